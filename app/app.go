@@ -15,6 +15,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/exchange"
 	"github.com/tony-tvu/goexpense/finances"
 	"github.com/tony-tvu/goexpense/jobs"
 	"github.com/tony-tvu/goexpense/middleware"
@@ -85,6 +86,18 @@ func (a *App) Initialize(ctx context.Context) {
 	} else {
 		jobs.TransactionsInterval = transactionsInterval
 	}
+	templatesInterval, err := strconv.Atoi(os.Getenv("TEMPLATES_INTERVAL"))
+	if err != nil {
+		jobs.TemplatesInterval = 3600 // 1 hour default
+	} else {
+		jobs.TemplatesInterval = templatesInterval
+	}
+	recurringInterval, err := strconv.Atoi(os.Getenv("RECURRING_INTERVAL"))
+	if err != nil {
+		jobs.RecurringInterval = 86400 // 24 hour default
+	} else {
+		jobs.RecurringInterval = recurringInterval
+	}
 	a.Jobs = jobs
 
 	// Handlers
@@ -111,10 +124,58 @@ func (a *App) Initialize(ctx context.Context) {
 		api.POST("/transactions", finances.CreateTransaction)
 		api.PATCH("/transactions", finances.UpdateTransaction)
 		api.DELETE("/transactions/:transaction_id", finances.DeleteTransaction)
+		api.PATCH("/transactions/:transaction_id/splits", finances.UpdateSplits)
+		api.PATCH("/transactions/:transaction_id/unlock", finances.UnlockTransaction)
+		api.PATCH("/transactions/:transaction_id/refund", finances.UpdateRefund)
+		api.PATCH("/transactions/:transaction_id/reimbursable", finances.UpdateReimbursable)
+		api.GET("/transactions/tags", finances.GetTags)
+		api.PATCH("/transactions/tags/add", finances.AddTags)
+		api.PATCH("/transactions/tags/remove", finances.RemoveTags)
 		api.GET("/accounts", finances.GetAccounts)
+		api.POST("/accounts", finances.CreateAccount)
+		api.GET("/import/profiles", finances.GetImportProfiles)
+		api.POST("/import/profiles", finances.SaveImportProfile)
+		api.DELETE("/import/profiles/:profile_id", finances.DeleteImportProfile)
+		api.POST("/import/csv", finances.ImportCSV)
+		api.POST("/import/ofx", finances.ImportOFX)
+		api.GET("/export", finances.Export)
+		api.GET("/summary", finances.GetSummary)
 		api.GET("/rules", finances.GetRules)
 		api.POST("/rules", finances.CreateRule)
+		api.POST("/rules/preview", finances.PreviewRule)
 		api.DELETE("/rules/:rule_id", finances.DeleteRule)
+		api.PATCH("/rules/order", finances.ReorderRules)
+		api.PATCH("/rules/:rule_id", finances.UpdateRule)
+		api.GET("/rules/conflicts", finances.GetRuleConflicts)
+		api.GET("/rules/suggestions", finances.GetRuleSuggestions)
+		api.POST("/rules/suggestions/accept", finances.AcceptRuleSuggestion)
+		api.POST("/rules/suggestions/dismiss", finances.DismissRuleSuggestion)
+		api.POST("/classifier/retrain", finances.RetrainClassifier)
+		api.GET("/merchants", finances.GetMerchants)
+		api.PATCH("/merchants", finances.UpdateMerchant)
+		api.GET("/recurring", finances.GetRecurring)
+		api.GET("/transfers", finances.GetTransfers)
+		api.PATCH("/transfers/:transfer_id/confirm", finances.ConfirmTransfer)
+		api.DELETE("/transfers/:transfer_id", finances.UnlinkTransfer)
+		api.GET("/reimbursements", finances.GetReimbursements)
+		api.POST("/reimbursements/reconcile", finances.ReconcileReimbursement)
+		api.DELETE("/reimbursements/:payment_id", finances.UnlinkReimbursement)
+		api.GET("/templates", finances.GetTemplates)
+		api.POST("/templates", finances.CreateTemplate)
+		api.PATCH("/templates/:template_id", finances.UpdateTemplate)
+		api.DELETE("/templates/:template_id", finances.DeleteTemplate)
+		api.GET("/categories", finances.GetCategories)
+		api.GET("/categories/totals", finances.GetCategoryTotals)
+		api.POST("/categories", finances.CreateCategory)
+		api.PATCH("/categories", finances.EditCategory)
+		api.DELETE("/categories/:category_id", finances.DeleteCategory)
+		api.GET("/budgets", finances.GetBudgets)
+		api.GET("/budgets/progress", finances.GetBudgetProgress)
+		api.POST("/budgets", finances.CreateBudget)
+		api.PATCH("/budgets", finances.UpdateBudget)
+		api.DELETE("/budgets/:budget_id", finances.DeleteBudget)
+		api.GET("/budgets/envelopes", finances.GetEnvelopes)
+		api.POST("/budgets/envelopes/recompute", finances.RecomputeEnvelopes)
 
 		// teller
 		api.POST("/enrollments", teller.NewEnrollment)
@@ -126,6 +187,7 @@ func (a *App) Initialize(ctx context.Context) {
 		api.POST("/login", middleware.LoginRateLimit(), users.Login)
 		api.GET("/logged_in", users.IsLoggedIn)
 		api.GET("/user_info", users.GetUserInfo)
+		api.PATCH("/user_info", users.UpdateUserInfo)
 		api.POST("/register", users.RegisterUser)
 	}
 
@@ -154,6 +216,17 @@ func (a *App) Start(ctx context.Context) {
 	}()
 	a.Db.SetCollections(mongoclient, dbName)
 	a.Db.CreateUniqueConstraints(ctx)
+	a.Db.RunMigrations(ctx)
+
+	// Load offline exchange rates
+	if ratesFile := os.Getenv("EXCHANGE_RATES_FILE"); ratesFile != "" {
+		count, err := exchange.LoadFile(ctx, a.Db, ratesFile)
+		if err != nil {
+			log.Printf("error loading exchange rates from %s: %v", ratesFile, err)
+		} else {
+			log.Printf("loaded %d exchange rates from %s\n", count, ratesFile)
+		}
+	}
 
 	// Start scheduled jobs
 	a.Jobs.Start(ctx)
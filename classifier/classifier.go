@@ -0,0 +1,152 @@
+package classifier
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/tony-tvu/goexpense/money"
+)
+
+// Upper bounds in whole currency units of the amount buckets used as features
+var amountBuckets = []int64{10, 25, 50, 100, 250, 500, 1000}
+
+// Example is a categorized transaction the model learns from
+type Example struct {
+	Name     string
+	Amount   money.Amount
+	Category string
+}
+
+// Class holds feature counts for one category
+type Class struct {
+	Category  string         `json:"category" bson:"category"`
+	Documents int            `json:"documents" bson:"documents"`
+	Features  map[string]int `json:"features" bson:"features"`
+	Total     int            `json:"total" bson:"total"`
+}
+
+// Model is a multinomial naive Bayes classifier over transaction names and amounts
+type Model struct {
+	Classes    []*Class `json:"classes" bson:"classes"`
+	Documents  int      `json:"documents" bson:"documents"`
+	Vocabulary int      `json:"vocabulary" bson:"vocabulary"`
+}
+
+// Splits a transaction name into lowercase words, dropping numbers and single characters
+// since store numbers and card suffixes say nothing about the category
+func Tokenize(name string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := []string{}
+	for _, field := range fields {
+		if len(field) < 2 || strings.IndexFunc(field, unicode.IsLetter) < 0 {
+			continue
+		}
+		tokens = append(tokens, field)
+	}
+	return tokens
+}
+
+// Returns a feature for the size and direction of an amount
+func AmountBucket(amount money.Amount) string {
+	sign := "out"
+	if amount > 0 {
+		sign = "in"
+	}
+	units := int64(amount.Abs()) / 100
+	bucket := len(amountBuckets)
+	for i, bound := range amountBuckets {
+		if units < bound {
+			bucket = i
+			break
+		}
+	}
+	return "amount:" + sign + ":" + strconv.Itoa(bucket)
+}
+
+// Returns every feature of a transaction
+func Features(name string, amount money.Amount) []string {
+	return append(Tokenize(name), AmountBucket(amount))
+}
+
+// Trains a model from categorized transactions
+func Train(examples []*Example) *Model {
+	classes := map[string]*Class{}
+	vocabulary := map[string]bool{}
+	for _, example := range examples {
+		class, ok := classes[example.Category]
+		if !ok {
+			class = &Class{Category: example.Category, Features: map[string]int{}}
+			classes[example.Category] = class
+		}
+		class.Documents++
+		for _, feature := range Features(example.Name, example.Amount) {
+			class.Features[feature]++
+			class.Total++
+			vocabulary[feature] = true
+		}
+	}
+
+	model := &Model{Classes: []*Class{}, Documents: len(examples), Vocabulary: len(vocabulary)}
+	for _, class := range classes {
+		model.Classes = append(model.Classes, class)
+	}
+	sort.Slice(model.Classes, func(i, j int) bool { return model.Classes[i].Category < model.Classes[j].Category })
+	return model
+}
+
+func (m *Model) known(feature string) bool {
+	for _, class := range m.Classes {
+		if _, ok := class.Features[feature]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the most likely category for a transaction and its probability between 0 and 1.
+// Models with fewer than two categories and names with no known words return no category,
+// the amount alone isn't enough to go on.
+func (m *Model) Predict(name string, amount money.Amount) (string, float64) {
+	if m == nil || len(m.Classes) < 2 {
+		return "", 0
+	}
+
+	features := []string{}
+	for _, token := range Tokenize(name) {
+		if m.known(token) {
+			features = append(features, token)
+		}
+	}
+	if len(features) == 0 {
+		return "", 0
+	}
+	if bucket := AmountBucket(amount); m.known(bucket) {
+		features = append(features, bucket)
+	}
+
+	// log probabilities with add-one smoothing
+	scores := make([]float64, len(m.Classes))
+	best := 0
+	for i, class := range m.Classes {
+		score := math.Log(float64(class.Documents) / float64(m.Documents))
+		for _, feature := range features {
+			score += math.Log(float64(class.Features[feature]+1) / float64(class.Total+m.Vocabulary))
+		}
+		scores[i] = score
+		if score > scores[best] {
+			best = i
+		}
+	}
+
+	// normalize into a probability
+	var sum float64
+	for _, score := range scores {
+		sum += math.Exp(score - scores[best])
+	}
+	return m.Classes[best].Category, 1 / sum
+}
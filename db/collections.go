@@ -10,20 +10,46 @@ import (
 )
 
 type MongoDb struct {
-	Accounts     *mongo.Collection
-	Enrollments  *mongo.Collection
-	Rules        *mongo.Collection
-	Sessions     *mongo.Collection
-	Transactions *mongo.Collection
-	Users        *mongo.Collection
+	Accounts             *mongo.Collection
+	Budgets              *mongo.Collection
+	Categories           *mongo.Collection
+	Classifiers          *mongo.Collection
+	DismissedSuggestions *mongo.Collection
+	Enrollments          *mongo.Collection
+	Envelopes            *mongo.Collection
+	ExchangeRates        *mongo.Collection
+	ImportProfiles       *mongo.Collection
+	Merchants            *mongo.Collection
+	Migrations           *mongo.Collection
+	Recategorizations    *mongo.Collection
+	Recurring            *mongo.Collection
+	Rules                *mongo.Collection
+	Sessions             *mongo.Collection
+	Templates            *mongo.Collection
+	Transactions         *mongo.Collection
+	Transfers            *mongo.Collection
+	Users                *mongo.Collection
 }
 
 func (db *MongoDb) SetCollections(client *mongo.Client, dbName string) {
 	db.Accounts = client.Database(dbName).Collection("accounts")
+	db.Budgets = client.Database(dbName).Collection("budgets")
+	db.Categories = client.Database(dbName).Collection("categories")
+	db.Classifiers = client.Database(dbName).Collection("classifiers")
+	db.DismissedSuggestions = client.Database(dbName).Collection("dismissed_suggestions")
 	db.Enrollments = client.Database(dbName).Collection("enrollments")
+	db.Envelopes = client.Database(dbName).Collection("envelopes")
+	db.ExchangeRates = client.Database(dbName).Collection("exchange_rates")
+	db.ImportProfiles = client.Database(dbName).Collection("import_profiles")
+	db.Merchants = client.Database(dbName).Collection("merchants")
+	db.Migrations = client.Database(dbName).Collection("migrations")
+	db.Recategorizations = client.Database(dbName).Collection("recategorizations")
+	db.Recurring = client.Database(dbName).Collection("recurring")
 	db.Rules = client.Database(dbName).Collection("rules")
 	db.Sessions = client.Database(dbName).Collection("sessions")
+	db.Templates = client.Database(dbName).Collection("templates")
 	db.Transactions = client.Database(dbName).Collection("transactions")
+	db.Transfers = client.Database(dbName).Collection("transfers")
 	db.Users = client.Database(dbName).Collection("users")
 }
 
@@ -68,4 +94,141 @@ func (db *MongoDb) CreateUniqueConstraints(ctx context.Context) {
 	); err != nil {
 		log.Fatal(err)
 	}
+	if _, err := db.Categories.Indexes().CreateOne(
+		ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Budgets.Indexes().CreateOne(
+		ctx, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "user_id", Value: 1},
+				{Key: "category", Value: 1},
+				{Key: "year", Value: 1},
+				{Key: "month", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+	); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Envelopes.Indexes().CreateOne(
+		ctx, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "user_id", Value: 1},
+				{Key: "category", Value: 1},
+				{Key: "year", Value: 1},
+				{Key: "month", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+	); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Transactions.Indexes().CreateMany(
+		ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "date", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "category", Value: 1}}},
+		},
+	); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.ExchangeRates.Indexes().CreateOne(
+		ctx, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "base", Value: 1},
+				{Key: "currency", Value: 1},
+				{Key: "date", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+	); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Classifiers.Indexes().CreateOne(
+		ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Recategorizations.Indexes().CreateOne(
+		ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}},
+		},
+	); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.DismissedSuggestions.Indexes().CreateOne(
+		ctx, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "user_id", Value: 1},
+				{Key: "substring", Value: 1},
+				{Key: "category", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+	); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Merchants.Indexes().CreateOne(
+		ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Transactions.Indexes().CreateOne(
+		ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "merchant", Value: 1}},
+		},
+	); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Recurring.Indexes().CreateOne(
+		ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "next_date", Value: 1}},
+		},
+	); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Templates.Indexes().CreateMany(
+		ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "next_date", Value: 1}}},
+			{Keys: bson.D{{Key: "paused", Value: 1}, {Key: "next_date", Value: 1}}},
+		},
+	); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Transfers.Indexes().CreateOne(
+		ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "status", Value: 1}},
+		},
+	); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.ImportProfiles.Indexes().CreateOne(
+		ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	); err != nil {
+		log.Fatal(err)
+	}
+	// series saved before they had keys are replaced the next time they're detected
+	if _, err := db.Recurring.Indexes().CreateOne(
+		ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"key": bson.M{"$exists": true}}),
+		},
+	); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,230 @@
+package db
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/tony-tvu/goexpense/merchant"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/rules"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type migration struct {
+	Name string
+	Run  func(ctx context.Context, db *MongoDb) error
+}
+
+// Migrations run once in order and are recorded in the migrations collection
+var migrations = []migration{
+	{Name: "amounts_to_minor_units", Run: amountsToMinorUnits},
+	{Name: "transaction_currencies", Run: transactionCurrencies},
+	{Name: "rule_conditions", Run: ruleConditions},
+	{Name: "rule_priorities", Run: rulePriorities},
+	{Name: "transaction_originals", Run: transactionOriginals},
+	{Name: "transaction_merchants", Run: transactionMerchants},
+}
+
+func (db *MongoDb) RunMigrations(ctx context.Context) {
+	for _, m := range migrations {
+		count, err := db.Migrations.CountDocuments(ctx, bson.M{"name": m.Name})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		log.Printf("running migration %s\n", m.Name)
+		if err = m.Run(ctx, db); err != nil {
+			log.Fatalf("error running migration %s: %v", m.Name, err)
+		}
+
+		doc := bson.D{
+			{Key: "name", Value: m.Name},
+			{Key: "applied_at", Value: time.Now()},
+		}
+		if _, err = db.Migrations.InsertOne(ctx, doc); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// Converts float amounts into integer minor units, e.g. 12.34 becomes 1234.
+// Only double values are touched so running it twice is harmless.
+func amountsToMinorUnits(ctx context.Context, db *MongoDb) error {
+	fields := []struct {
+		collection *mongo.Collection
+		field      string
+	}{
+		{db.Transactions, "amount"},
+		{db.Accounts, "balance"},
+		{db.Budgets, "amount"},
+		{db.Envelopes, "carried_in"},
+		{db.Envelopes, "budgeted"},
+		{db.Envelopes, "spent"},
+		{db.Envelopes, "closing"},
+	}
+
+	for _, f := range fields {
+		update := mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				f.field: bson.M{"$toLong": bson.M{"$round": bson.A{bson.M{"$multiply": bson.A{"$" + f.field, 100}}, 0}}},
+			}}},
+		}
+		_, err := f.collection.UpdateMany(ctx, bson.M{f.field: bson.M{"$type": "double"}}, update)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sets the currency of existing transactions to the currency of their account
+func transactionCurrencies(ctx context.Context, db *MongoDb) error {
+	var accounts []struct {
+		AccountID string `bson:"account_id"`
+		Currency  string `bson:"currency"`
+	}
+	cursor, err := db.Accounts.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(ctx, &accounts); err != nil {
+		return err
+	}
+
+	for _, account := range accounts {
+		if account.Currency == "" {
+			continue
+		}
+		_, err = db.Transactions.UpdateMany(
+			ctx,
+			bson.M{"account_id": account.AccountID, "currency": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"currency": account.Currency}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	// manually created transactions
+	_, err = db.Transactions.UpdateMany(
+		ctx,
+		bson.M{"currency": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"currency": money.DefaultCurrency}},
+	)
+	return err
+}
+
+// Converts substring rules into a name condition with set category and add tag actions
+func ruleConditions(ctx context.Context, db *MongoDb) error {
+	var legacy []struct {
+		ID        interface{} `bson:"_id"`
+		Substring string      `bson:"substring"`
+		Category  string      `bson:"category"`
+		Tags      []string    `bson:"tags"`
+	}
+	cursor, err := db.Rules.Find(ctx, bson.M{"substring": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(ctx, &legacy); err != nil {
+		return err
+	}
+
+	for _, rule := range legacy {
+		actions := []*rules.Action{}
+		if rule.Category != "" {
+			actions = append(actions, &rules.Action{Type: rules.SetCategory, Value: rule.Category})
+		}
+		for _, tag := range rule.Tags {
+			actions = append(actions, &rules.Action{Type: rules.AddTag, Value: tag})
+		}
+
+		_, err = db.Rules.UpdateOne(ctx, bson.M{"_id": rule.ID}, bson.M{
+			"$set": bson.M{
+				"match":      rules.MatchAll,
+				"conditions": []*rules.Condition{{Field: rules.FieldName, Op: rules.OpContains, Value: rule.Substring}},
+				"actions":    actions,
+				"updated_at": time.Now(),
+			},
+			"$unset": bson.M{"substring": "", "category": "", "tags": ""},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Numbers each user's existing rules newest first. Rules used to all run in the order they were
+// created, so the newest matching rule had the last word, and first match wins now.
+func rulePriorities(ctx context.Context, db *MongoDb) error {
+	var existing []struct {
+		ID     primitive.ObjectID `bson:"_id"`
+		UserID primitive.ObjectID `bson:"user_id"`
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := db.Rules.Find(ctx, bson.M{"priority": bson.M{"$exists": false}}, opts)
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(ctx, &existing); err != nil {
+		return err
+	}
+
+	priorities := map[primitive.ObjectID]int{}
+	for _, rule := range existing {
+		priorities[rule.UserID]++
+		_, err = db.Rules.UpdateOne(ctx, bson.M{"_id": rule.ID}, bson.M{"$set": bson.M{"priority": priorities[rule.UserID]}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Treats the current category and name of existing transactions as their originals
+func transactionOriginals(ctx context.Context, db *MongoDb) error {
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"original_category": "$category",
+			"original_name":     "$name",
+		}}},
+	}
+	_, err := db.Transactions.UpdateMany(ctx, bson.M{"original_category": bson.M{"$exists": false}}, update)
+	return err
+}
+
+// Fills in the merchant of existing transactions from their original name
+func transactionMerchants(ctx context.Context, db *MongoDb) error {
+	var existing []struct {
+		ID           primitive.ObjectID `bson:"_id"`
+		Name         string             `bson:"name"`
+		OriginalName string             `bson:"original_name"`
+	}
+	cursor, err := db.Transactions.Find(ctx, bson.M{"merchant": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(ctx, &existing); err != nil {
+		return err
+	}
+
+	for _, t := range existing {
+		name := t.OriginalName
+		if name == "" {
+			name = t.Name
+		}
+		_, err = db.Transactions.UpdateOne(ctx, bson.M{"_id": t.ID}, bson.M{"$set": bson.M{"merchant": merchant.Normalize(name)}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
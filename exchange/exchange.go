@@ -0,0 +1,261 @@
+package exchange
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Rate is how many units of Currency one unit of Base buys on Date
+type Rate struct {
+	Base     string    `json:"base" bson:"base"`
+	Currency string    `json:"currency" bson:"currency"`
+	Date     time.Time `json:"date" bson:"date"`
+	Rate     float64   `json:"rate" bson:"rate"`
+}
+
+// Parses a CSV file with a date,base,currency,rate header, dates are YYYY-MM-DD
+func ParseCSV(r io.Reader) ([]*Rate, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("empty exchange rates file")
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"date", "base", "currency", "rate"} {
+		if _, ok := columns[name]; !ok {
+			return nil, errors.New("exchange rates file is missing column " + name)
+		}
+	}
+
+	rates := []*Rate{}
+	for _, record := range records[1:] {
+		date, err := time.Parse("2006-01-02", record[columns["date"]])
+		if err != nil {
+			return nil, err
+		}
+		rate, err := strconv.ParseFloat(record[columns["rate"]], 64)
+		if err != nil || rate <= 0 {
+			return nil, errors.New("invalid rate " + record[columns["rate"]])
+		}
+		rates = append(rates, &Rate{
+			Base:     strings.ToUpper(record[columns["base"]]),
+			Currency: strings.ToUpper(record[columns["currency"]]),
+			Date:     date,
+			Rate:     rate,
+		})
+	}
+	return rates, nil
+}
+
+// Parses the European Central Bank's euro reference rates XML (eurofxref-daily.xml or eurofxref-hist.xml)
+func ParseECB(r io.Reader) ([]*Rate, error) {
+	var envelope struct {
+		Cube struct {
+			Days []struct {
+				Time  string `xml:"time,attr"`
+				Rates []struct {
+					Currency string `xml:"currency,attr"`
+					Rate     string `xml:"rate,attr"`
+				} `xml:"Cube"`
+			} `xml:"Cube"`
+		} `xml:"Cube"`
+	}
+	if err := xml.NewDecoder(r).Decode(&envelope); err != nil {
+		return nil, err
+	}
+
+	rates := []*Rate{}
+	for _, day := range envelope.Cube.Days {
+		date, err := time.Parse("2006-01-02", day.Time)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range day.Rates {
+			rate, err := strconv.ParseFloat(r.Rate, 64)
+			if err != nil || rate <= 0 {
+				return nil, errors.New("invalid rate " + r.Rate)
+			}
+			rates = append(rates, &Rate{Base: "EUR", Currency: r.Currency, Date: date, Rate: rate})
+		}
+	}
+	return rates, nil
+}
+
+// Saves rates from a local .csv or .xml file, existing rates for the same day are replaced
+func LoadFile(ctx context.Context, db *db.MongoDb, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var rates []*Rate
+	if strings.ToLower(filepath.Ext(path)) == ".xml" {
+		rates, err = ParseECB(f)
+	} else {
+		rates, err = ParseCSV(f)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, rate := range rates {
+		_, err = db.ExchangeRates.UpdateOne(
+			ctx,
+			bson.M{"base": rate.Base, "currency": rate.Currency, "date": rate.Date},
+			bson.M{"$set": bson.M{"rate": rate.Rate}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(rates), nil
+}
+
+// Table converts between currencies using the most recent rate on or before a date
+type Table struct {
+	base  string
+	rates map[string][]*Rate
+}
+
+// Builds a table from rates against the given base currency, other rates are skipped
+func NewTable(base string, rates []*Rate) *Table {
+	t := &Table{base: base, rates: map[string][]*Rate{}}
+	for _, rate := range rates {
+		if rate.Base == base {
+			t.rates[rate.Currency] = append(t.rates[rate.Currency], rate)
+		}
+	}
+	for _, rates := range t.rates {
+		sort.Slice(rates, func(i, j int) bool { return rates[i].Date.Before(rates[j].Date) })
+	}
+	return t
+}
+
+// Returns the rate of a currency against the table's base, falling back to the earliest known rate
+func (t *Table) rate(currency string, date time.Time) (float64, bool) {
+	if currency == t.base {
+		return 1, true
+	}
+	rates := t.rates[currency]
+	if len(rates) == 0 {
+		return 0, false
+	}
+	i := sort.Search(len(rates), func(i int) bool { return rates[i].Date.After(date) })
+	if i == 0 {
+		return rates[0].Rate, true
+	}
+	return rates[i-1].Rate, true
+}
+
+// Converts an amount between currencies at the given date.
+// Returns false if either currency has no known rate.
+func (t *Table) Convert(amount money.Amount, from, to string, date time.Time) (money.Amount, bool) {
+	if from == to || from == "" || to == "" {
+		return amount, true
+	}
+	fromRate, ok := t.rate(from, date)
+	if !ok {
+		return amount, false
+	}
+	toRate, ok := t.rate(to, date)
+	if !ok {
+		return amount, false
+	}
+	return money.FromFloat(amount.Float() / fromRate * toRate), true
+}
+
+// Loads a table with every rate needed to convert the given currencies between two dates.
+// Rates from two weeks before since are included to cover weekends and holidays.
+func LoadTable(ctx context.Context, db *db.MongoDb, currencies []string, to string, since, until time.Time) (*Table, error) {
+	needed := []string{}
+	for _, currency := range currencies {
+		if currency != "" && currency != to && !util.Contains(&needed, currency) {
+			needed = append(needed, currency)
+		}
+	}
+	if len(needed) == 0 {
+		return NewTable(to, nil), nil
+	}
+	needed = append(needed, to)
+
+	base, err := tableBase(ctx, db, to)
+	if err != nil {
+		return nil, err
+	}
+	if base == "" {
+		return NewTable(to, nil), nil
+	}
+
+	dateFilter := bson.M{"$lte": until}
+	if !since.IsZero() {
+		dateFilter["$gte"] = since.AddDate(0, 0, -14)
+	}
+	var rates []*Rate
+	cursor, err := db.ExchangeRates.Find(ctx, bson.M{
+		"base":     base,
+		"currency": bson.M{"$in": needed},
+		"date":     dateFilter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &rates); err != nil {
+		return nil, err
+	}
+	return NewTable(base, rates), nil
+}
+
+// Returns the base to convert to a currency through. Rates quoted against the currency itself
+// are used when there are any, otherwise the first base that has rates for it. Returns an
+// empty string when no base can reach the currency.
+func tableBase(ctx context.Context, db *db.MongoDb, to string) (string, error) {
+	values, err := db.ExchangeRates.Distinct(ctx, "base", bson.M{})
+	if err != nil {
+		return "", err
+	}
+	bases := []string{}
+	for _, value := range values {
+		if base, ok := value.(string); ok {
+			bases = append(bases, base)
+		}
+	}
+	if util.Contains(&bases, to) {
+		return to, nil
+	}
+	sort.Strings(bases)
+	for _, base := range bases {
+		count, err := db.ExchangeRates.CountDocuments(ctx, bson.M{"base": base, "currency": to}, options.Count().SetLimit(1))
+		if err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return base, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,377 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tony-tvu/goexpense/money"
+)
+
+// Export formats
+const (
+	CSV  = "csv"
+	JSON = "json"
+	OFX  = "ofx"
+	QIF  = "qif"
+)
+
+var ErrUnknownFormat = errors.New("unknown format")
+
+// Record is one exported transaction along with its account
+type Record struct {
+	TransactionID string       `json:"transaction_id"`
+	Date          time.Time    `json:"date"`
+	Name          string       `json:"name"`
+	Merchant      string       `json:"merchant"`
+	Category      string       `json:"category"`
+	Amount        money.Amount `json:"amount"`
+	Currency      string       `json:"currency"`
+	Tags          []string     `json:"tags"`
+	Notes         string       `json:"notes"`
+	Splits        []*Split     `json:"splits,omitempty"`
+
+	AccountID   string `json:"account_id"`
+	AccountName string `json:"account_name"`
+	AccountType string `json:"account_type"`
+	Institution string `json:"institution"`
+}
+
+// Split is the part of an exported transaction in one category
+type Split struct {
+	Category string       `json:"category"`
+	Amount   money.Amount `json:"amount"`
+	Note     string       `json:"note"`
+}
+
+// Account is the account statement formats group records under. The balance is only
+// given for statements that run up to today.
+type Account struct {
+	ID       string
+	Type     string
+	Currency string
+	Balance  *money.Amount
+}
+
+// Writer writes records one at a time so exports don't have to be held in memory.
+// Close writes whatever the format needs after the last record.
+type Writer interface {
+	Write(r *Record) error
+	Close() error
+}
+
+// Returns true for formats that group records by account, which need them ordered by account
+func ByAccount(format string) bool {
+	return format == OFX || format == QIF
+}
+
+// Returns the content type of a format
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv"
+	case JSON:
+		return "application/json"
+	case OFX:
+		return "application/x-ofx"
+	default:
+		return "application/qif"
+	}
+}
+
+// Returns a writer for a format. accounts are looked up by id for statement formats, and
+// from and to are the date range the statements cover.
+func NewWriter(format string, w io.Writer, accounts map[string]*Account, from, to time.Time) (Writer, error) {
+	switch format {
+	case CSV:
+		return newCSVWriter(w), nil
+	case JSON:
+		return &jsonWriter{w: w}, nil
+	case OFX:
+		return &ofxWriter{w: w, accounts: accounts, from: from, to: to}, nil
+	case QIF:
+		return &qifWriter{w: w}, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Returns the categories of a record, every split's for split transactions
+func (r *Record) categories() string {
+	if len(r.Splits) == 0 {
+		return r.Category
+	}
+	categories := []string{}
+	for _, split := range r.Splits {
+		categories = append(categories, split.Category)
+	}
+	return strings.Join(categories, "; ")
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (cw *csvWriter) Write(r *Record) error {
+	if !cw.header {
+		cw.header = true
+		if err := cw.w.Write([]string{
+			"date", "name", "merchant", "category", "amount", "currency", "tags", "notes",
+			"account", "institution", "transaction_id",
+		}); err != nil {
+			return err
+		}
+	}
+	return cw.w.Write([]string{
+		r.Date.Format("2006-01-02"),
+		r.Name,
+		r.Merchant,
+		r.categories(),
+		r.Amount.String(),
+		r.Currency,
+		strings.Join(r.Tags, "; "),
+		r.Notes,
+		r.AccountName,
+		r.Institution,
+		r.TransactionID,
+	})
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// writes a JSON array one element at a time
+type jsonWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (jw *jsonWriter) Write(r *Record) error {
+	prefix := ","
+	if !jw.started {
+		jw.started, prefix = true, "["
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(jw.w, "%s%s\n", prefix, b)
+	return err
+}
+
+func (jw *jsonWriter) Close() error {
+	if !jw.started {
+		_, err := io.WriteString(jw.w, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(jw.w, "]\n")
+	return err
+}
+
+// Returns the memo statement formats carry the category and tags in, since they have no fields for them
+func (r *Record) memo() string {
+	parts := []string{}
+	if category := r.categories(); category != "" {
+		parts = append(parts, category)
+	}
+	for _, tag := range r.Tags {
+		parts = append(parts, "#"+tag)
+	}
+	if r.Notes != "" {
+		parts = append(parts, r.Notes)
+	}
+	return strings.Join(parts, " ")
+}
+
+// writes an OFX 2 document with a statement per account
+type ofxWriter struct {
+	w        io.Writer
+	accounts map[string]*Account
+	from, to time.Time
+
+	started bool
+	current *Account
+	err     error
+}
+
+func (ow *ofxWriter) printf(format string, args ...interface{}) {
+	if ow.err == nil {
+		_, ow.err = fmt.Fprintf(ow.w, format, args...)
+	}
+}
+
+func (ow *ofxWriter) start() {
+	if ow.started {
+		return
+	}
+	ow.started = true
+	ow.printf("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	ow.printf("<?OFX OFXHEADER=\"200\" VERSION=\"220\" SECURITY=\"NONE\" OLDFILEUID=\"NONE\" NEWFILEUID=\"NONE\"?>\n")
+	ow.printf("<OFX>\n<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>")
+	ow.printf("<DTSERVER>%s</DTSERVER><LANGUAGE>ENG</LANGUAGE></SONRS></SIGNONMSGSRSV1>\n", ofxDate(time.Now()))
+}
+
+func (ow *ofxWriter) Write(r *Record) error {
+	ow.start()
+	if ow.current == nil || ow.current.ID != r.AccountID {
+		ow.endStatement()
+		ow.startStatement(r)
+	}
+
+	trnType := "DEBIT"
+	if r.Amount > 0 {
+		trnType = "CREDIT"
+	}
+	ow.printf("<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s</TRNAMT><FITID>%s</FITID><NAME>%s</NAME>",
+		trnType, ofxDate(r.Date), r.Amount.String(), escape(r.TransactionID), escape(truncate(r.Name, 32)))
+	if memo := r.memo(); memo != "" {
+		ow.printf("<MEMO>%s</MEMO>", escape(truncate(memo, 255)))
+	}
+	ow.printf("</STMTTRN>\n")
+	return ow.err
+}
+
+// Returns the account a record belongs to, transactions without one are grouped under their account id
+func (ow *ofxWriter) account(r *Record) *Account {
+	if account, ok := ow.accounts[r.AccountID]; ok {
+		return account
+	}
+	return &Account{ID: r.AccountID, Type: r.AccountType, Currency: r.Currency}
+}
+
+func (ow *ofxWriter) isCredit() bool {
+	return ow.current.Type == "credit"
+}
+
+func (ow *ofxWriter) startStatement(r *Record) {
+	ow.current = ow.account(r)
+	currency := ow.current.Currency
+	if currency == "" {
+		currency = r.Currency
+	}
+	if ow.isCredit() {
+		ow.printf("<CREDITCARDMSGSRSV1><CCSTMTTRNRS><TRNUID>0</TRNUID><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>\n")
+		ow.printf("<CCSTMTRS><CURDEF>%s</CURDEF><CCACCTFROM><ACCTID>%s</ACCTID></CCACCTFROM>\n", escape(currency), escape(ow.current.ID))
+	} else {
+		ow.printf("<BANKMSGSRSV1><STMTTRNRS><TRNUID>0</TRNUID><STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>\n")
+		ow.printf("<STMTRS><CURDEF>%s</CURDEF><BANKACCTFROM><BANKID>000000000</BANKID><ACCTID>%s</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>\n",
+			escape(currency), escape(ow.current.ID))
+	}
+	ow.printf("<BANKTRANLIST><DTSTART>%s</DTSTART><DTEND>%s</DTEND>\n", ofxDate(ow.from), ofxDate(ow.to))
+}
+
+func (ow *ofxWriter) endStatement() {
+	if ow.current == nil {
+		return
+	}
+	ow.printf("</BANKTRANLIST>\n")
+	if ow.current.Balance != nil {
+		ow.printf("<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>\n", ow.current.Balance.String(), ofxDate(ow.to))
+	}
+	if ow.isCredit() {
+		ow.printf("</CCSTMTRS></CCSTMTTRNRS></CREDITCARDMSGSRSV1>\n")
+	} else {
+		ow.printf("</STMTRS></STMTTRNRS></BANKMSGSRSV1>\n")
+	}
+}
+
+// an export without transactions is still a document, just without statements
+func (ow *ofxWriter) Close() error {
+	ow.start()
+	ow.endStatement()
+	ow.printf("</OFX>\n")
+	return ow.err
+}
+
+// writes QIF with an account block before each account's transactions
+type qifWriter struct {
+	w         io.Writer
+	accountID *string
+	err       error
+}
+
+func (qw *qifWriter) printf(format string, args ...interface{}) {
+	if qw.err == nil {
+		_, qw.err = fmt.Fprintf(qw.w, format, args...)
+	}
+}
+
+func (qw *qifWriter) Write(r *Record) error {
+	if qw.accountID == nil || *qw.accountID != r.AccountID {
+		qw.accountID = &r.AccountID
+		qifType := "Bank"
+		if r.AccountType == "credit" {
+			qifType = "CCard"
+		}
+		name := r.AccountName
+		if name == "" {
+			name = r.AccountID
+		}
+		qw.printf("!Account\nN%s\nT%s\n", qifLine(name), qifType)
+		if r.Institution != "" {
+			qw.printf("D%s\n", qifLine(r.Institution))
+		}
+		qw.printf("^\n!Type:%s\n", qifType)
+	}
+
+	qw.printf("D%s\nT%s\nP%s\n", r.Date.Format("01/02/2006"), r.Amount.String(), qifLine(r.Name))
+	if len(r.Splits) == 0 && r.Category != "" {
+		qw.printf("L%s\n", qifLine(r.Category))
+	}
+	memo := r.Notes
+	for _, tag := range r.Tags {
+		memo = strings.TrimSpace(memo + " #" + tag)
+	}
+	if memo != "" {
+		qw.printf("M%s\n", qifLine(memo))
+	}
+	// split amounts are signed like the transaction
+	for _, split := range r.Splits {
+		amount := split.Amount.Abs()
+		if r.Amount < 0 {
+			amount = -amount
+		}
+		qw.printf("S%s\n$%s\n", qifLine(split.Category), amount.String())
+		if split.Note != "" {
+			qw.printf("E%s\n", qifLine(split.Note))
+		}
+	}
+	qw.printf("^\n")
+	return qw.err
+}
+
+func (qw *qifWriter) Close() error {
+	return qw.err
+}
+
+func ofxDate(t time.Time) string {
+	return t.UTC().Format("20060102")
+}
+
+func escape(s string) string {
+	return html.EscapeString(s)
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) > max {
+		return string(runes[:max])
+	}
+	return s
+}
+
+// QIF fields are one line each
+func qifLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
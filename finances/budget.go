@@ -0,0 +1,430 @@
+package finances
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Recurring budgets apply to every month and have Month and Year set to 0
+type Budget struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id"`
+	UserID primitive.ObjectID `json:"user_id" bson:"user_id"`
+
+	Category  string       `json:"category" bson:"category"`
+	Amount    money.Amount `json:"amount" bson:"amount"`
+	Month     int          `json:"month" bson:"month"`
+	Year      int          `json:"year" bson:"year"`
+	Recurring bool         `json:"recurring" bson:"recurring"`
+	Rollover  bool         `json:"rollover" bson:"rollover"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+type BudgetProgress struct {
+	Category  string       `json:"category"`
+	Path      string       `json:"path"`
+	Recurring bool         `json:"recurring"`
+	Rollover  bool         `json:"rollover"`
+	Budgeted  money.Amount `json:"budgeted"`
+	CarriedIn money.Amount `json:"carried_in"`
+	Spent     money.Amount `json:"spent"`
+	Remaining money.Amount `json:"remaining"`
+}
+
+// Returns the budgets that apply to a month, month specific budgets replace recurring ones
+func EffectiveBudgets(budgets []*Budget, month, year int) map[string]*Budget {
+	effective := map[string]*Budget{}
+	for _, budget := range budgets {
+		if budget.Recurring {
+			if _, ok := effective[budget.Category]; !ok {
+				effective[budget.Category] = budget
+			}
+		} else if budget.Month == month && budget.Year == year {
+			effective[budget.Category] = budget
+		}
+	}
+	return effective
+}
+
+// Returns money spent per expense category between two dates with children rolled up into parents.
+// Income and ignore categories are excluded the same way NormalizeAmount treats them.
+func (h *Handler) categorySpending(ctx context.Context, userID *primitive.ObjectID, categories CategorySet, fromDate, toDate time.Time) (map[string]money.Amount, error) {
+	pipeline := totalsStages(bson.M{
+		"user_id": *userID,
+		"date":    bson.M{"$gte": fromDate, "$lt": toDate},
+	})
+	pipeline = append(pipeline, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"category": bson.M{"$in": categories.NamesOfKind(Expense)}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.M{"category": "$category", "currency": "$currency", "date": "$date"}},
+			{Key: "amount", Value: bson.M{"$sum": "$amount"}},
+		}}},
+	}...)
+	cursor, err := h.Db.Transactions.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		ID struct {
+			Category string    `bson:"category"`
+			Currency string    `bson:"currency"`
+			Date     time.Time `bson:"date"`
+		} `bson:"_id"`
+		Amount money.Amount `bson:"amount"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	converter, err := h.newConverter(ctx, userID, fromDate, toDate)
+	if err != nil {
+		return nil, err
+	}
+
+	// expenses are stored as negative amounts
+	spent := map[string]money.Amount{}
+	for _, result := range results {
+		spent[result.ID.Category] -= converter.Convert(result.Amount, result.ID.Currency, result.ID.Date)
+	}
+	return categories.Rollup(spent), nil
+}
+
+func hasBudgetedAncestor(categories CategorySet, budgets map[string]*Budget, category string) bool {
+	for _, ancestor := range categories.Ancestors(category) {
+		if _, ok := budgets[ancestor]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) getBudgets(ctx context.Context, userID *primitive.ObjectID) ([]*Budget, error) {
+	var budgets []*Budget
+	opts := options.Find().SetSort(bson.D{{Key: "category", Value: 1}, {Key: "year", Value: -1}, {Key: "month", Value: -1}})
+	cursor, err := h.Db.Budgets.Find(ctx, bson.M{"user_id": *userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &budgets); err != nil {
+		return nil, err
+	}
+	return budgets, nil
+}
+
+// Parses and validates the budget fields shared by create and update
+func parseBudgetInput(categories CategorySet, category, amountStr string, month, year int, recurring bool) (money.Amount, bool) {
+	if categories.Kind(category) != Expense || !categories.Contains(category) {
+		return 0, false
+	}
+	if !recurring && (month < 1 || month > 12 || year < 1) {
+		return 0, false
+	}
+	amount, err := money.Parse(amountStr)
+	if err != nil || amount <= 0 {
+		return 0, false
+	}
+	return amount, true
+}
+
+func (h *Handler) GetBudgets(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	budgets, err := h.getBudgets(ctx, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"budgets": budgets,
+	})
+}
+
+func (h *Handler) CreateBudget(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	type Input struct {
+		Category  string `json:"category" validate:"required"`
+		Amount    string `json:"amount" validate:"required"`
+		Month     int    `json:"month"`
+		Year      int    `json:"year"`
+		Recurring bool   `json:"recurring"`
+		Rollover  bool   `json:"rollover"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = v.Struct(input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	amount, ok := parseBudgetInput(categories, input.Category, input.Amount, input.Month, input.Year, input.Recurring)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if input.Recurring {
+		input.Month = 0
+		input.Year = 0
+	}
+
+	doc := &bson.D{
+		{Key: "user_id", Value: *userID},
+		{Key: "category", Value: input.Category},
+		{Key: "amount", Value: amount},
+		{Key: "month", Value: input.Month},
+		{Key: "year", Value: input.Year},
+		{Key: "recurring", Value: input.Recurring},
+		{Key: "rollover", Value: input.Rollover},
+		{Key: "created_at", Value: time.Now()},
+		{Key: "updated_at", Value: time.Now()},
+	}
+	_, err = h.Db.Budgets.InsertOne(ctx, doc)
+	if err != nil && strings.Contains(err.Error(), "duplicate key error") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Budget for that category and month already exists",
+		})
+		return
+	} else if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) UpdateBudget(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	type Input struct {
+		ID        string `json:"id" validate:"required"`
+		Category  string `json:"category" validate:"required"`
+		Amount    string `json:"amount" validate:"required"`
+		Month     int    `json:"month"`
+		Year      int    `json:"year"`
+		Recurring bool   `json:"recurring"`
+		Rollover  bool   `json:"rollover"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = v.Struct(input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	budgetObjID, err := primitive.ObjectIDFromHex(input.ID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	amount, ok := parseBudgetInput(categories, input.Category, input.Amount, input.Month, input.Year, input.Recurring)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if input.Recurring {
+		input.Month = 0
+		input.Year = 0
+	}
+
+	res, err := h.Db.Budgets.UpdateOne(
+		ctx,
+		bson.M{"_id": budgetObjID, "user_id": *userID},
+		bson.M{"$set": bson.M{
+			"category":   input.Category,
+			"amount":     amount,
+			"month":      input.Month,
+			"year":       input.Year,
+			"recurring":  input.Recurring,
+			"rollover":   input.Rollover,
+			"updated_at": time.Now(),
+		}},
+	)
+	if err != nil && strings.Contains(err.Error(), "duplicate key error") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Budget for that category and month already exists",
+		})
+		return
+	} else if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if res.MatchedCount == 0 {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+}
+
+func (h *Handler) DeleteBudget(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	budgetIDHex := c.Param("budget_id")
+	if util.ContainsEmpty(budgetIDHex) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	budgetObjID, err := primitive.ObjectIDFromHex(budgetIDHex)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	_, err = h.Db.Budgets.DeleteOne(ctx, bson.M{"_id": budgetObjID, "user_id": *userID})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+}
+
+// Returns budgeted vs spent vs remaining for each budgeted category in a month
+func (h *Handler) GetBudgetProgress(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	month, err := strconv.Atoi(c.Query("month"))
+	if err != nil || month < 1 || month > 12 {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	year, err := strconv.Atoi(c.Query("year"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	budgets, err := h.getBudgets(ctx, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	fromDate, toDate := util.MonthRange(month, year)
+	spent, err := h.categorySpending(ctx, userID, categories, fromDate, toDate)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	carried, err := h.carriedBalances(ctx, userID, categories, budgets, month, year)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	progress := []*BudgetProgress{}
+	var totalBudgeted, totalCarried, totalSpent money.Amount
+	effective := EffectiveBudgets(budgets, month, year)
+	for category, budget := range effective {
+		var carriedIn money.Amount
+		if budget.Rollover {
+			carriedIn = carried[category]
+		}
+		progress = append(progress, &BudgetProgress{
+			Category:  category,
+			Path:      categories.Path(category),
+			Recurring: budget.Recurring,
+			Rollover:  budget.Rollover,
+			Budgeted:  budget.Amount,
+			CarriedIn: carriedIn,
+			Spent:     spent[category],
+			Remaining: CloseEnvelope(carriedIn, budget.Amount, spent[category]),
+		})
+
+		// children of budgeted parents are already counted in the parent
+		if !hasBudgetedAncestor(categories, effective, category) {
+			totalBudgeted += budget.Amount
+			totalCarried += carriedIn
+			totalSpent += spent[category]
+		}
+	}
+	sort.Slice(progress, func(i, j int) bool { return progress[i].Path < progress[j].Path })
+
+	c.JSON(http.StatusOK, gin.H{
+		"progress":   progress,
+		"budgeted":   totalBudgeted,
+		"carried_in": totalCarried,
+		"spent":      totalSpent,
+		"remaining":  CloseEnvelope(totalCarried, totalBudgeted, totalSpent),
+	})
+}
@@ -0,0 +1,88 @@
+package finances
+
+import (
+	"context"
+
+	"github.com/tony-tvu/goexpense/classifier"
+	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/merchant"
+	"github.com/tony-tvu/goexpense/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Categorizer decides the category of new transactions from any source: the source's own
+// category, then the user's rules, the merchant's default category and finally the classifier
+type Categorizer struct {
+	Categories CategorySet
+	Rules      []*Rule
+	Merchants  MerchantSet
+	Model      *classifier.Model
+}
+
+// Loads the user's categories, rules, merchants and classifier
+func NewCategorizer(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID) (*Categorizer, error) {
+	categories, err := GetUserCategories(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	ruleset, err := GetUserRules(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	merchants, err := GetUserMerchants(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	model, err := LoadClassifier(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &Categorizer{Categories: categories, Rules: ruleset, Merchants: merchants, Model: model}, nil
+}
+
+// Fills in the category, name, tags and rule of a new transaction from its name and amount.
+// category is what the source reported, if anything, and the merchant is derived from the
+// name unless the source already set one.
+func (cz *Categorizer) Categorize(t *Transaction, category, institution string) {
+	if !cz.Categories.Contains(category) {
+		category = Uncategorized
+	}
+	kind := cz.Categories.Kind(category)
+	if kind != Income && kind != Ignore && t.Amount > 0 {
+		category = cz.Categories.IncomeCategory()
+	}
+
+	// apply rules
+	t.Name = util.RemoveDuplicateWhitespace(t.Name)
+	t.OriginalCategory, t.OriginalName = category, t.Name
+	outcome := EvaluateRules(cz.Categories, cz.Rules, t.RuleSubject(institution))
+	if outcome.Category != "" {
+		t.Amount = NormalizeAmount(t.Amount, cz.Categories.Kind(outcome.Category))
+		category = outcome.Category
+	}
+	if outcome.Name != "" {
+		t.Name = outcome.Name
+	}
+	t.Tags = outcome.Tags
+	t.RuleID = outcome.RuleID
+
+	if t.Merchant == "" {
+		t.Merchant = merchant.Normalize(t.OriginalName)
+	}
+	// merchant defaults describe one direction of money, refunds from a merchant aren't expenses
+	if outcome.Category == "" {
+		defaultCategory := cz.Merchants.DefaultCategory(t.Merchant, cz.Categories)
+		if defaultCategory != "" && NormalizeAmount(t.Amount, cz.Categories.Kind(defaultCategory)) == t.Amount {
+			category = defaultCategory
+		}
+	}
+
+	// fall back to the classifier when neither the source nor rules knew the category
+	if category == Uncategorized {
+		if predicted, p, ok := PredictCategory(cz.Model, cz.Categories, t.OriginalName, t.Amount); ok {
+			t.Amount = NormalizeAmount(t.Amount, cz.Categories.Kind(predicted))
+			category, t.Confidence = predicted, p
+		}
+	}
+	t.Category = category
+}
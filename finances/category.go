@@ -0,0 +1,695 @@
+package finances
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/rules"
+	"github.com/tony-tvu/goexpense/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Category kinds determine how transaction amounts are signed and counted
+const (
+	Expense = "expense"
+	Income  = "income"
+	Ignore  = "ignore"
+)
+
+// Uncategorized is always present and cannot be renamed or deleted
+const Uncategorized = "uncategorized"
+
+var Kinds = []string{Expense, Income, Ignore}
+
+type Category struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id"`
+	UserID primitive.ObjectID `json:"user_id" bson:"user_id"`
+
+	Name   string `json:"name" bson:"name"`
+	Parent string `json:"parent" bson:"parent"`
+	Color  string `json:"color" bson:"color"`
+	Icon   string `json:"icon" bson:"icon"`
+	Kind   string `json:"kind" bson:"kind"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// Categories every user starts with
+var DefaultCategories = []Category{
+	{Name: "bills", Color: "#e53e3e", Icon: "receipt", Kind: Expense},
+	{Name: "entertainment", Color: "#805ad5", Icon: "film", Kind: Expense},
+	{Name: "groceries", Color: "#38a169", Icon: "shopping-cart", Kind: Expense},
+	{Name: "ignore", Color: "#a0aec0", Icon: "eye-off", Kind: Ignore},
+	{Name: "income", Color: "#3182ce", Icon: "dollar-sign", Kind: Income},
+	{Name: "restaurant", Color: "#dd6b20", Icon: "coffee", Kind: Expense},
+	{Name: "transportation", Color: "#d69e2e", Icon: "truck", Kind: Expense},
+	{Name: "vacation", Color: "#00b5d8", Icon: "sun", Kind: Expense},
+	{Name: Uncategorized, Color: "#718096", Icon: "help-circle", Kind: Expense},
+}
+
+// CategorySet is a user's categories keyed by name
+type CategorySet map[string]*Category
+
+func (cs CategorySet) Contains(name string) bool {
+	_, ok := cs[name]
+	return ok
+}
+
+// Returns the kind of the named category, unknown categories count as expenses
+func (cs CategorySet) Kind(name string) string {
+	if category, ok := cs[name]; ok {
+		return category.Kind
+	}
+	return Expense
+}
+
+// Returns the name of the category used for incoming money when nothing else matches
+func (cs CategorySet) IncomeCategory() string {
+	if cs.Kind(Income) == Income {
+		return Income
+	}
+	for name, category := range cs {
+		if category.Kind == Income {
+			return name
+		}
+	}
+	return Uncategorized
+}
+
+// Returns the names of all parents of a category, nearest first
+func (cs CategorySet) Ancestors(name string) []string {
+	ancestors := []string{}
+	category, ok := cs[name]
+	for ok && category.Parent != "" && len(ancestors) < len(cs) {
+		ancestors = append(ancestors, category.Parent)
+		category, ok = cs[category.Parent]
+	}
+	return ancestors
+}
+
+// Returns the names of all children of a category and their children
+func (cs CategorySet) Descendants(name string) []string {
+	descendants := []string{}
+	for child := range cs {
+		ancestors := cs.Ancestors(child)
+		if util.Contains(&ancestors, name) {
+			descendants = append(descendants, child)
+		}
+	}
+	return descendants
+}
+
+// Returns the display path of a category, e.g. "food > groceries"
+func (cs CategorySet) Path(name string) string {
+	path := name
+	for _, ancestor := range cs.Ancestors(name) {
+		path = ancestor + " > " + path
+	}
+	return path
+}
+
+// Returns true if parent can be set as the parent of name, a category of the given kind, without
+// creating a cycle. Parents and children share a kind so totals only roll up within one kind.
+func (cs CategorySet) ValidParent(name, kind, parent string) bool {
+	for _, child := range cs.Descendants(name) {
+		if cs.Kind(child) != kind {
+			return false
+		}
+	}
+	if parent == "" {
+		return true
+	}
+	if parent == name || !cs.Contains(parent) || cs.Kind(parent) != kind {
+		return false
+	}
+	ancestors := cs.Ancestors(parent)
+	return !util.Contains(&ancestors, name)
+}
+
+// Adds each category's total into all of its parents
+func (cs CategorySet) Rollup(totals map[string]money.Amount) map[string]money.Amount {
+	rolled := map[string]money.Amount{}
+	for name, amount := range totals {
+		rolled[name] += amount
+		for _, ancestor := range cs.Ancestors(name) {
+			rolled[ancestor] += amount
+		}
+	}
+	return rolled
+}
+
+// Returns all names of categories with the given kind
+func (cs CategorySet) NamesOfKind(kind string) []string {
+	names := []string{}
+	for name, category := range cs {
+		if category.Kind == kind {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Returns the user's categories, saving the default set if the user has none yet
+func GetUserCategories(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID) (CategorySet, error) {
+	var categories []*Category
+	cursor, err := db.Categories.Find(ctx, bson.M{"user_id": *userID})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+
+	if len(categories) == 0 {
+		var docs []interface{}
+		for _, category := range DefaultCategories {
+			docs = append(docs, bson.D{
+				{Key: "user_id", Value: *userID},
+				{Key: "name", Value: category.Name},
+				{Key: "color", Value: category.Color},
+				{Key: "icon", Value: category.Icon},
+				{Key: "kind", Value: category.Kind},
+				{Key: "created_at", Value: time.Now()},
+				{Key: "updated_at", Value: time.Now()},
+			})
+		}
+		_, err = db.Categories.InsertMany(ctx, docs, &options.InsertManyOptions{
+			Ordered: util.BoolPointer(false),
+		})
+		if err != nil && !strings.Contains(err.Error(), "duplicate key error") {
+			return nil, err
+		}
+
+		cursor, err = db.Categories.Find(ctx, bson.M{"user_id": *userID})
+		if err != nil {
+			return nil, err
+		}
+		if err = cursor.All(ctx, &categories); err != nil {
+			return nil, err
+		}
+	}
+
+	set := CategorySet{}
+	for _, category := range categories {
+		set[category.Name] = category
+	}
+	return set, nil
+}
+
+// Returns an aggregation expression that signs an amount field for the given category kind
+func normalizeAmountExpr(field, kind string) interface{} {
+	switch kind {
+	case Income:
+		return bson.M{"$abs": field}
+	case Ignore:
+		return field
+	default:
+		return bson.M{"$multiply": bson.A{bson.M{"$abs": field}, -1}}
+	}
+}
+
+// Moves all of a user's transactions, rules and templates from one category to another
+func (h *Handler) remapCategory(ctx context.Context, userID *primitive.ObjectID, from, to, kind string) error {
+	// refunds are the opposite sign of the category
+	amount := normalizeAmountExpr("$amount", kind)
+	if kind != Ignore {
+		amount = bson.M{"$cond": bson.A{
+			bson.M{"$ifNull": bson.A{"$refund_of", false}},
+			bson.M{"$multiply": bson.A{amount, -1}},
+			amount,
+		}}
+	}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "category", Value: to},
+			{Key: "amount", Value: amount},
+			{Key: "updated_at", Value: time.Now()},
+		}}},
+	}
+	_, err := h.Db.Transactions.UpdateMany(ctx, bson.M{"user_id": *userID, "category": from}, update)
+	if err != nil {
+		return err
+	}
+
+	// splits of other transactions
+	update = mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "splits", Value: bson.M{"$map": bson.M{
+				"input": "$splits",
+				"as":    "s",
+				"in": bson.M{"$cond": bson.A{
+					bson.M{"$eq": bson.A{"$$s.category", from}},
+					bson.M{"$mergeObjects": bson.A{"$$s", bson.M{
+						"category": to,
+						"amount":   normalizeAmountExpr("$$s.amount", kind),
+					}}},
+					"$$s",
+				}},
+			}}},
+			{Key: "updated_at", Value: time.Now()},
+		}}},
+	}
+	_, err = h.Db.Transactions.UpdateMany(ctx, bson.M{"user_id": *userID, "splits.category": from}, update)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.Db.Rules.UpdateMany(
+		ctx,
+		bson.M{"user_id": *userID, "actions": bson.M{"$elemMatch": bson.M{"type": rules.SetCategory, "value": from}}},
+		bson.M{"$set": bson.M{"actions.$[action].value": to, "updated_at": time.Now()}},
+		options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"action.type": rules.SetCategory, "action.value": from}},
+		}),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.Db.Templates.UpdateMany(
+		ctx,
+		bson.M{"user_id": *userID, "category": from},
+		bson.M{"$set": bson.M{"category": to, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+func (h *Handler) GetCategories(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	if _, err = GetUserCategories(ctx, h.Db, userID); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	var categories []*Category
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := h.Db.Categories.Find(ctx, bson.M{"user_id": *userID}, opts)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = cursor.All(ctx, &categories); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"categories": categories,
+	})
+}
+
+func (h *Handler) CreateCategory(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	type Input struct {
+		Name   string `json:"name" validate:"required"`
+		Parent string `json:"parent"`
+		Color  string `json:"color"`
+		Icon   string `json:"icon"`
+		Kind   string `json:"kind" validate:"required"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = v.Struct(input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	name := strings.ToLower(strings.TrimSpace(util.RemoveDuplicateWhitespace(input.Name)))
+	parent := strings.ToLower(strings.TrimSpace(util.RemoveDuplicateWhitespace(input.Parent)))
+	if util.ContainsEmpty(name) || !util.Contains(&Kinds, input.Kind) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	// make sure defaults exist before adding to the set
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if !categories.ValidParent(name, input.Kind, parent) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	doc := &bson.D{
+		{Key: "user_id", Value: *userID},
+		{Key: "name", Value: name},
+		{Key: "parent", Value: parent},
+		{Key: "color", Value: input.Color},
+		{Key: "icon", Value: input.Icon},
+		{Key: "kind", Value: input.Kind},
+		{Key: "created_at", Value: time.Now()},
+		{Key: "updated_at", Value: time.Now()},
+	}
+	_, err = h.Db.Categories.InsertOne(ctx, doc)
+	if err != nil && strings.Contains(err.Error(), "duplicate key error") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Category with that name already exists",
+		})
+		return
+	} else if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) EditCategory(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	type Input struct {
+		ID     string `json:"id" validate:"required"`
+		Name   string `json:"name" validate:"required"`
+		Parent string `json:"parent"`
+		Color  string `json:"color"`
+		Icon   string `json:"icon"`
+		Kind   string `json:"kind" validate:"required"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = v.Struct(input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	name := strings.ToLower(strings.TrimSpace(util.RemoveDuplicateWhitespace(input.Name)))
+	parent := strings.ToLower(strings.TrimSpace(util.RemoveDuplicateWhitespace(input.Parent)))
+	if util.ContainsEmpty(name) || !util.Contains(&Kinds, input.Kind) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	categoryObjID, err := primitive.ObjectIDFromHex(input.ID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var category *Category
+	if err = h.Db.Categories.
+		FindOne(ctx, bson.M{"_id": categoryObjID, "user_id": *userID}).
+		Decode(&category); err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if category.Name == Uncategorized && name != Uncategorized {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	// validate against the existing name since children still point to it
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if !categories.ValidParent(category.Name, input.Kind, parent) || parent == name {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	_, err = h.Db.Categories.UpdateOne(
+		ctx,
+		bson.M{"_id": categoryObjID, "user_id": *userID},
+		bson.M{"$set": bson.M{
+			"name":       name,
+			"parent":     parent,
+			"color":      input.Color,
+			"icon":       input.Icon,
+			"kind":       input.Kind,
+			"updated_at": time.Now(),
+		}},
+	)
+	if err != nil && strings.Contains(err.Error(), "duplicate key error") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Category with that name already exists",
+		})
+		return
+	} else if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	// move transactions and rules over to the new name and kind
+	if category.Name != name || category.Kind != input.Kind {
+		if err = h.remapCategory(ctx, userID, category.Name, name, input.Kind); err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// keep children and budgets attached to the renamed category
+	if category.Name != name {
+		_, err = h.Db.Categories.UpdateMany(
+			ctx,
+			bson.M{"user_id": *userID, "parent": category.Name},
+			bson.M{"$set": bson.M{"parent": name, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		_, err = h.Db.Budgets.UpdateMany(
+			ctx,
+			bson.M{"user_id": *userID, "category": category.Name},
+			bson.M{"$set": bson.M{"category": name, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		_, err = h.Db.Envelopes.UpdateMany(
+			ctx,
+			bson.M{"user_id": *userID, "category": category.Name},
+			bson.M{"$set": bson.M{"category": name}},
+		)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (h *Handler) DeleteCategory(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	categoryIDHex := c.Param("category_id")
+	if util.ContainsEmpty(categoryIDHex) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	categoryObjID, err := primitive.ObjectIDFromHex(categoryIDHex)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var category *Category
+	if err = h.Db.Categories.
+		FindOne(ctx, bson.M{"_id": categoryObjID, "user_id": *userID}).
+		Decode(&category); err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if category.Name == Uncategorized {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	// transactions and rules are moved to the replacement category
+	replacement := c.DefaultQuery("replace_with", Uncategorized)
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if replacement == category.Name || !categories.Contains(replacement) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err = h.remapCategory(ctx, userID, category.Name, replacement, categories.Kind(replacement)); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	// children move up to the deleted category's parent
+	_, err = h.Db.Categories.UpdateMany(
+		ctx,
+		bson.M{"user_id": *userID, "parent": category.Name},
+		bson.M{"$set": bson.M{"parent": category.Parent, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	_, err = h.Db.Budgets.DeleteMany(ctx, bson.M{"user_id": *userID, "category": category.Name})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	_, err = h.Db.Envelopes.DeleteMany(ctx, bson.M{"user_id": *userID, "category": category.Name})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	_, err = h.Db.Categories.DeleteOne(ctx, bson.M{"_id": categoryObjID, "user_id": *userID})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+}
+
+// Returns the amount spent in each category with child amounts rolled up into parents
+func (h *Handler) GetCategoryTotals(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	match := bson.M{"user_id": *userID}
+	monthStr := c.Query("month")
+	yearStr := c.Query("year")
+	if !util.ContainsEmpty(monthStr, yearStr) {
+		month, err := strconv.Atoi(monthStr)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		year, err := strconv.Atoi(yearStr)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		fromDate, toDate := util.MonthRange(month, year)
+		match["date"] = bson.M{"$gte": fromDate, "$lt": toDate}
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	pipeline := totalsStages(match)
+	pipeline = append(pipeline, bson.D{{Key: "$group", Value: bson.D{
+		{Key: "_id", Value: "$category"},
+		{Key: "amount", Value: bson.M{"$sum": "$amount"}},
+	}}})
+	cursor, err := h.Db.Transactions.Aggregate(ctx, pipeline)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	var results []struct {
+		Category string       `bson:"_id"`
+		Amount   money.Amount `bson:"amount"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	own := map[string]money.Amount{}
+	for _, result := range results {
+		own[result.Category] = result.Amount
+	}
+	rolled := categories.Rollup(own)
+
+	type Total struct {
+		Category string       `json:"category"`
+		Parent   string       `json:"parent"`
+		Path     string       `json:"path"`
+		Kind     string       `json:"kind"`
+		Amount   money.Amount `json:"amount"`
+		Total    money.Amount `json:"total"`
+	}
+	totals := []*Total{}
+	for name, total := range rolled {
+		parent := ""
+		if category, ok := categories[name]; ok {
+			parent = category.Parent
+		}
+		totals = append(totals, &Total{
+			Category: name,
+			Parent:   parent,
+			Path:     categories.Path(name),
+			Kind:     categories.Kind(name),
+			Amount:   own[name],
+			Total:    total,
+		})
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Path < totals[j].Path })
+
+	c.JSON(http.StatusOK, gin.H{
+		"totals": totals,
+	})
+}
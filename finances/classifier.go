@@ -0,0 +1,108 @@
+package finances
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/classifier"
+	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Predictions less likely than this leave transactions uncategorized
+const MinConfidence = 0.6
+
+// Trains the user's classifier on their categorized transactions and saves it
+func TrainClassifier(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID) (*classifier.Model, error) {
+	categories, err := GetUserCategories(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// split transactions don't belong to a single category, and ones the classifier
+	// categorized itself would only reinforce its own guesses
+	var transactions []*Transaction
+	cursor, err := db.Transactions.Find(ctx, bson.M{
+		"user_id":    *userID,
+		"category":   bson.M{"$ne": Uncategorized},
+		"splits":     bson.M{"$exists": false},
+		"confidence": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &transactions); err != nil {
+		return nil, err
+	}
+
+	examples := []*classifier.Example{}
+	for _, t := range transactions {
+		if categories.Contains(t.Category) {
+			examples = append(examples, &classifier.Example{Name: t.Name, Amount: t.Amount, Category: t.Category})
+		}
+	}
+	model := classifier.Train(examples)
+
+	_, err = db.Classifiers.UpdateOne(
+		ctx,
+		bson.M{"user_id": *userID},
+		bson.M{"$set": bson.M{"model": model, "trained_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// Returns the user's saved classifier, training one the first time
+func LoadClassifier(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID) (*classifier.Model, error) {
+	var saved struct {
+		Model *classifier.Model `bson:"model"`
+	}
+	err := db.Classifiers.FindOne(ctx, bson.M{"user_id": *userID}).Decode(&saved)
+	if err == mongo.ErrNoDocuments {
+		return TrainClassifier(ctx, db, userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return saved.Model, nil
+}
+
+// Returns the predicted category for a transaction if the user has it and the model is confident enough
+func PredictCategory(model *classifier.Model, categories CategorySet, name string, amount money.Amount) (string, float64, bool) {
+	category, confidence := model.Predict(name, amount)
+	if category == "" || confidence < MinConfidence || !categories.Contains(category) {
+		return "", 0, false
+	}
+	return category, confidence, true
+}
+
+func (h *Handler) RetrainClassifier(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	model, err := TrainClassifier(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"documents":  model.Documents,
+		"categories": len(model.Classes),
+		"vocabulary": model.Vocabulary,
+	})
+}
@@ -0,0 +1,72 @@
+package finances
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/exchange"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Returns the currency the user's totals are reported in
+func GetBaseCurrency(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID) string {
+	var u struct {
+		BaseCurrency string `bson:"base_currency"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"base_currency": 1})
+	if err := db.Users.FindOne(ctx, bson.M{"_id": *userID}, opts).Decode(&u); err != nil || u.BaseCurrency == "" {
+		return money.DefaultCurrency
+	}
+	return u.BaseCurrency
+}
+
+// Converter converts transaction amounts into the user's base currency at the transaction date.
+// Currencies without a known rate are left as is and reported by Missing.
+type Converter struct {
+	Base    string
+	table   *exchange.Table
+	missing []string
+}
+
+func (cv *Converter) Convert(amount money.Amount, currency string, date time.Time) money.Amount {
+	converted, ok := cv.table.Convert(amount, currency, cv.Base, date)
+	if !ok && !util.Contains(&cv.missing, currency) {
+		cv.missing = append(cv.missing, currency)
+	}
+	return converted
+}
+
+// Returns the currencies that could not be converted
+func (cv *Converter) Missing() []string {
+	missing := append([]string{}, cv.missing...)
+	sort.Strings(missing)
+	return missing
+}
+
+// Loads a converter for the user's transactions between two dates, a zero since loads every rate up to until
+func (h *Handler) newConverter(ctx context.Context, userID *primitive.ObjectID, since, until time.Time) (*Converter, error) {
+	base := GetBaseCurrency(ctx, h.Db, userID)
+
+	values, err := h.Db.Transactions.Distinct(ctx, "currency", bson.M{"user_id": *userID})
+	if err != nil {
+		return nil, err
+	}
+	currencies := []string{}
+	for _, value := range values {
+		if currency, ok := value.(string); ok {
+			currencies = append(currencies, currency)
+		}
+	}
+
+	table, err := exchange.LoadTable(ctx, h.Db, currencies, base, since, until)
+	if err != nil {
+		return nil, err
+	}
+	return &Converter{Base: base, table: table}, nil
+}
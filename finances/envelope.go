@@ -0,0 +1,264 @@
+package finances
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Envelope is the persisted closing balance of a rollover budget for one month.
+// Closed envelopes are not recalculated when old transactions change unless recomputed.
+type Envelope struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id"`
+	UserID primitive.ObjectID `json:"user_id" bson:"user_id"`
+
+	Category  string       `json:"category" bson:"category"`
+	Month     int          `json:"month" bson:"month"`
+	Year      int          `json:"year" bson:"year"`
+	CarriedIn money.Amount `json:"carried_in" bson:"carried_in"`
+	Budgeted  money.Amount `json:"budgeted" bson:"budgeted"`
+	Spent     money.Amount `json:"spent" bson:"spent"`
+	Closing   money.Amount `json:"closing" bson:"closing"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Returns months as a single comparable number
+func monthIndex(month, year int) int {
+	return year*12 + month - 1
+}
+
+func monthFromIndex(index int) (int, int) {
+	return index%12 + 1, index / 12
+}
+
+// Returns the first month each category has a rollover budget
+func rolloverStarts(budgets []*Budget) map[string]int {
+	starts := map[string]int{}
+	for _, budget := range budgets {
+		if !budget.Rollover {
+			continue
+		}
+		start := monthIndex(budget.Month, budget.Year)
+		if budget.Recurring {
+			start = monthIndex(int(budget.CreatedAt.Month()), budget.CreatedAt.Year())
+		}
+		if current, ok := starts[budget.Category]; !ok || start < current {
+			starts[budget.Category] = start
+		}
+	}
+	return starts
+}
+
+// Returns the closing balance of an envelope, positive balances are unspent money
+func CloseEnvelope(carriedIn, budgeted, spent money.Amount) money.Amount {
+	return carriedIn + budgeted - spent
+}
+
+// Persists envelopes for every month up to and including the through month that isn't closed yet.
+// Months without a rollover budget reset the carried balance to zero.
+func (h *Handler) closeEnvelopes(ctx context.Context, userID *primitive.ObjectID, categories CategorySet, budgets []*Budget, through int) error {
+	starts := rolloverStarts(budgets)
+	if len(starts) == 0 {
+		return nil
+	}
+
+	var envelopes []*Envelope
+	cursor, err := h.Db.Envelopes.Find(ctx, bson.M{"user_id": *userID})
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(ctx, &envelopes); err != nil {
+		return err
+	}
+	closed := map[string]map[int]*Envelope{}
+	for _, envelope := range envelopes {
+		if closed[envelope.Category] == nil {
+			closed[envelope.Category] = map[int]*Envelope{}
+		}
+		closed[envelope.Category][monthIndex(envelope.Month, envelope.Year)] = envelope
+	}
+
+	// spending is only looked up for months that need closing
+	spending := map[int]map[string]money.Amount{}
+	for category, start := range starts {
+		var carry money.Amount
+		for index := start; index <= through; index++ {
+			if envelope, ok := closed[category][index]; ok {
+				carry = envelope.Closing
+				continue
+			}
+
+			month, year := monthFromIndex(index)
+			budget, ok := EffectiveBudgets(budgets, month, year)[category]
+			if !ok || !budget.Rollover {
+				carry = 0
+				continue
+			}
+
+			if _, ok := spending[index]; !ok {
+				fromDate, toDate := util.MonthRange(month, year)
+				spent, err := h.categorySpending(ctx, userID, categories, fromDate, toDate)
+				if err != nil {
+					return err
+				}
+				spending[index] = spent
+			}
+
+			spent := spending[index][category]
+			closing := CloseEnvelope(carry, budget.Amount, spent)
+			doc := bson.D{
+				{Key: "user_id", Value: *userID},
+				{Key: "category", Value: category},
+				{Key: "month", Value: month},
+				{Key: "year", Value: year},
+				{Key: "carried_in", Value: carry},
+				{Key: "budgeted", Value: budget.Amount},
+				{Key: "spent", Value: spent},
+				{Key: "closing", Value: closing},
+				{Key: "created_at", Value: time.Now()},
+			}
+			_, err := h.Db.Envelopes.InsertOne(ctx, doc)
+			if err != nil && !strings.Contains(err.Error(), "duplicate key error") {
+				return err
+			}
+			carry = closing
+		}
+	}
+
+	return nil
+}
+
+// Returns the balance carried into a month for every rollover category
+func (h *Handler) carriedBalances(ctx context.Context, userID *primitive.ObjectID, categories CategorySet, budgets []*Budget, month, year int) (map[string]money.Amount, error) {
+	// only months that are over can be closed
+	now := time.Now()
+	through := monthIndex(month, year) - 1
+	if lastComplete := monthIndex(int(now.Month()), now.Year()) - 1; through > lastComplete {
+		through = lastComplete
+	}
+	if err := h.closeEnvelopes(ctx, userID, categories, budgets, through); err != nil {
+		return nil, err
+	}
+
+	prevMonth, prevYear := monthFromIndex(monthIndex(month, year) - 1)
+	var envelopes []*Envelope
+	cursor, err := h.Db.Envelopes.Find(ctx, bson.M{"user_id": *userID, "month": prevMonth, "year": prevYear})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &envelopes); err != nil {
+		return nil, err
+	}
+
+	carried := map[string]money.Amount{}
+	for _, envelope := range envelopes {
+		carried[envelope.Category] = envelope.Closing
+	}
+	return carried, nil
+}
+
+func (h *Handler) GetEnvelopes(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	filter := bson.M{"user_id": *userID}
+	if category := c.Query("category"); category != "" {
+		filter["category"] = category
+	}
+
+	var envelopes []*Envelope
+	opts := options.Find().SetSort(bson.D{{Key: "year", Value: -1}, {Key: "month", Value: -1}, {Key: "category", Value: 1}})
+	cursor, err := h.Db.Envelopes.Find(ctx, filter, opts)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = cursor.All(ctx, &envelopes); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"envelopes": envelopes,
+	})
+}
+
+// Rebuilds envelopes from the given month forward
+func (h *Handler) RecomputeEnvelopes(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	type Input struct {
+		Month int `json:"month" validate:"required,min=1,max=12"`
+		Year  int `json:"year" validate:"required"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = v.Struct(input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	_, err = h.Db.Envelopes.DeleteMany(ctx, bson.M{
+		"user_id": *userID,
+		"$or": bson.A{
+			bson.M{"year": bson.M{"$gt": input.Year}},
+			bson.M{"year": input.Year, "month": bson.M{"$gte": input.Month}},
+		},
+	})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	budgets, err := h.getBudgets(ctx, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	lastComplete := monthIndex(int(now.Month()), now.Year()) - 1
+	if err = h.closeEnvelopes(ctx, userID, categories, budgets, lastComplete); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,166 @@
+package finances
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/exporter"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Records written between flushes to the client
+const exportFlushEvery = 500
+
+// Returns the export record of a transaction
+func exportRecord(t *Transaction, account *Account) *exporter.Record {
+	record := &exporter.Record{
+		TransactionID: t.TransactionID,
+		Date:          t.Date,
+		Name:          t.Name,
+		Merchant:      t.Merchant,
+		Category:      t.Category,
+		Amount:        t.Amount,
+		Currency:      t.Currency,
+		Tags:          t.Tags,
+		Notes:         t.Notes,
+		AccountID:     t.AccountID,
+	}
+	if record.Tags == nil {
+		record.Tags = []string{}
+	}
+	for _, split := range t.Splits {
+		record.Splits = append(record.Splits, &exporter.Split{Category: split.Category, Amount: split.Amount, Note: split.Note})
+	}
+	if account != nil {
+		record.AccountName = account.Name
+		record.AccountType = account.AccountType
+		record.Institution = account.Institution
+	}
+	return record
+}
+
+// Streams the transactions matching the same filters as the transactions list as csv, json,
+// ofx or qif. Paging is ignored so the whole history can be exported, ofx and qif are ordered
+// by account since they group transactions into a statement per account.
+func (h *Handler) Export(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	format := c.DefaultQuery("format", exporter.CSV)
+	if format != exporter.CSV && format != exporter.JSON && format != exporter.OFX && format != exporter.QIF {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	query, err := ParseTransactionQuery(c, userID, categories)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var accounts []*Account
+	cursor, err := h.Db.Accounts.Find(ctx, bson.M{"user_id": *userID})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = cursor.All(ctx, &accounts); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	// statements cover the requested dates, or everything up to today
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, to := time.Time{}, time.Now()
+	if dateFilter, ok := query.Filter["date"].(bson.M); ok {
+		if since, ok := dateFilter["$gte"].(time.Time); ok {
+			from = since
+		}
+		if until, ok := dateFilter["$lt"].(time.Time); ok {
+			to = until.AddDate(0, 0, -1)
+		}
+	}
+
+	// the current balance is only right for statements that run up to today
+	byID := map[string]*Account{}
+	statementAccounts := map[string]*exporter.Account{}
+	for _, account := range accounts {
+		byID[account.AccountID] = account
+		statementAccount := &exporter.Account{
+			ID:       account.AccountID,
+			Type:     account.AccountType,
+			Currency: account.Currency,
+		}
+		if !to.Before(today) {
+			balance := account.Balance
+			statementAccount.Balance = &balance
+		}
+		statementAccounts[account.AccountID] = statementAccount
+	}
+
+	if from.IsZero() {
+		var first *Transaction
+		opts := options.FindOne().SetSort(bson.D{{Key: "date", Value: 1}})
+		if err = h.Db.Transactions.FindOne(ctx, query.Filter, opts).Decode(&first); err == nil {
+			from = first.Date
+		}
+	}
+
+	opts := options.Find().SetSort(query.SortDoc())
+	if exporter.ByAccount(format) {
+		opts.SetSort(bson.D{{Key: "account_id", Value: 1}, {Key: "date", Value: 1}, {Key: "_id", Value: 1}})
+	}
+	cursor, err = h.Db.Transactions.Find(ctx, query.Filter, opts)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	writer, err := exporter.NewWriter(format, c.Writer, statementAccounts, from, to)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	c.Header("Content-Type", exporter.ContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="transactions_%s.%s"`, time.Now().Format("2006_01_02"), format))
+	c.Status(http.StatusOK)
+
+	// the response has started, so errors from here on can only cut it short
+	written := 0
+	for cursor.Next(ctx) {
+		var t *Transaction
+		if err = cursor.Decode(&t); err != nil {
+			log.Printf("error decoding exported transaction for user %s: %v", userID.Hex(), err)
+			return
+		}
+		if err = writer.Write(exportRecord(t, byID[t.AccountID])); err != nil {
+			log.Printf("error exporting transactions for user %s: %v", userID.Hex(), err)
+			return
+		}
+		if written++; written%exportFlushEvery == 0 {
+			c.Writer.Flush()
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		log.Printf("error exporting transactions for user %s: %v", userID.Hex(), err)
+		return
+	}
+	if err = writer.Close(); err != nil {
+		log.Printf("error exporting transactions for user %s: %v", userID.Hex(), err)
+	}
+}
@@ -0,0 +1,238 @@
+package finances
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/merchant"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// Fields transactions can be sorted by
+var sortFields = []string{"date", "amount", "name"}
+
+// TransactionQuery is a parsed transactions list request
+type TransactionQuery struct {
+	Filter bson.M
+	Sort   string
+	Order  int
+	Limit  int64
+	Cursor *PageCursor
+}
+
+// PageCursor points at the last transaction of a page
+type PageCursor struct {
+	Date   time.Time          `json:"d"`
+	Amount money.Amount       `json:"a"`
+	Name   string             `json:"n"`
+	ID     primitive.ObjectID `json:"id"`
+}
+
+func (pc *PageCursor) Encode() string {
+	b, _ := json.Marshal(pc)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodePageCursor(s string) (*PageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var pc *PageCursor
+	if err = json.Unmarshal(b, &pc); err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// Returns a cursor pointing at the given transaction
+func NewPageCursor(t *Transaction) *PageCursor {
+	return &PageCursor{Date: t.Date, Amount: t.Amount, Name: t.Name, ID: t.ID}
+}
+
+func (pc *PageCursor) value(sortField string) interface{} {
+	switch sortField {
+	case "amount":
+		return pc.Amount
+	case "name":
+		return pc.Name
+	default:
+		return pc.Date
+	}
+}
+
+// Returns the filter matching transactions after the cursor for the query's sort order
+func (q *TransactionQuery) CursorFilter() bson.M {
+	if q.Cursor == nil {
+		return q.Filter
+	}
+	op := "$lt"
+	if q.Order > 0 {
+		op = "$gt"
+	}
+	value := q.Cursor.value(q.Sort)
+	return bson.M{"$and": bson.A{
+		q.Filter,
+		bson.M{"$or": bson.A{
+			bson.M{q.Sort: bson.M{op: value}},
+			bson.M{q.Sort: value, "_id": bson.M{op: q.Cursor.ID}},
+		}},
+	}}
+}
+
+// Returns the sort document for the query, _id breaks ties so pages are stable
+func (q *TransactionQuery) SortDoc() bson.D {
+	return bson.D{{Key: q.Sort, Value: q.Order}, {Key: "_id", Value: q.Order}}
+}
+
+// Parses filters, sort options and paging from the transactions list query string
+func ParseTransactionQuery(c *gin.Context, userID *primitive.ObjectID, categories CategorySet) (*TransactionQuery, error) {
+	q := &TransactionQuery{
+		Filter: bson.M{"user_id": *userID},
+		Sort:   "date",
+		Order:  -1,
+		Limit:  defaultPageSize,
+	}
+
+	dateFilter, ok := parseDateRange(c)
+	if !ok {
+		return nil, errors.New("invalid date range")
+	}
+
+	// month and year select a whole month, which is returned unpaged unless a limit is given
+	monthStr := c.Query("month")
+	yearStr := c.Query("year")
+	if !util.ContainsEmpty(monthStr, yearStr) {
+		month, err := strconv.Atoi(monthStr)
+		if err != nil {
+			return nil, err
+		}
+		year, err := strconv.Atoi(yearStr)
+		if err != nil {
+			return nil, err
+		}
+		fromDate, toDate := util.MonthRange(month, year)
+		dateFilter["$gte"] = fromDate
+		dateFilter["$lt"] = toDate
+		q.Limit = 0
+	}
+	if len(dateFilter) > 0 {
+		q.Filter["date"] = dateFilter
+	}
+
+	// parent categories include their children
+	if names := c.QueryArray("category"); len(names) > 0 {
+		in := []string{}
+		for _, name := range names {
+			in = append(in, name)
+			in = append(in, categories.Descendants(name)...)
+		}
+		// split transactions match on any of their splits
+		q.Filter["$or"] = bson.A{
+			bson.M{"category": bson.M{"$in": in}},
+			bson.M{"splits.category": bson.M{"$in": in}},
+		}
+	}
+	if tags := NormalizeTags(c.QueryArray("tag")); len(tags) > 0 {
+		q.Filter["tags"] = bson.M{"$in": tags}
+	}
+	// merchants are matched by key like everywhere else, regardless of case and spacing
+	if merchants := c.QueryArray("merchant"); len(merchants) > 0 {
+		in := bson.A{}
+		for _, name := range merchants {
+			if key := merchant.Key(name); key != "" {
+				in = append(in, primitive.Regex{Pattern: "^" + regexp.QuoteMeta(key) + "$", Options: "i"})
+			}
+		}
+		q.Filter["merchant"] = bson.M{"$in": in}
+	}
+	// transfer=true lists only transfers, transfer=false leaves them out
+	switch c.Query("transfer") {
+	case "true":
+		q.Filter["transfer_id"] = bson.M{"$exists": true}
+	case "false":
+		q.Filter["transfer_id"] = notTransfer
+	}
+	if accountIDs := c.QueryArray("account_id"); len(accountIDs) > 0 {
+		q.Filter["account_id"] = bson.M{"$in": accountIDs}
+	}
+	if enrollmentIDs := c.QueryArray("enrollment_id"); len(enrollmentIDs) > 0 {
+		q.Filter["enrollment_id"] = bson.M{"$in": enrollmentIDs}
+	}
+
+	amountFilter := bson.M{}
+	if minStr := c.Query("min_amount"); minStr != "" {
+		min, err := money.Parse(minStr)
+		if err != nil {
+			return nil, err
+		}
+		amountFilter["$gte"] = min
+	}
+	if maxStr := c.Query("max_amount"); maxStr != "" {
+		max, err := money.Parse(maxStr)
+		if err != nil {
+			return nil, err
+		}
+		amountFilter["$lte"] = max
+	}
+	if len(amountFilter) > 0 {
+		q.Filter["amount"] = amountFilter
+	}
+
+	if search := strings.TrimSpace(c.Query("search")); search != "" {
+		q.Filter["name"] = primitive.Regex{
+			Pattern: regexp.QuoteMeta(util.RemoveDuplicateWhitespace(search)),
+			Options: "i",
+		}
+	}
+
+	if sort := c.Query("sort"); sort != "" {
+		if !util.Contains(&sortFields, sort) {
+			return nil, errors.New("invalid sort field")
+		}
+		q.Sort = sort
+	}
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+		q.Order = 1
+	case "desc":
+		q.Order = -1
+	default:
+		return nil, errors.New("invalid sort order")
+	}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			return nil, errors.New("invalid limit")
+		}
+		if limit > maxPageSize {
+			limit = maxPageSize
+		}
+		q.Limit = int64(limit)
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		pc, err := DecodePageCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		q.Cursor = pc
+	}
+
+	return q, nil
+}
@@ -6,7 +6,6 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
@@ -15,9 +14,12 @@ import (
 	"github.com/google/uuid"
 	"github.com/tony-tvu/goexpense/auth"
 	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/merchant"
+	"github.com/tony-tvu/goexpense/money"
 	"github.com/tony-tvu/goexpense/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -29,19 +31,23 @@ type Account struct {
 	ID     primitive.ObjectID `json:"id" bson:"_id"`
 	UserID primitive.ObjectID `json:"user_id" bson:"user_id"`
 
-	AccountID    string    `json:"account_id" bson:"account_id"`
-	EnrollmentID string    `json:"enrollment_id" bson:"enrollment_id"`
-	AccessToken  string    `json:"access_token" bson:"access_token"`
-	AccountType  string    `json:"account_type" bson:"account_type"`
-	Subtype      string    `json:"subtype" bson:"subtype"`
-	Status       string    `json:"status" bson:"status"`
-	Name         string    `json:"name" bson:"name"`
-	LastFour     string    `json:"last_four" bson:"last_four"`
-	Institution  string    `json:"institution" bson:"institution"`
-	Balance      float64   `json:"balance" bson:"balance"`
-	Currency     string    `json:"currency" bson:"currency"`
-	CreatedAt    time.Time `json:"created_at" bson:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" bson:"updated_at"`
+	AccountID    string       `json:"account_id" bson:"account_id"`
+	EnrollmentID string       `json:"enrollment_id" bson:"enrollment_id"`
+	AccessToken  string       `json:"access_token" bson:"access_token"`
+	AccountType  string       `json:"account_type" bson:"account_type"`
+	Subtype      string       `json:"subtype" bson:"subtype"`
+	Status       string       `json:"status" bson:"status"`
+	Name         string       `json:"name" bson:"name"`
+	LastFour     string       `json:"last_four" bson:"last_four"`
+	Institution  string       `json:"institution" bson:"institution"`
+	Balance      money.Amount `json:"balance" bson:"balance"`
+	Currency     string       `json:"currency" bson:"currency"`
+
+	// when the balance of a manual account was taken, from the last statement imported
+	BalanceAsOf *time.Time `json:"balance_as_of,omitempty" bson:"balance_as_of,omitempty"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
 }
 
 type Transaction struct {
@@ -50,34 +56,49 @@ type Transaction struct {
 	EnrollmentID string             `json:"enrollment_id" bson:"enrollment_id"`
 	AccountID    string             `json:"account_id" bson:"account_id"`
 
-	TransactionID string    `json:"transaction_id" bson:"transaction_id"`
-	Category      string    `json:"category" bson:"category"`
-	Name          string    `json:"name" bson:"name"`
-	Date          time.Time `json:"date" bson:"date"`
-	Amount        float32   `json:"amount" bson:"amount"`
+	TransactionID string       `json:"transaction_id" bson:"transaction_id"`
+	Category      string       `json:"category" bson:"category"`
+	Name          string       `json:"name" bson:"name"`
+	Date          time.Time    `json:"date" bson:"date"`
+	Amount        money.Amount `json:"amount" bson:"amount"`
+	Currency      string       `json:"currency" bson:"currency"`
+	Splits        []*Split     `json:"splits" bson:"splits,omitempty"`
+	Tags          []string     `json:"tags" bson:"tags,omitempty"`
+	Notes         string       `json:"notes" bson:"notes,omitempty"`
+	Merchant      string       `json:"merchant" bson:"merchant,omitempty"`
 
-	CreatedAt time.Time `json:"created_at" bson:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
-}
+	// the confirmed transfer this transaction is one side of, transfers are left out of totals
+	TransferID *primitive.ObjectID `json:"transfer_id,omitempty" bson:"transfer_id,omitempty"`
 
-type Rule struct {
-	ID        primitive.ObjectID `json:"id" bson:"_id"`
-	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
-	Substring string             `json:"substring" bson:"substring"`
-	Category  string             `json:"category" bson:"category"`
-	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
-}
+	// the purchase a refund was linked to, refunds keep the purchase's category with a positive amount
+	RefundOf string `json:"refund_of,omitempty" bson:"refund_of,omitempty"`
+
+	// expenses owed back by someone else and, on the payment that settled them, the expenses it
+	// covered and how much of the payment went to them. The rest of the payment still counts.
+	Reimbursement    *Reimbursement `json:"reimbursement,omitempty" bson:"reimbursement,omitempty"`
+	Reimburses       []string       `json:"reimburses,omitempty" bson:"reimburses,omitempty"`
+	ReimbursedAmount money.Amount   `json:"reimbursed_amount,omitempty" bson:"reimbursed_amount,omitempty"`
+
+	// the template a scheduled transaction was created from
+	TemplateID *primitive.ObjectID `json:"template_id,omitempty" bson:"template_id,omitempty"`
+
+	// the rule that last categorized the transaction and what it was before any rules ran
+	RuleID           *primitive.ObjectID `json:"rule_id" bson:"rule_id,omitempty"`
+	OriginalCategory string              `json:"original_category" bson:"original_category,omitempty"`
+	OriginalName     string              `json:"original_name" bson:"original_name,omitempty"`
 
-var Categories = []string{
-	"bills",
-	"entertainment",
-	"groceries",
-	"ignore",
-	"income",
-	"restaurant",
-	"transportation",
-	"vacation",
-	"uncategorized",
+	// probability of the predicted category when the classifier chose it
+	Confidence float64 `json:"confidence,omitempty" bson:"confidence,omitempty"`
+
+	// fields the user edited by hand, which rules leave alone
+	Locked []string `json:"locked" bson:"locked,omitempty"`
+
+	// amount in the user's base currency, filled in when listing
+	ConvertedAmount money.Amount `json:"converted_amount" bson:"-"`
+	BaseCurrency    string       `json:"base_currency" bson:"-"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
 }
 
 var v *validator.Validate
@@ -86,92 +107,53 @@ func init() {
 	v = validator.New()
 }
 
-func (h *Handler) CreateRule(c *gin.Context) {
+func (h *Handler) DeleteTransaction(c *gin.Context) {
 	ctx := c.Request.Context()
-	defer c.Request.Body.Close()
-
 	userID, err := auth.AuthorizeUser(c, h.Db)
 	if err != nil {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
-	type Input struct {
-		Substring string `json:"substring" validate:"required"`
-		Category  string `json:"category" validate:"required"`
-	}
-
-	var input *Input
-	bodyBytes, err := io.ReadAll(c.Request.Body)
-	if err != nil {
-		c.AbortWithStatus(http.StatusBadRequest)
-		return
-	}
-	err = json.Unmarshal(bodyBytes, &input)
-	if err != nil {
-		c.AbortWithStatus(http.StatusBadRequest)
-		return
-	}
-	err = v.Struct(input)
-	if err != nil {
-		c.AbortWithStatus(http.StatusBadRequest)
-		return
-	}
-	if util.ContainsEmpty(input.Substring) {
-		c.AbortWithStatus(http.StatusBadRequest)
-		return
-	}
-	if !util.Contains(&Categories, input.Category) {
+	transactionID := c.Param("transaction_id")
+	if util.ContainsEmpty(transactionID) {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
-	doc := &bson.D{
-		{Key: "user_id", Value: *userID},
-		{Key: "substring", Value: input.Substring},
-		{Key: "category", Value: input.Category},
-		{Key: "created_at", Value: time.Now()},
+	var transaction *Transaction
+	err = h.Db.Transactions.
+		FindOneAndDelete(ctx, bson.M{"transaction_id": transactionID, "user_id": *userID}).
+		Decode(&transaction)
+	if err == mongo.ErrNoDocuments {
+		return
 	}
-	_, err = h.Db.Rules.InsertOne(ctx, doc)
 	if err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	// update all transactions with rules
-	if !h.applyNewRule(ctx, userID, input.Substring, input.Category) {
+	// the other side of a transfer, the expenses a payment settled and the refunds of a purchase
+	// count towards totals again
+	if err = deleteTransfer(ctx, h.Db, userID, transaction.TransactionID); err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
-}
-
-func (h *Handler) applyNewRule(ctx context.Context, userID *primitive.ObjectID, substring, category string) bool {
-	success := true
-	var transactions []*Transaction
-	cursor, _ := h.Db.Transactions.Find(ctx, bson.M{"user_id": *userID})
-	if err := cursor.All(ctx, &transactions); err != nil {
-		log.Printf("error updating transaction with new rule: %v", err)
-		success = false
-	}
-
-	for _, transaction := range transactions {
-		if strings.Contains(util.RemoveDuplicateWhitespace(transaction.Name), substring) {
-			amount := NormalizeAmount(transaction.Amount, category)
-
-			filter := bson.M{"transaction_id": transaction.TransactionID, "user_id": *userID}
-			update := bson.M{"$set": bson.M{"category": category, "amount": amount}}
-			_, err := h.Db.Transactions.UpdateOne(ctx, filter, update)
-			if err != nil {
-				log.Printf("error updating transaction with new rule: %v", err)
-				success = false
-			}
+	if len(transaction.Reimburses) > 0 {
+		if err = releaseReimbursed(ctx, h.Db, userID, transaction.TransactionID); err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+	if transaction.Amount < 0 {
+		if err = releaseRefunds(ctx, h.Db, userID, transaction.TransactionID); err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
 		}
 	}
-
-	return success
 }
 
-func (h *Handler) DeleteTransaction(c *gin.Context) {
+func (h *Handler) GetTransactions(c *gin.Context) {
 	ctx := c.Request.Context()
 	userID, err := auth.AuthorizeUser(c, h.Db)
 	if err != nil {
@@ -179,139 +161,99 @@ func (h *Handler) DeleteTransaction(c *gin.Context) {
 		return
 	}
 
-	transactionID := c.Param("transaction_id")
-	if util.ContainsEmpty(transactionID) {
-		c.AbortWithStatus(http.StatusBadRequest)
-		return
-	}
-
-	_, err = h.Db.Transactions.DeleteOne(ctx, bson.M{"transaction_id": transactionID, "user_id": *userID})
+	categories, err := GetUserCategories(ctx, h.Db, userID)
 	if err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
-}
 
-func (h *Handler) DeleteRule(c *gin.Context) {
-	ctx := c.Request.Context()
-	userID, err := auth.AuthorizeUser(c, h.Db)
+	query, err := ParseTransactionQuery(c, userID, categories)
 	if err != nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
-		return
-	}
-
-	ruleIDHex := c.Param("rule_id")
-	if util.ContainsEmpty(ruleIDHex) {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
-	ruleObjID, err := primitive.ObjectIDFromHex(ruleIDHex)
-	if err != nil {
-		c.AbortWithStatus(http.StatusInternalServerError)
-		return
+	// fetch one extra row to know if there's another page
+	opts := options.Find().SetSort(query.SortDoc())
+	if query.Limit > 0 {
+		opts.SetLimit(query.Limit + 1)
 	}
-
-	_, err = h.Db.Rules.DeleteOne(ctx, bson.M{"_id": ruleObjID, "user_id": *userID})
+	transactions := []*Transaction{}
+	cursor, err := h.Db.Transactions.Find(ctx, query.CursorFilter(), opts)
 	if err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
-}
-
-func (h *Handler) GetRules(c *gin.Context) {
-	ctx := c.Request.Context()
-	userID, err := auth.AuthorizeUser(c, h.Db)
-	if err != nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
-		return
-	}
-
-	var rules []*Rule
-	opts := options.Find().SetSort(bson.D{{Key: "substring", Value: -1}})
-	cursor, _ := h.Db.Rules.Find(ctx, bson.M{
-		"user_id": *userID,
-	}, opts)
-	if err = cursor.All(ctx, &rules); err != nil {
+	if err = cursor.All(ctx, &transactions); err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"rules": rules,
-	})
-}
-
-func (h *Handler) GetTransactions(c *gin.Context) {
-	ctx := c.Request.Context()
-	userID, err := auth.AuthorizeUser(c, h.Db)
-	if err != nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
-		return
+	nextCursor := ""
+	if query.Limit > 0 && int64(len(transactions)) > query.Limit {
+		transactions = transactions[:query.Limit]
+		nextCursor = NewPageCursor(transactions[len(transactions)-1]).Encode()
 	}
 
-	hasFilter := false
-	monthStr := c.Query("month")
-	yearStr := c.Query("year")
-	month := 0
-	year := 0
-	if !util.ContainsEmpty(monthStr, yearStr) {
-		month, err = strconv.Atoi(monthStr)
-		if err != nil {
-			c.AbortWithStatus(http.StatusBadRequest)
-			return
+	if len(transactions) > 0 {
+		// rates are only loaded for the dates on this page
+		since, until := transactions[0].Date, transactions[0].Date
+		for _, t := range transactions {
+			if t.Date.Before(since) {
+				since = t.Date
+			}
+			if t.Date.After(until) {
+				until = t.Date
+			}
 		}
-		year, err = strconv.Atoi(yearStr)
+		converter, err := h.newConverter(ctx, userID, since, until)
 		if err != nil {
-			c.AbortWithStatus(http.StatusBadRequest)
+			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
-		hasFilter = true
+		for _, t := range transactions {
+			t.ConvertedAmount = converter.Convert(t.Amount, t.Currency, t.Date)
+			t.BaseCurrency = converter.Base
+		}
 	}
 
-	var transactions []*Transaction
-	opts := options.Find().SetSort(bson.D{{Key: "date", Value: -1}})
-	cursor, _ := h.Db.Transactions.Find(ctx, bson.M{
-		"user_id": *userID,
-	}, opts)
-	if err = cursor.All(ctx, &transactions); err != nil {
+	years, err := h.transactionYears(ctx, userID)
+	if err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	fromDate := time.Now()
-	toDate := time.Now()
-	if hasFilter {
-		fromDate = time.Date(year, util.GetMonth(month), 0, 0, 0, 0, 0, time.UTC)
-		toDate = time.Date(year, util.GetMonth(month+1), 1, 0, 0, 0, 0, time.UTC)
-	}
-
-	filtered := []*Transaction{}
-	years := []int{}
-	for _, t := range transactions {
-		if hasFilter && t.Date.After(fromDate) && t.Date.Before(toDate) {
-			filtered = append(filtered, t)
-		}
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": transactions,
+		"count":        len(transactions),
+		"years":        years,
+		"next_cursor":  nextCursor,
+	})
+}
 
-		year := t.Date.Year()
-		if !util.ContainsInt(&years, year) {
-			years = append(years, year)
-		}
+// Returns every year the user has transactions in, most recent first
+func (h *Handler) transactionYears(ctx context.Context, userID *primitive.ObjectID) ([]int, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": *userID}}},
+		{{Key: "$group", Value: bson.M{"_id": bson.M{"$year": "$date"}}}},
+		{{Key: "$sort", Value: bson.M{"_id": -1}}},
+	}
+	cursor, err := h.Db.Transactions.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		Year int `bson:"_id"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
 	}
 
-	if hasFilter {
-		c.JSON(http.StatusOK, gin.H{
-			"transactions": filtered,
-			"count":        len(filtered),
-			"years":        years,
-		})
-	} else {
-		c.JSON(http.StatusOK, gin.H{
-			"transactions": transactions,
-			"count":        len(transactions),
-			"years":        years,
-		})
+	years := []int{}
+	for _, result := range results {
+		years = append(years, result.Year)
 	}
+	return years, nil
 }
 
 func (h *Handler) CreateTransaction(c *gin.Context) {
@@ -325,10 +267,12 @@ func (h *Handler) CreateTransaction(c *gin.Context) {
 	}
 
 	type Input struct {
-		Date     string `json:"date" validate:"required"`
-		Name     string `json:"name" validate:"required"`
-		Category string `json:"category" validate:"required"`
-		Amount   string `json:"amount" validate:"required"`
+		Date     string   `json:"date" validate:"required"`
+		Name     string   `json:"name" validate:"required"`
+		Category string   `json:"category" validate:"required"`
+		Amount   string   `json:"amount" validate:"required"`
+		Tags     []string `json:"tags"`
+		Notes    string   `json:"notes"`
 	}
 
 	var input *Input
@@ -348,12 +292,17 @@ func (h *Handler) CreateTransaction(c *gin.Context) {
 		return
 	}
 
-	if !util.Contains(&Categories, input.Category) {
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if !categories.Contains(input.Category) {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
-	parsedAmount, err := strconv.ParseFloat(strings.Replace(input.Amount, "-", "", -1), 32)
+	parsedAmount, err := money.Parse(strings.Replace(input.Amount, "-", "", -1))
 	if err != nil {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
@@ -378,7 +327,7 @@ func (h *Handler) CreateTransaction(c *gin.Context) {
 		return
 	}
 
-	amount := NormalizeAmount(float32(parsedAmount), input.Category)
+	amount := NormalizeAmount(parsedAmount, categories.Kind(input.Category))
 	transactionID := uuid.New().String()
 
 	doc := bson.D{
@@ -386,7 +335,13 @@ func (h *Handler) CreateTransaction(c *gin.Context) {
 		{Key: "enrollment_id", Value: "user_created"},
 		{Key: "name", Value: util.RemoveDuplicateWhitespace(input.Name)},
 		{Key: "category", Value: input.Category},
+		{Key: "original_category", Value: input.Category},
+		{Key: "original_name", Value: util.RemoveDuplicateWhitespace(input.Name)},
+		{Key: "merchant", Value: merchant.Normalize(input.Name)},
 		{Key: "amount", Value: amount},
+		{Key: "currency", Value: money.DefaultCurrency},
+		{Key: "tags", Value: NormalizeTags(input.Tags)},
+		{Key: "notes", Value: strings.TrimSpace(input.Notes)},
 		{Key: "date", Value: dateZeroed},
 		{Key: "user_id", Value: *userID},
 		{Key: "account_id", Value: "user_created"},
@@ -417,6 +372,10 @@ func (h *Handler) UpdateTransaction(c *gin.Context) {
 		Name          string `json:"name" validate:"required"`
 		Category      string `json:"category" validate:"required"`
 		Amount        string `json:"amount" validate:"required"`
+
+		// left unchanged when missing
+		Tags  *[]string `json:"tags"`
+		Notes *string   `json:"notes"`
 	}
 
 	var input *Input
@@ -436,12 +395,17 @@ func (h *Handler) UpdateTransaction(c *gin.Context) {
 		return
 	}
 
-	if !util.Contains(&Categories, input.Category) {
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if !categories.Contains(input.Category) {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
-	parsedAmount, err := strconv.ParseFloat(strings.Replace(input.Amount, "-", "", -1), 32)
+	parsedAmount, err := money.Parse(strings.Replace(input.Amount, "-", "", -1))
 	if err != nil {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
@@ -466,19 +430,76 @@ func (h *Handler) UpdateTransaction(c *gin.Context) {
 		return
 	}
 
-	amount := NormalizeAmount(float32(parsedAmount), input.Category)
+	var transaction *Transaction
+	if err = h.Db.Transactions.
+		FindOne(ctx, bson.M{"user_id": *userID, "transaction_id": input.TransactionID}).
+		Decode(&transaction); err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	amount := NormalizeAmount(parsedAmount, categories.Kind(input.Category))
+	// refunds stay positive in the category of their purchase
+	if transaction.RefundOf != "" && input.Category == transaction.Category {
+		amount = amount.Abs()
+	}
 	filter := bson.M{"transaction_id": input.TransactionID, "user_id": *userID}
-	update := bson.M{"$set": bson.M{
+	set := bson.M{
 		"date":     dateZeroed,
 		"name":     input.Name,
 		"category": input.Category,
 		"amount":   amount,
-	}}
+	}
+	if input.Tags != nil {
+		set["tags"] = NormalizeTags(*input.Tags)
+	}
+	if input.Notes != nil {
+		set["notes"] = strings.TrimSpace(*input.Notes)
+	}
+
+	// lock every field the user changed
+	locks := []string{}
+	unset := bson.M{}
+	if !dateZeroed.Equal(transaction.Date) {
+		locks = append(locks, LockDate)
+	}
+	if input.Name != transaction.Name {
+		locks = append(locks, LockName)
+	}
+	if input.Category != transaction.Category {
+		// categorized by hand now rather than by a rule
+		locks = append(locks, LockCategory)
+		unset["rule_id"] = ""
+		unset["confidence"] = ""
+		unset["refund_of"] = ""
+	}
+	// a new category can flip the sign, only a new magnitude was edited by hand
+	if amount.Abs() != transaction.Amount.Abs() {
+		locks = append(locks, LockAmount)
+	}
+	if input.Tags != nil && !sameTags(set["tags"].([]string), transaction.Tags) {
+		locks = append(locks, LockTags)
+	}
+	// splits no longer add up once the amount changes
+	if amount.Abs() != transaction.Amount.Abs() {
+		unset["splits"] = ""
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if len(locks) > 0 {
+		update["$addToSet"] = lockFields(locks...)
+	}
 	_, err = h.Db.Transactions.UpdateOne(ctx, filter, update)
 	if err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	if err = h.recordRecategorization(ctx, userID, transaction, input.Category); err != nil {
+		log.Printf("error recording recategorization: %v", err)
+	}
 }
 
 func (h *Handler) UpdateCategory(c *gin.Context) {
@@ -513,7 +534,12 @@ func (h *Handler) UpdateCategory(c *gin.Context) {
 		return
 	}
 
-	if !util.Contains(&Categories, input.Category) {
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if !categories.Contains(input.Category) {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
@@ -526,15 +552,26 @@ func (h *Handler) UpdateCategory(c *gin.Context) {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
-	amount := NormalizeAmount(transaction.Amount, input.Category)
+	// picking the same category again changes nothing
+	if input.Category == transaction.Category {
+		return
+	}
+	amount := NormalizeAmount(transaction.Amount, categories.Kind(input.Category))
 
 	filter := bson.M{"transaction_id": input.TransactionID, "user_id": *userID}
-	update = bson.M{"$set": bson.M{"category": input.Category, "amount": amount}}
+	update = bson.M{
+		"$set":      bson.M{"category": input.Category, "amount": amount},
+		"$unset":    bson.M{"splits": "", "rule_id": "", "confidence": "", "refund_of": ""},
+		"$addToSet": lockFields(LockCategory),
+	}
 	_, err = h.Db.Transactions.UpdateOne(ctx, filter, update)
 	if err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	if err = h.recordRecategorization(ctx, userID, transaction, input.Category); err != nil {
+		log.Printf("error recording recategorization: %v", err)
+	}
 }
 
 func (h *Handler) GetAccounts(c *gin.Context) {
@@ -563,14 +600,14 @@ func (h *Handler) GetAccounts(c *gin.Context) {
 	})
 }
 
-// make transaction amount positive if category to changed to 'income'
-// make transaction amount negative if category is not 'income'/'ignore'
-func NormalizeAmount(amount float32, category string) float32 {
+// make transaction amount positive if category kind is 'income'
+// make transaction amount negative if category kind is not 'income'/'ignore'
+func NormalizeAmount(amount money.Amount, kind string) money.Amount {
 	normalized := amount
-	if category == "income" && amount < 0 {
+	if kind == Income && amount < 0 {
 		normalized = -1 * amount
 	}
-	if category != "income" && category != "ignore" && amount > 0 {
+	if kind != Income && kind != Ignore && amount > 0 {
 		normalized = -1 * amount
 	}
 	return normalized
@@ -0,0 +1,590 @@
+package finances
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/importer"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Enrollment of accounts the user created for statements from banks teller doesn't support
+const ManualEnrollment = "manual"
+
+// Largest statement file accepted for import
+const maxImportSize = 10 << 20
+
+// ImportProfile is a saved CSV column mapping for one bank's exports
+type ImportProfile struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id"`
+	UserID primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Name   string             `json:"name" bson:"name"`
+
+	importer.Mapping `bson:",inline"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// ImportedRow is a statement line as it is, or would be, imported
+type ImportedRow struct {
+	Line        int          `json:"line"`
+	Duplicate   bool         `json:"duplicate"`
+	Transaction *Transaction `json:"transaction"`
+}
+
+// Returns a stable transaction id for a statement row so importing the same rows again is
+// de-duplicated by the unique index. occurrence tells apart identical rows within one statement.
+func ImportTransactionID(accountID string, row *importer.Row, occurrence int) string {
+	key := fmt.Sprintf("%s|%s|%d|%s|%d", accountID, row.Date.Format("2006-01-02"), row.Amount, strings.ToLower(row.Description), occurrence)
+	sum := sha1.Sum([]byte(key))
+	return "import-" + hex.EncodeToString(sum[:])
+}
+
+// Returns one of the user's manual accounts
+func findManualAccount(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID, accountID string) (*Account, error) {
+	var account *Account
+	err := db.Accounts.FindOne(ctx, bson.M{
+		"user_id":       *userID,
+		"account_id":    accountID,
+		"enrollment_id": ManualEnrollment,
+	}).Decode(&account)
+	return account, err
+}
+
+// Categorizes statement transactions for an account and flags the ones already imported
+func prepareImport(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID, account *Account, transactions []*Transaction, lines []int) ([]*ImportedRow, error) {
+	categorizer, err := NewCategorizer(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, t := range transactions {
+		t.UserID = *userID
+		t.AccountID = account.AccountID
+		t.EnrollmentID = account.EnrollmentID
+		if t.Currency == "" {
+			t.Currency = account.Currency
+		}
+		categorizer.Categorize(t, "", account.Institution)
+		ids = append(ids, t.TransactionID)
+	}
+
+	existing, err := db.Transactions.Distinct(ctx, "transaction_id", bson.M{"transaction_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	imported := map[string]bool{}
+	for _, id := range existing {
+		if s, ok := id.(string); ok {
+			imported[s] = true
+		}
+	}
+
+	rows := []*ImportedRow{}
+	for i, t := range transactions {
+		rows = append(rows, &ImportedRow{Line: lines[i], Duplicate: imported[t.TransactionID], Transaction: t})
+	}
+	return rows, nil
+}
+
+// Saves the new rows of an import, returning how many were saved
+func insertImported(ctx context.Context, db *db.MongoDb, rows []*ImportedRow) (int, error) {
+	docs := []interface{}{}
+	for _, row := range rows {
+		if row.Duplicate {
+			continue
+		}
+		t := row.Transaction
+		doc := bson.D{
+			{Key: "transaction_id", Value: t.TransactionID},
+			{Key: "enrollment_id", Value: t.EnrollmentID},
+			{Key: "name", Value: t.Name},
+			{Key: "category", Value: t.Category},
+			{Key: "original_category", Value: t.OriginalCategory},
+			{Key: "original_name", Value: t.OriginalName},
+			{Key: "merchant", Value: t.Merchant},
+			{Key: "rule_id", Value: t.RuleID},
+			{Key: "amount", Value: t.Amount},
+			{Key: "currency", Value: t.Currency},
+			{Key: "tags", Value: t.Tags},
+			{Key: "notes", Value: t.Notes},
+			{Key: "date", Value: t.Date},
+			{Key: "user_id", Value: t.UserID},
+			{Key: "account_id", Value: t.AccountID},
+			{Key: "created_at", Value: time.Now()},
+			{Key: "updated_at", Value: time.Now()},
+		}
+		if t.Confidence > 0 {
+			doc = append(doc, bson.E{Key: "confidence", Value: t.Confidence})
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) == 0 {
+		return 0, nil
+	}
+
+	// rows saved by an import running at the same time are skipped as duplicates
+	inserted := len(docs)
+	_, err := db.Transactions.InsertMany(ctx, docs, &options.InsertManyOptions{
+		Ordered: util.BoolPointer(false),
+	})
+	if err != nil && !strings.Contains(err.Error(), "duplicate key error") {
+		return 0, err
+	}
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) {
+		inserted -= len(bulkErr.WriteErrors)
+	}
+	return inserted, nil
+}
+
+// Links imported transactions to purchases they refund and to transfers, like a teller sync does.
+// Only the dates the new rows cover are looked at.
+func linkImported(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID, rows []*ImportedRow) {
+	var since time.Time
+	for _, row := range rows {
+		if !row.Duplicate && (since.IsZero() || row.Transaction.Date.Before(since)) {
+			since = row.Transaction.Date
+		}
+	}
+	if since.IsZero() {
+		return
+	}
+
+	if _, err := DetectRefunds(ctx, db, userID, since); err != nil {
+		log.Printf("error detecting refunds for user %s: %v", userID.Hex(), err)
+	}
+	if _, err := DetectTransfers(ctx, db, userID, since); err != nil {
+		log.Printf("error detecting transfers for user %s: %v", userID.Hex(), err)
+	}
+}
+
+// Creates an account to import statements into
+func (h *Handler) CreateAccount(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	type Input struct {
+		Name        string `json:"name" validate:"required"`
+		Institution string `json:"institution"`
+		AccountType string `json:"account_type" validate:"omitempty,oneof=depository credit"`
+		Subtype     string `json:"subtype"`
+		LastFour    string `json:"last_four" validate:"omitempty,len=4,numeric"`
+		Currency    string `json:"currency" validate:"omitempty,len=3,alpha"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = v.Struct(input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	account := &Account{
+		ID:           primitive.NewObjectID(),
+		UserID:       *userID,
+		AccountID:    uuid.New().String(),
+		EnrollmentID: ManualEnrollment,
+		AccountType:  input.AccountType,
+		Subtype:      input.Subtype,
+		LastFour:     input.LastFour,
+		Status:       "open",
+		Name:         util.RemoveDuplicateWhitespace(strings.TrimSpace(input.Name)),
+		Institution:  util.RemoveDuplicateWhitespace(strings.TrimSpace(input.Institution)),
+		Currency:     strings.ToUpper(input.Currency),
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	if account.AccountType == "" {
+		account.AccountType = "depository"
+	}
+	if account.Currency == "" {
+		account.Currency = money.DefaultCurrency
+	}
+	if _, err = h.Db.Accounts.InsertOne(ctx, account); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"account": account,
+	})
+}
+
+func (h *Handler) GetImportProfiles(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	var profiles []*ImportProfile
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := h.Db.ImportProfiles.Find(ctx, bson.M{"user_id": *userID}, opts)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = cursor.All(ctx, &profiles); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"profiles": profiles,
+	})
+}
+
+// Saves a CSV column mapping under a name, replacing the user's profile of the same name
+func (h *Handler) SaveImportProfile(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	var profile *ImportProfile
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(bodyBytes, &profile)
+	if err != nil || profile == nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	profile.Name = util.RemoveDuplicateWhitespace(strings.TrimSpace(profile.Name))
+	if profile.Name == "" || profile.Mapping.Validate() != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var existing *ImportProfile
+	err = h.Db.ImportProfiles.FindOne(ctx, bson.M{"user_id": *userID, "name": profile.Name}).Decode(&existing)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		profile.ID = primitive.NewObjectID()
+		profile.CreatedAt = time.Now()
+	case err != nil:
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	default:
+		profile.ID, profile.CreatedAt = existing.ID, existing.CreatedAt
+	}
+	profile.UserID = *userID
+	profile.UpdatedAt = time.Now()
+
+	_, err = h.Db.ImportProfiles.ReplaceOne(ctx, bson.M{"_id": profile.ID}, profile, options.Replace().SetUpsert(true))
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"profile": profile,
+	})
+}
+
+func (h *Handler) DeleteImportProfile(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	profileID, err := primitive.ObjectIDFromHex(c.Param("profile_id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.Db.ImportProfiles.DeleteOne(ctx, bson.M{"_id": profileID, "user_id": *userID})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+}
+
+// Imports a bank's CSV export into a manual account using a saved mapping profile. Rows are
+// categorized like synced transactions and ones imported before are skipped. With preview=true
+// the parsed rows are returned without saving anything.
+func (h *Handler) ImportCSV(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil || header.Size > maxImportSize {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	profileID, err := primitive.ObjectIDFromHex(c.PostForm("profile_id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	preview := c.PostForm("preview") == "true"
+
+	var profile *ImportProfile
+	if err = h.Db.ImportProfiles.FindOne(ctx, bson.M{"_id": profileID, "user_id": *userID}).Decode(&profile); err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	account, err := findManualAccount(ctx, h.Db, userID, c.PostForm("account_id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	parsed, rowErrors, err := importer.ParseCSV(file, &profile.Mapping)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	// identical rows on the same day are separate transactions, numbered in the order they appear
+	occurrences := map[string]int{}
+	transactions := []*Transaction{}
+	lines := []int{}
+	for _, row := range parsed {
+		id := ImportTransactionID(account.AccountID, row, 0)
+		transactions = append(transactions, &Transaction{
+			TransactionID: ImportTransactionID(account.AccountID, row, occurrences[id]),
+			Name:          row.Description,
+			Amount:        row.Amount,
+			Date:          row.Date,
+		})
+		lines = append(lines, row.Line)
+		occurrences[id]++
+	}
+
+	rows, err := prepareImport(ctx, h.Db, userID, account, transactions, lines)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	duplicates := 0
+	for _, row := range rows {
+		if row.Duplicate {
+			duplicates++
+		}
+	}
+	if preview {
+		c.JSON(http.StatusOK, gin.H{
+			"rows":       rows,
+			"duplicates": duplicates,
+			"errors":     rowErrors,
+		})
+		return
+	}
+
+	imported, err := insertImported(ctx, h.Db, rows)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if imported > 0 {
+		linkImported(ctx, h.Db, userID, rows)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"imported":   imported,
+		"duplicates": len(rows) - imported,
+		"errors":     rowErrors,
+	})
+}
+
+// Returns the transaction id of an OFX entry. FITIDs are only unique within an account, so
+// they're kept stable but scoped to the account they're imported into.
+func OFXTransactionID(accountID, fitID string) string {
+	return "ofx-" + accountID + "-" + fitID
+}
+
+// Returns the statement for an account from an OFX file, files with several accounts are matched
+// by the account's last four digits
+func statementFor(account *Account, statements []*importer.Statement) *importer.Statement {
+	if len(statements) == 1 {
+		return statements[0]
+	}
+	if account.LastFour == "" {
+		return nil
+	}
+	for _, statement := range statements {
+		if strings.HasSuffix(statement.AccountID, account.LastFour) {
+			return statement
+		}
+	}
+	return nil
+}
+
+// Imports an OFX or QFX statement into a manual account. Entries keep their FITID so importing
+// an overlapping statement skips what was already imported, and the ledger balance becomes the
+// account balance unless a later statement was imported before. With preview=true the parsed
+// entries are returned without saving anything.
+func (h *Handler) ImportOFX(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil || header.Size > maxImportSize {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	preview := c.PostForm("preview") == "true"
+
+	account, err := findManualAccount(ctx, h.Db, userID, c.PostForm("account_id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	statements, err := importer.ParseOFX(file)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	statement := statementFor(account, statements)
+	if statement == nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	transactions := []*Transaction{}
+	lines := []int{}
+	for i, entry := range statement.Transactions {
+		t := &Transaction{
+			TransactionID: OFXTransactionID(account.AccountID, entry.FITID),
+			Name:          entry.Name,
+			Amount:        entry.Amount,
+			Currency:      statement.Currency,
+			Date:          entry.Date,
+		}
+		if entry.Memo != entry.Name {
+			t.Notes = entry.Memo
+		}
+		transactions = append(transactions, t)
+		lines = append(lines, i+1)
+	}
+
+	rows, err := prepareImport(ctx, h.Db, userID, account, transactions, lines)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	duplicates := 0
+	for _, row := range rows {
+		if row.Duplicate {
+			duplicates++
+		}
+	}
+	if preview {
+		c.JSON(http.StatusOK, gin.H{
+			"rows":         rows,
+			"duplicates":   duplicates,
+			"balance":      statement.Balance,
+			"balance_date": statement.BalanceDate,
+		})
+		return
+	}
+
+	imported, err := insertImported(ctx, h.Db, rows)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if imported > 0 {
+		linkImported(ctx, h.Db, userID, rows)
+	}
+
+	if statement.Balance != nil {
+		_, err = h.Db.Accounts.UpdateOne(
+			ctx,
+			bson.M{
+				"_id": account.ID,
+				"$or": bson.A{
+					bson.M{"balance_as_of": bson.M{"$exists": false}},
+					bson.M{"balance_as_of": bson.M{"$lte": statement.BalanceDate}},
+				},
+			},
+			bson.M{"$set": bson.M{
+				"balance":       *statement.Balance,
+				"balance_as_of": statement.BalanceDate,
+				"updated_at":    time.Now(),
+			}},
+		)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"imported":   imported,
+		"duplicates": len(rows) - imported,
+	})
+}
@@ -0,0 +1,103 @@
+package finances
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/util"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Fields a user edit locks so rules and syncing leave them alone
+const (
+	LockCategory = "category"
+	LockName     = "name"
+	LockAmount   = "amount"
+	LockDate     = "date"
+	LockTags     = "tags"
+)
+
+var LockableFields = []string{LockCategory, LockName, LockAmount, LockDate, LockTags}
+
+func (t *Transaction) IsLocked(field string) bool {
+	return util.Contains(&t.Locked, field)
+}
+
+// Returns the update operator that adds fields to a transaction's locks
+func lockFields(fields ...string) bson.M {
+	return bson.M{"locked": bson.M{"$each": fields}}
+}
+
+// Returns true if both tag lists hold the same tags
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, tag := range a {
+		if !util.Contains(&b, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// Unlocks fields of a transaction so rules can change them again, no fields unlocks everything
+func (h *Handler) UnlockTransaction(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	transactionID := c.Param("transaction_id")
+	if util.ContainsEmpty(transactionID) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	type Input struct {
+		Fields []string `json:"fields"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if len(bodyBytes) > 0 {
+		if err = json.Unmarshal(bodyBytes, &input); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	update := bson.M{"$unset": bson.M{"locked": ""}}
+	if input != nil && len(input.Fields) > 0 {
+		for _, field := range input.Fields {
+			if !util.Contains(&LockableFields, field) {
+				c.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+		}
+		update = bson.M{"$pull": bson.M{"locked": bson.M{"$in": input.Fields}}}
+	}
+	update["$set"] = bson.M{"updated_at": time.Now()}
+
+	result, err := h.Db.Transactions.UpdateOne(ctx, bson.M{"user_id": *userID, "transaction_id": transactionID}, update)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+}
@@ -0,0 +1,211 @@
+package finances
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/merchant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Merchant holds the user's settings for a normalized merchant name
+type Merchant struct {
+	ID              primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID          primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Name            string             `json:"name" bson:"name"`
+	Key             string             `json:"-" bson:"key"`
+	DisplayName     string             `json:"display_name" bson:"display_name"`
+	DefaultCategory string             `json:"default_category" bson:"default_category,omitempty"`
+	LogoURL         string             `json:"logo_url" bson:"logo_url,omitempty"`
+	Transactions    int                `json:"transactions" bson:"-"`
+	CreatedAt       time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt       time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// MerchantSet is the user's saved merchants by key
+type MerchantSet map[string]*Merchant
+
+// Returns the saved merchant for a transaction merchant, nil if there isn't one
+func (ms MerchantSet) Get(name string) *Merchant {
+	return ms[merchant.Key(name)]
+}
+
+// Returns the name a merchant is shown as
+func (ms MerchantSet) DisplayName(name string) string {
+	if m := ms.Get(name); m != nil && m.DisplayName != "" {
+		return m.DisplayName
+	}
+	return name
+}
+
+// Returns the category new transactions from a merchant get when rules don't set one
+func (ms MerchantSet) DefaultCategory(name string, categories CategorySet) string {
+	if m := ms.Get(name); m != nil && categories.Contains(m.DefaultCategory) {
+		return m.DefaultCategory
+	}
+	return ""
+}
+
+func GetUserMerchants(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID) (MerchantSet, error) {
+	var merchants []*Merchant
+	cursor, err := db.Merchants.Find(ctx, bson.M{"user_id": *userID})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &merchants); err != nil {
+		return nil, err
+	}
+	set := MerchantSet{}
+	for _, m := range merchants {
+		set[m.Key] = m
+	}
+	return set, nil
+}
+
+// Returns every merchant the user has transactions with along with their saved settings
+func (h *Handler) GetMerchants(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	saved, err := GetUserMerchants(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	cursor, err := h.Db.Transactions.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": *userID, "merchant": bson.M{"$nin": bson.A{nil, ""}}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$merchant", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	var results []struct {
+		Name  string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	byKey := map[string]*Merchant{}
+	for key, m := range saved {
+		byKey[key] = m
+	}
+	for _, result := range results {
+		key := merchant.Key(result.Name)
+		m, ok := byKey[key]
+		if !ok {
+			m = &Merchant{UserID: *userID, Name: result.Name, Key: key, DisplayName: result.Name}
+			byKey[key] = m
+		}
+		m.Transactions += result.Count
+	}
+
+	merchants := []*Merchant{}
+	for _, m := range byKey {
+		merchants = append(merchants, m)
+	}
+	sort.Slice(merchants, func(i, j int) bool {
+		return strings.ToLower(merchants[i].DisplayName) < strings.ToLower(merchants[j].DisplayName)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"merchants": merchants,
+	})
+}
+
+// Saves the display name, default category and logo of a merchant
+func (h *Handler) UpdateMerchant(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	type Input struct {
+		Name            string `json:"name" validate:"required"`
+		DisplayName     string `json:"display_name"`
+		DefaultCategory string `json:"default_category"`
+		LogoURL         string `json:"logo_url" validate:"omitempty,url"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = v.Struct(input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	name := strings.Join(strings.Fields(input.Name), " ")
+	displayName := strings.Join(strings.Fields(input.DisplayName), " ")
+	if displayName == "" {
+		displayName = name
+	}
+	if input.DefaultCategory != "" {
+		categories, err := GetUserCategories(ctx, h.Db, userID)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if !categories.Contains(input.DefaultCategory) {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	var saved *Merchant
+	err = h.Db.Merchants.FindOneAndUpdate(
+		ctx,
+		bson.M{"user_id": *userID, "key": merchant.Key(name)},
+		bson.M{
+			"$set": bson.M{
+				"name":             name,
+				"display_name":     displayName,
+				"default_category": input.DefaultCategory,
+				"logo_url":         input.LogoURL,
+				"updated_at":       time.Now(),
+			},
+			"$setOnInsert": bson.M{"created_at": time.Now()},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&saved)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"merchant": saved,
+	})
+}
@@ -0,0 +1,205 @@
+package finances
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/merchant"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/recurring"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How far back transactions are scanned for recurring charges
+const recurringLookbackYears = 2
+
+// RecurringSeries is a subscription, bill or paycheck detected in the user's transactions
+type RecurringSeries struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID         primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Key            string             `json:"-" bson:"key"`
+	Merchant       string             `json:"merchant" bson:"merchant"`
+	Category       string             `json:"category" bson:"category"`
+	Currency       string             `json:"currency" bson:"currency"`
+	Cadence        string             `json:"cadence" bson:"cadence"`
+	AverageAmount  money.Amount       `json:"average_amount" bson:"average_amount"`
+	LastAmount     money.Amount       `json:"last_amount" bson:"last_amount"`
+	PreviousAmount money.Amount       `json:"previous_amount" bson:"previous_amount"`
+	LastDate       time.Time          `json:"last_date" bson:"last_date"`
+	NextDate       time.Time          `json:"next_date" bson:"next_date"`
+	Charges        int                `json:"charges" bson:"charges"`
+	TransactionIDs []string           `json:"transaction_ids" bson:"transaction_ids"`
+	PriceIncrease  bool               `json:"price_increase" bson:"price_increase"`
+	MissedCharges  int                `json:"missed_charges" bson:"missed_charges"`
+	Active         bool               `json:"active" bson:"active"`
+	DetectedAt     time.Time          `json:"detected_at" bson:"detected_at"`
+}
+
+// Scans the user's transactions for recurring charges and saves the series found. Charges are
+// grouped by merchant, currency and direction before looking for a cadence, and series keep
+// their id across detections as long as those and the cadence stay the same.
+func DetectRecurring(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID, now time.Time) ([]*RecurringSeries, error) {
+	categories, err := GetUserCategories(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var transactions []*Transaction
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: 1}})
+	cursor, err := db.Transactions.Find(ctx, countedInTotals(bson.M{
+		"user_id":    *userID,
+		"date":       bson.M{"$gte": now.AddDate(-recurringLookbackYears, 0, 0)},
+		"category":   bson.M{"$nin": categories.NamesOfKind(Ignore)},
+		"reimburses": bson.M{"$exists": false},
+	}), opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &transactions); err != nil {
+		return nil, err
+	}
+
+	type group struct {
+		merchant string
+		currency string
+		charges  []*recurring.Charge
+		byID     map[string]*Transaction
+	}
+	groups := map[string]*group{}
+	keys := []string{}
+	for _, t := range transactions {
+		name := t.Merchant
+		if name == "" {
+			name = merchant.Normalize(t.Name)
+		}
+		key := merchant.Key(name) + "|" + t.Currency
+		if t.Amount > 0 {
+			key += "|in"
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &group{merchant: name, currency: t.Currency, byID: map[string]*Transaction{}}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		g.charges = append(g.charges, &recurring.Charge{TransactionID: t.TransactionID, Date: t.Date, Amount: t.Amount})
+		g.byID[t.TransactionID] = t
+	}
+	sort.Strings(keys)
+
+	detected := []*RecurringSeries{}
+	seen := map[string]int{}
+	for _, key := range keys {
+		g := groups[key]
+		for _, s := range recurring.Detect(g.charges, now) {
+			// a merchant can have more than one series at the same cadence, told apart by amount
+			seriesKey := key + "|" + s.Cadence
+			seen[seriesKey]++
+			if seen[seriesKey] > 1 {
+				seriesKey += "|" + strconv.Itoa(seen[seriesKey])
+			}
+			series := &RecurringSeries{
+				UserID:         *userID,
+				Key:            seriesKey,
+				Merchant:       g.merchant,
+				Currency:       g.currency,
+				Cadence:        s.Cadence,
+				AverageAmount:  s.AverageAmount,
+				LastAmount:     s.LastAmount,
+				PreviousAmount: s.PreviousAmount,
+				LastDate:       s.LastDate,
+				NextDate:       s.NextDate,
+				Charges:        len(s.Charges),
+				TransactionIDs: []string{},
+				PriceIncrease:  s.PriceIncrease,
+				MissedCharges:  s.MissedCharges,
+				Active:         s.Active,
+				DetectedAt:     now,
+			}
+			for _, charge := range s.Charges {
+				series.TransactionIDs = append(series.TransactionIDs, charge.TransactionID)
+			}
+			series.Category = g.byID[s.Charges[len(s.Charges)-1].TransactionID].Category
+			detected = append(detected, series)
+		}
+	}
+
+	detectedKeys := []string{}
+	for _, series := range detected {
+		detectedKeys = append(detectedKeys, series.Key)
+		var saved struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		err = db.Recurring.FindOneAndUpdate(
+			ctx,
+			bson.M{"user_id": *userID, "key": series.Key},
+			bson.M{"$set": series},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After).SetProjection(bson.M{"_id": 1}),
+		).Decode(&saved)
+		if err != nil {
+			return nil, err
+		}
+		series.ID = saved.ID
+	}
+
+	// series that are no longer detected are removed
+	if _, err = db.Recurring.DeleteMany(ctx, bson.M{"user_id": *userID, "key": bson.M{"$nin": detectedKeys}}); err != nil {
+		return nil, err
+	}
+	return detected, nil
+}
+
+// Returns the user's recurring series soonest first. Series are detected again when asked
+// with refresh=true or when none have been saved yet, otherwise the last detection is returned.
+func (h *Handler) GetRecurring(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	var series []*RecurringSeries
+	cursor, err := h.Db.Recurring.Find(ctx, bson.M{"user_id": *userID})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = cursor.All(ctx, &series); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if len(series) == 0 || c.Query("refresh") == "true" {
+		series, err = DetectRecurring(ctx, h.Db, userID, time.Now())
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// ended series are only included when asked for
+	listed := []*RecurringSeries{}
+	for _, s := range series {
+		if s.Active || c.Query("include_inactive") == "true" {
+			listed = append(listed, s)
+		}
+	}
+	sort.Slice(listed, func(i, j int) bool {
+		if !listed[i].NextDate.Equal(listed[j].NextDate) {
+			return listed[i].NextDate.Before(listed[j].NextDate)
+		}
+		return listed[i].Merchant < listed[j].Merchant
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"recurring": listed,
+	})
+}
@@ -0,0 +1,288 @@
+package finances
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/merchant"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Most days after a purchase that a refund is matched to it
+const RefundWindowDays = 90
+
+// Returns the category a purchase counts towards, the largest split for split purchases
+func purchaseCategory(t *Transaction) string {
+	category := t.Category
+	var largest money.Amount
+	for _, split := range t.Splits {
+		if split.Amount.Abs() > largest {
+			category, largest = split.Category, split.Amount.Abs()
+		}
+	}
+	return category
+}
+
+// Returns the purchase a refund most likely belongs to: an earlier purchase from the same merchant within
+// the refund window with enough left unrefunded, preferring exact amounts and then the most recent.
+func MatchRefund(refund *Transaction, purchases []*Transaction, refunded map[string]money.Amount) *Transaction {
+	var best *Transaction
+	bestExact := false
+	for _, purchase := range purchases {
+		if purchase.Amount >= 0 || purchase.TransactionID == refund.TransactionID ||
+			merchant.Key(purchase.Merchant) != merchant.Key(refund.Merchant) || purchase.Merchant == "" ||
+			purchase.Date.After(refund.Date) || refund.Date.Sub(purchase.Date) > time.Duration(RefundWindowDays)*24*time.Hour {
+			continue
+		}
+		remaining := purchase.Amount.Abs() - refunded[purchase.TransactionID]
+		if remaining < refund.Amount.Abs() {
+			continue
+		}
+		exact := remaining == refund.Amount.Abs()
+		if best == nil || (exact && !bestExact) || (exact == bestExact && purchase.Date.After(best.Date)) {
+			best, bestExact = purchase, exact
+		}
+	}
+	return best
+}
+
+// Moves a refund into the category of its purchase as a positive amount so the two net out
+func linkRefund(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID, refund, purchase *Transaction, lock bool) error {
+	update := bson.M{
+		"$set": bson.M{
+			"category":   purchaseCategory(purchase),
+			"amount":     refund.Amount.Abs(),
+			"refund_of":  purchase.TransactionID,
+			"updated_at": time.Now(),
+		},
+		"$unset": bson.M{"rule_id": "", "confidence": ""},
+	}
+	if lock {
+		update["$addToSet"] = lockFields(LockCategory)
+	}
+	_, err := db.Transactions.UpdateOne(ctx, bson.M{"user_id": *userID, "transaction_id": refund.TransactionID}, update)
+	return err
+}
+
+// Links money coming back from merchants to the purchases it refunds. Only deposits that were
+// counted as income by default are considered, ones a rule or the user categorized are left alone.
+// Deposits from since on are looked at, a zero since looks at all of them.
+func DetectRefunds(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID, since time.Time) (int, error) {
+	categories, err := GetUserCategories(ctx, db, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	filter := bson.M{
+		"user_id":     *userID,
+		"amount":      bson.M{"$gt": 0},
+		"category":    bson.M{"$in": categories.NamesOfKind(Income)},
+		"merchant":    bson.M{"$nin": bson.A{nil, ""}},
+		"locked":      bson.M{"$ne": LockCategory},
+		"refund_of":   bson.M{"$exists": false},
+		"rule_id":     bson.M{"$exists": false},
+		"transfer_id": notTransfer,
+		"reimburses":  bson.M{"$exists": false},
+	}
+	if !since.IsZero() {
+		filter["date"] = bson.M{"$gte": since}
+	}
+	var refunds []*Transaction
+	cursor, err := db.Transactions.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	if err = cursor.All(ctx, &refunds); err != nil {
+		return 0, err
+	}
+	if len(refunds) == 0 {
+		return 0, nil
+	}
+
+	// purchases can be refunded up to the refund window before the earliest deposit
+	merchants := bson.A{}
+	earliest := refunds[0].Date
+	for _, refund := range refunds {
+		merchants = append(merchants, refund.Merchant)
+		if refund.Date.Before(earliest) {
+			earliest = refund.Date
+		}
+	}
+	var purchases []*Transaction
+	cursor, err = db.Transactions.Find(ctx, bson.M{
+		"user_id":  *userID,
+		"amount":   bson.M{"$lt": 0},
+		"merchant": bson.M{"$in": merchants},
+		"date":     bson.M{"$gte": earliest.AddDate(0, 0, -RefundWindowDays)},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err = cursor.All(ctx, &purchases); err != nil {
+		return 0, err
+	}
+
+	refunded, err := refundedAmounts(ctx, db, userID, purchases)
+	if err != nil {
+		return 0, err
+	}
+
+	sort.Slice(refunds, func(i, j int) bool { return refunds[i].Date.Before(refunds[j].Date) })
+	linked := 0
+	for _, refund := range refunds {
+		purchase := MatchRefund(refund, purchases, refunded)
+		if purchase == nil || categories.Kind(purchaseCategory(purchase)) != Expense {
+			continue
+		}
+		if err = linkRefund(ctx, db, userID, refund, purchase, false); err != nil {
+			return linked, err
+		}
+		refunded[purchase.TransactionID] += refund.Amount.Abs()
+		linked++
+	}
+	return linked, nil
+}
+
+// Returns how much of each of the purchases has been refunded
+func refundedAmounts(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID, purchases []*Transaction) (map[string]money.Amount, error) {
+	ids := bson.A{}
+	for _, purchase := range purchases {
+		ids = append(ids, purchase.TransactionID)
+	}
+	var refunds []*Transaction
+	cursor, err := db.Transactions.Find(ctx, bson.M{"user_id": *userID, "refund_of": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &refunds); err != nil {
+		return nil, err
+	}
+	refunded := map[string]money.Amount{}
+	for _, refund := range refunds {
+		refunded[refund.RefundOf] += refund.Amount.Abs()
+	}
+	return refunded, nil
+}
+
+// Counts the refunds of a purchase as income again
+func releaseRefunds(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID, purchaseID string) error {
+	categories, err := GetUserCategories(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	_, err = db.Transactions.UpdateMany(
+		ctx,
+		bson.M{"user_id": *userID, "refund_of": purchaseID},
+		bson.M{
+			"$set":   bson.M{"category": categories.IncomeCategory(), "updated_at": time.Now()},
+			"$unset": bson.M{"refund_of": ""},
+		},
+	)
+	return err
+}
+
+// Links a deposit to a purchase by hand as a refund, an empty refund_of unlinks a refund and counts it as income again
+func (h *Handler) UpdateRefund(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	transactionID := c.Param("transaction_id")
+	if util.ContainsEmpty(transactionID) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	type Input struct {
+		RefundOf string `json:"refund_of"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil || input == nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var refund *Transaction
+	if err = h.Db.Transactions.
+		FindOne(ctx, bson.M{"user_id": *userID, "transaction_id": transactionID}).
+		Decode(&refund); err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if input.RefundOf == "" {
+		if refund.RefundOf == "" {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		_, err = h.Db.Transactions.UpdateOne(
+			ctx,
+			bson.M{"user_id": *userID, "transaction_id": transactionID},
+			bson.M{
+				"$set": bson.M{
+					"category":   categories.IncomeCategory(),
+					"amount":     refund.Amount.Abs(),
+					"updated_at": time.Now(),
+				},
+				"$unset":    bson.M{"refund_of": ""},
+				"$addToSet": lockFields(LockCategory),
+			},
+		)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// only deposits can be refunds, linked refunds are stored as positive amounts too
+	if refund.Amount <= 0 || refund.TransferID != nil || len(refund.Splits) > 0 || len(refund.Reimburses) > 0 {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var purchase *Transaction
+	if err = h.Db.Transactions.
+		FindOne(ctx, bson.M{"user_id": *userID, "transaction_id": input.RefundOf}).
+		Decode(&purchase); err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if purchase.TransactionID == refund.TransactionID || purchase.Amount >= 0 || purchase.TransferID != nil ||
+		purchase.Currency != refund.Currency || categories.Kind(purchaseCategory(purchase)) != Expense {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err = linkRefund(ctx, h.Db, userID, refund, purchase, true); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,491 @@
+package finances
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/merchant"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Reimbursement statuses
+const (
+	ReimbursementOwed = "owed"
+	Reimbursed        = "reimbursed"
+)
+
+// Reimbursement marks an expense someone else, like an employer, owes back
+type Reimbursement struct {
+	Payer        string     `json:"payer" bson:"payer"`
+	Status       string     `json:"status" bson:"status"`
+	PaymentID    string     `json:"payment_id,omitempty" bson:"payment_id,omitempty"`
+	MarkedAt     time.Time  `json:"marked_at" bson:"marked_at"`
+	ReimbursedAt *time.Time `json:"reimbursed_at,omitempty" bson:"reimbursed_at,omitempty"`
+}
+
+// PayerTotal is how much one payer still owes
+type PayerTotal struct {
+	Payer    string       `json:"payer"`
+	Owed     money.Amount `json:"owed"`
+	Expenses int          `json:"expenses"`
+}
+
+// Adds the conditions that leave transfers and settled reimbursements out of totals. Payments that
+// settled reimbursements are still matched, totalsStages counts only what they paid beyond them.
+func countedInTotals(match bson.M) bson.M {
+	match["transfer_id"] = notTransfer
+	match["reimbursement.status"] = bson.M{"$ne": Reimbursed}
+	return match
+}
+
+// Returns the stages totals start from: the counted transactions, with reimbursed expenses and
+// the part of their payment that covered them cancelling out, then one document per split
+func totalsStages(match bson.M) mongo.Pipeline {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: countedInTotals(match)}},
+		{{Key: "$set", Value: bson.M{
+			"amount": bson.M{"$subtract": bson.A{"$amount", bson.M{"$ifNull": bson.A{"$reimbursed_amount", 0}}}},
+		}}},
+		{{Key: "$match", Value: bson.M{"amount": bson.M{"$ne": 0}}}},
+	}
+	return append(pipeline, splitStages()...)
+}
+
+// Returns the owed expenses a payment settles: all of the payer's expenses up to the payment when it
+// covers their total, otherwise the earliest single expense of the same amount. The payment has to
+// name the payer and be in the expenses' currency.
+func MatchReimbursement(payment *Transaction, owed []*Transaction) []*Transaction {
+	if payment.Amount <= 0 || len(owed) == 0 || owed[0].Reimbursement == nil {
+		return nil
+	}
+	payer := strings.ToLower(owed[0].Reimbursement.Payer)
+	if !strings.Contains(strings.ToLower(payment.Name), payer) && !strings.Contains(strings.ToLower(payment.Merchant), payer) {
+		return nil
+	}
+
+	due := []*Transaction{}
+	var total money.Amount
+	for _, expense := range owed {
+		if !expense.Date.After(payment.Date) && expense.Currency == payment.Currency {
+			due = append(due, expense)
+			total += expense.Amount.Abs()
+		}
+	}
+	if len(due) == 0 {
+		return nil
+	}
+	if total == payment.Amount {
+		return due
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].Date.Before(due[j].Date) })
+	for _, expense := range due {
+		if expense.Amount.Abs() == payment.Amount {
+			return []*Transaction{expense}
+		}
+	}
+	return nil
+}
+
+// Marks expenses as reimbursed by a payment that covers them, any of the payment beyond the expenses
+// stays counted
+func settleReimbursement(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID, payment *Transaction, expenses []*Transaction) error {
+	expenseIDs := []string{}
+	var total money.Amount
+	for _, expense := range expenses {
+		expenseIDs = append(expenseIDs, expense.TransactionID)
+		total += expense.Amount.Abs()
+	}
+
+	now := time.Now()
+	_, err := db.Transactions.UpdateMany(
+		ctx,
+		bson.M{"user_id": *userID, "transaction_id": bson.M{"$in": expenseIDs}, "reimbursement.status": ReimbursementOwed},
+		bson.M{"$set": bson.M{
+			"reimbursement.status":        Reimbursed,
+			"reimbursement.payment_id":    payment.TransactionID,
+			"reimbursement.reimbursed_at": now,
+			"updated_at":                  now,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = db.Transactions.UpdateOne(
+		ctx,
+		bson.M{"user_id": *userID, "transaction_id": payment.TransactionID},
+		bson.M{"$set": bson.M{"reimburses": expenseIDs, "reimbursed_amount": total, "updated_at": now}},
+	)
+	return err
+}
+
+// Makes the expenses a payment settled owed again
+func releaseReimbursed(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID, paymentID string) error {
+	_, err := db.Transactions.UpdateMany(
+		ctx,
+		bson.M{"user_id": *userID, "reimbursement.payment_id": paymentID},
+		bson.M{
+			"$set":   bson.M{"reimbursement.status": ReimbursementOwed, "updated_at": time.Now()},
+			"$unset": bson.M{"reimbursement.payment_id": "", "reimbursement.reimbursed_at": ""},
+		},
+	)
+	return err
+}
+
+// Returns the user's owed expenses grouped by payer
+func owedByPayer(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID) (map[string][]*Transaction, error) {
+	var owed []*Transaction
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := db.Transactions.Find(ctx, bson.M{"user_id": *userID, "reimbursement.status": ReimbursementOwed}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &owed); err != nil {
+		return nil, err
+	}
+	byPayer := map[string][]*Transaction{}
+	for _, expense := range owed {
+		key := strings.ToLower(expense.Reimbursement.Payer)
+		byPayer[key] = append(byPayer[key], expense)
+	}
+	return byPayer, nil
+}
+
+// Returns the merchant keys and currencies of the user's recurring deposits, like paychecks
+func recurringDeposits(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID) (map[string]bool, error) {
+	var series []*RecurringSeries
+	cursor, err := db.Recurring.Find(ctx, bson.M{"user_id": *userID, "average_amount": bson.M{"$gt": 0}})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &series); err != nil {
+		return nil, err
+	}
+	deposits := map[string]bool{}
+	for _, s := range series {
+		deposits[merchant.Key(s.Merchant)+"|"+s.Currency] = true
+	}
+	return deposits, nil
+}
+
+// Settles owed expenses with incoming payments from their payer, returning how many payments were matched.
+// Recurring deposits such as paychecks and payments unlinked by the user are never taken as reimbursements.
+// Payments from since on are looked at, a zero since looks at all of them.
+func ReconcileReimbursements(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID, since time.Time) (int, error) {
+	byPayer, err := owedByPayer(ctx, db, userID)
+	if err != nil || len(byPayer) == 0 {
+		return 0, err
+	}
+	deposits, err := recurringDeposits(ctx, db, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	filter := bson.M{
+		"user_id":                *userID,
+		"amount":                 bson.M{"$gt": 0},
+		"reimburses":             bson.M{"$exists": false},
+		"reimbursement_unlinked": bson.M{"$ne": true},
+		"refund_of":              bson.M{"$exists": false},
+		"transfer_id":            notTransfer,
+		"splits":                 bson.M{"$exists": false},
+	}
+	if !since.IsZero() {
+		filter["date"] = bson.M{"$gte": since}
+	}
+	var payments []*Transaction
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := db.Transactions.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, err
+	}
+	if err = cursor.All(ctx, &payments); err != nil {
+		return 0, err
+	}
+
+	reconciled := 0
+	for _, payment := range payments {
+		name := payment.Merchant
+		if name == "" {
+			name = merchant.Normalize(payment.Name)
+		}
+		if deposits[merchant.Key(name)+"|"+payment.Currency] {
+			continue
+		}
+		for payer, owed := range byPayer {
+			expenses := MatchReimbursement(payment, owed)
+			if len(expenses) == 0 {
+				continue
+			}
+			settled := map[string]bool{}
+			for _, expense := range expenses {
+				settled[expense.TransactionID] = true
+			}
+			if err = settleReimbursement(ctx, db, userID, payment, expenses); err != nil {
+				return reconciled, err
+			}
+			remaining := []*Transaction{}
+			for _, expense := range owed {
+				if !settled[expense.TransactionID] {
+					remaining = append(remaining, expense)
+				}
+			}
+			byPayer[payer] = remaining
+			reconciled++
+			break
+		}
+	}
+	return reconciled, nil
+}
+
+// Marks an expense as owed back by a payer, an empty payer unmarks it
+func (h *Handler) UpdateReimbursable(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	transactionID := c.Param("transaction_id")
+	if util.ContainsEmpty(transactionID) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	type Input struct {
+		Payer string `json:"payer"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil || input == nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var transaction *Transaction
+	if err = h.Db.Transactions.
+		FindOne(ctx, bson.M{"user_id": *userID, "transaction_id": transactionID}).
+		Decode(&transaction); err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	// settled reimbursements are undone by unlinking their payment first, see UnlinkReimbursement
+	if transaction.Amount >= 0 || (transaction.Reimbursement != nil && transaction.Reimbursement.Status == Reimbursed) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	update := bson.M{"$unset": bson.M{"reimbursement": ""}, "$set": bson.M{"updated_at": time.Now()}}
+	if payer := util.RemoveDuplicateWhitespace(strings.TrimSpace(input.Payer)); payer != "" {
+		update = bson.M{"$set": bson.M{
+			"reimbursement": &Reimbursement{Payer: payer, Status: ReimbursementOwed, MarkedAt: time.Now()},
+			"updated_at":    time.Now(),
+		}}
+	}
+	_, err = h.Db.Transactions.UpdateOne(ctx, bson.M{"user_id": *userID, "transaction_id": transactionID}, update)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+}
+
+// Returns reimbursable expenses, owed ones unless status=reimbursed, along with what each payer owes
+func (h *Handler) GetReimbursements(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	status := c.DefaultQuery("status", ReimbursementOwed)
+	if status != ReimbursementOwed && status != Reimbursed {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var transactions []*Transaction
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := h.Db.Transactions.Find(ctx, bson.M{"user_id": *userID, "reimbursement.status": status}, opts)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = cursor.All(ctx, &transactions); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	byPayer, err := owedByPayer(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	payers := []*PayerTotal{}
+	for _, owed := range byPayer {
+		total := &PayerTotal{Payer: owed[0].Reimbursement.Payer, Expenses: len(owed)}
+		for _, expense := range owed {
+			total.Owed += expense.Amount.Abs()
+		}
+		payers = append(payers, total)
+	}
+	sort.Slice(payers, func(i, j int) bool { return strings.ToLower(payers[i].Payer) < strings.ToLower(payers[j].Payer) })
+
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": transactions,
+		"payers":       payers,
+	})
+}
+
+// Settles owed expenses with a payment by hand. The payment has to cover the expenses in their
+// currency, what it paid beyond them is returned as the difference.
+func (h *Handler) ReconcileReimbursement(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	type Input struct {
+		PaymentID      string   `json:"payment_id" validate:"required"`
+		TransactionIDs []string `json:"transaction_ids" validate:"required,min=1"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = v.Struct(input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var payment *Transaction
+	if err = h.Db.Transactions.
+		FindOne(ctx, bson.M{"user_id": *userID, "transaction_id": input.PaymentID}).
+		Decode(&payment); err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if payment.Amount <= 0 || len(payment.Reimburses) > 0 || len(payment.Splits) > 0 ||
+		payment.TransferID != nil || payment.RefundOf != "" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var expenses []*Transaction
+	cursor, err := h.Db.Transactions.Find(ctx, bson.M{
+		"user_id":              *userID,
+		"transaction_id":       bson.M{"$in": input.TransactionIDs},
+		"reimbursement.status": ReimbursementOwed,
+	})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = cursor.All(ctx, &expenses); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if len(expenses) != len(input.TransactionIDs) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var total money.Amount
+	for _, expense := range expenses {
+		if expense.Currency != payment.Currency {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		total += expense.Amount.Abs()
+	}
+	if total > payment.Amount {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if err = settleReimbursement(ctx, h.Db, userID, payment, expenses); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reconciled": len(expenses),
+		"difference": payment.Amount - total,
+	})
+}
+
+// Unlinks a payment from the expenses it settled. The expenses are owed again and the payment
+// counts in full, it isn't matched to reimbursements automatically again.
+func (h *Handler) UnlinkReimbursement(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	paymentID := c.Param("payment_id")
+	if util.ContainsEmpty(paymentID) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var payment *Transaction
+	if err = h.Db.Transactions.
+		FindOne(ctx, bson.M{"user_id": *userID, "transaction_id": paymentID, "reimburses": bson.M{"$exists": true}}).
+		Decode(&payment); err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if err = releaseReimbursed(ctx, h.Db, userID, payment.TransactionID); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	_, err = h.Db.Transactions.UpdateOne(
+		ctx,
+		bson.M{"user_id": *userID, "transaction_id": payment.TransactionID},
+		bson.M{
+			"$set":   bson.M{"reimbursement_unlinked": true, "updated_at": now},
+			"$unset": bson.M{"reimburses": "", "reimbursed_amount": ""},
+		},
+	)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+}
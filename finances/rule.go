@@ -0,0 +1,812 @@
+package finances
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/rules"
+	"github.com/tony-tvu/goexpense/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Rule struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	UserID     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	rules.Rule `bson:",inline"`
+
+	// rules run from the lowest priority number up
+	Priority int `json:"priority" bson:"priority"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// Returns the user's rules in the order they are applied
+func GetUserRules(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID) ([]*Rule, error) {
+	var ruleset []*Rule
+	opts := options.Find().SetSort(bson.D{{Key: "priority", Value: 1}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := db.Rules.Find(ctx, bson.M{"user_id": *userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &ruleset); err != nil {
+		return nil, err
+	}
+	return ruleset, nil
+}
+
+// Returns the rule engine subject for a transaction
+func (t *Transaction) RuleSubject(institution string) *rules.Subject {
+	return &rules.Subject{
+		Name:         t.Name,
+		Amount:       t.Amount,
+		AccountID:    t.AccountID,
+		EnrollmentID: t.EnrollmentID,
+		Institution:  institution,
+		Date:         t.Date,
+	}
+}
+
+// RuleOutcome is the engine outcome along with the rule that decided the category
+type RuleOutcome struct {
+	*rules.Outcome
+	RuleID *primitive.ObjectID
+}
+
+// Runs the user's rules against a transaction. Categories the user no longer has are dropped
+// and ignore actions resolve to one of the user's 'ignore' kind categories.
+func EvaluateRules(categories CategorySet, ruleset []*Rule, subject *rules.Subject) *RuleOutcome {
+	engineRules := []*rules.Rule{}
+	for _, rule := range ruleset {
+		engineRules = append(engineRules, &rule.Rule)
+	}
+	outcome := &RuleOutcome{Outcome: rules.Apply(engineRules, subject)}
+
+	if outcome.Ignore {
+		// prefer the default 'ignore' category over others of its kind
+		ignored := categories.NamesOfKind(Ignore)
+		sort.Strings(ignored)
+		if categories.Kind("ignore") == Ignore {
+			outcome.Category = "ignore"
+		} else if len(ignored) > 0 {
+			outcome.Category = ignored[0]
+		}
+	}
+	if !categories.Contains(outcome.Category) {
+		outcome.Category = ""
+	}
+	if outcome.Category != "" && outcome.CategorizedBy >= 0 {
+		outcome.RuleID = &ruleset[outcome.CategorizedBy].ID
+	}
+	outcome.Tags = NormalizeTags(outcome.Tags)
+	return outcome
+}
+
+// Returns the update that applies a rule outcome to a stored transaction, nil if nothing changes
+func outcomeUpdate(categories CategorySet, t *Transaction, outcome *RuleOutcome) bson.M {
+	set := bson.M{}
+	// split transactions keep the categories of their splits, refunds keep the category of their
+	// purchase and locked fields are never changed
+	if outcome.Category != "" && len(t.Splits) == 0 && t.RefundOf == "" && !t.IsLocked(LockCategory) {
+		set["category"] = outcome.Category
+		set["amount"] = NormalizeAmount(t.Amount, categories.Kind(outcome.Category))
+		set["rule_id"] = outcome.RuleID
+	}
+	if outcome.Name != "" && !t.IsLocked(LockName) {
+		set["name"] = outcome.Name
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		set["updated_at"] = time.Now()
+		update["$set"] = set
+	}
+	if _, ok := set["category"]; ok {
+		update["$unset"] = bson.M{"confidence": ""}
+	}
+	if len(outcome.Tags) > 0 && !t.IsLocked(LockTags) {
+		update["$addToSet"] = bson.M{"tags": bson.M{"$each": outcome.Tags}}
+	}
+	if len(update) == 0 {
+		return nil
+	}
+	return update
+}
+
+// Returns the institution of each of the user's accounts
+func (h *Handler) accountInstitutions(ctx context.Context, userID *primitive.ObjectID) (map[string]string, error) {
+	var accounts []*Account
+	cursor, err := h.Db.Accounts.Find(ctx, bson.M{"user_id": *userID})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &accounts); err != nil {
+		return nil, err
+	}
+	institutions := map[string]string{}
+	for _, account := range accounts {
+		institutions[account.AccountID] = account.Institution
+	}
+	return institutions, nil
+}
+
+// Scopes a new rule can be applied with
+const (
+	ScopeFuture   = "future"
+	ScopeFromDate = "from_date"
+	ScopeAll      = "all"
+)
+
+// RuleChange describes how rules would change a stored transaction
+type RuleChange struct {
+	TransactionID string       `json:"transaction_id"`
+	Date          time.Time    `json:"date"`
+	Name          string       `json:"name"`
+	NewName       string       `json:"new_name,omitempty"`
+	FromCategory  string       `json:"from_category"`
+	ToCategory    string       `json:"to_category"`
+	FromAmount    money.Amount `json:"from_amount"`
+	ToAmount      money.Amount `json:"to_amount"`
+	AddedTags     []string     `json:"added_tags"`
+
+	update bson.M
+}
+
+// Returns the changes rules would make to the user's transactions matching the filter
+func (h *Handler) ruleChanges(ctx context.Context, userID *primitive.ObjectID, categories CategorySet, ruleset []*Rule, filter bson.M) ([]*RuleChange, error) {
+	institutions, err := h.accountInstitutions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter["user_id"] = *userID
+	var transactions []*Transaction
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := h.Db.Transactions.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &transactions); err != nil {
+		return nil, err
+	}
+
+	changes := []*RuleChange{}
+	for _, transaction := range transactions {
+		outcome := EvaluateRules(categories, ruleset, transaction.RuleSubject(institutions[transaction.AccountID]))
+		update := outcomeUpdate(categories, transaction, outcome)
+		if update == nil {
+			continue
+		}
+
+		change := &RuleChange{
+			TransactionID: transaction.TransactionID,
+			Date:          transaction.Date,
+			Name:          transaction.Name,
+			FromCategory:  transaction.Category,
+			ToCategory:    transaction.Category,
+			FromAmount:    transaction.Amount,
+			ToAmount:      transaction.Amount,
+			AddedTags:     []string{},
+			update:        update,
+		}
+		if set, ok := update["$set"].(bson.M); ok {
+			if category, ok := set["category"].(string); ok {
+				change.ToCategory = category
+				change.ToAmount = set["amount"].(money.Amount)
+			}
+			if name, ok := set["name"].(string); ok && name != transaction.Name {
+				change.NewName = name
+			}
+		}
+		for _, tag := range outcome.Tags {
+			if !util.Contains(&transaction.Tags, tag) {
+				change.AddedTags = append(change.AddedTags, tag)
+			}
+		}
+
+		// skip outcomes the transaction already has
+		if change.ToCategory == change.FromCategory && change.ToAmount == change.FromAmount &&
+			change.NewName == "" && len(change.AddedTags) == 0 {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func (h *Handler) applyRuleChanges(ctx context.Context, userID *primitive.ObjectID, changes []*RuleChange) bool {
+	success := true
+	for _, change := range changes {
+		filter := bson.M{"transaction_id": change.TransactionID, "user_id": *userID}
+		_, err := h.Db.Transactions.UpdateOne(ctx, filter, change.update)
+		if err != nil {
+			log.Printf("error applying rules: %v", err)
+			success = false
+		}
+	}
+	return success
+}
+
+// Returns the transaction filter for a rule scope, nil if no existing transactions are in scope
+func scopeFilter(scope string, from time.Time) bson.M {
+	switch scope {
+	case ScopeAll:
+		return bson.M{}
+	case ScopeFromDate:
+		return bson.M{"date": bson.M{"$gte": from}}
+	}
+	return nil
+}
+
+// Parses and validates a rule from the request body. Substring, category and tags are
+// accepted as a shorthand for a single name condition with set category and add tag actions.
+type ruleInput struct {
+	rule   *rules.Rule
+	scope  string
+	from   time.Time
+	revert string
+}
+
+func parseRuleInput(c *gin.Context, categories CategorySet) (*ruleInput, bool) {
+	type Input struct {
+		rules.Rule
+
+		Substring string   `json:"substring"`
+		Category  string   `json:"category"`
+		Tags      []string `json:"tags"`
+
+		// which existing transactions the rule is applied to, from is a YYYY-MM-DD date
+		Scope string `json:"scope"`
+		From  string `json:"from"`
+
+		// what happens to transactions an edited rule categorized before
+		Revert string `json:"revert"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, false
+	}
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil || input == nil {
+		return nil, false
+	}
+	err = v.Struct(input)
+	if err != nil {
+		return nil, false
+	}
+
+	rule := input.Rule
+	if len(rule.Conditions) == 0 && !util.ContainsEmpty(strings.TrimSpace(input.Substring)) {
+		rule.Conditions = []*rules.Condition{{Field: rules.FieldName, Op: rules.OpContains, Value: strings.TrimSpace(input.Substring)}}
+		if input.Category != "" {
+			rule.Actions = append(rule.Actions, &rules.Action{Type: rules.SetCategory, Value: input.Category})
+		}
+		for _, tag := range NormalizeTags(input.Tags) {
+			rule.Actions = append(rule.Actions, &rules.Action{Type: rules.AddTag, Value: tag})
+		}
+	}
+
+	if err = rule.Validate(); err != nil {
+		return nil, false
+	}
+	for _, action := range rule.Actions {
+		if action.Type == rules.SetCategory && !categories.Contains(action.Value) {
+			return nil, false
+		}
+		if action.Type == rules.AddTag {
+			tags := NormalizeTags([]string{action.Value})
+			if len(tags) == 0 {
+				return nil, false
+			}
+			action.Value = tags[0]
+		}
+	}
+
+	parsed := &ruleInput{rule: &rule, scope: input.Scope, revert: input.Revert}
+	switch input.Scope {
+	case "", ScopeFuture, ScopeAll:
+	case ScopeFromDate:
+		from, err := time.Parse("2006-01-02", input.From)
+		if err != nil {
+			return nil, false
+		}
+		parsed.from = from
+	default:
+		return nil, false
+	}
+	return parsed, true
+}
+
+func (h *Handler) CreateRule(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	input, ok := parseRuleInput(c, categories)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	// new rules apply to all history unless a scope is given
+	if input.scope == "" {
+		input.scope = ScopeAll
+	}
+
+	rule, applied, err := h.insertRule(ctx, userID, categories, input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rule":    rule,
+		"applied": applied,
+	})
+}
+
+// Saves a new rule last in priority and applies it to the transactions in its scope,
+// returning the rule and how many transactions changed
+func (h *Handler) insertRule(ctx context.Context, userID *primitive.ObjectID, categories CategorySet, input *ruleInput) (*Rule, int, error) {
+	// new rules go last
+	var last *Rule
+	opts := options.FindOne().SetSort(bson.D{{Key: "priority", Value: -1}})
+	if err := h.Db.Rules.FindOne(ctx, bson.M{"user_id": *userID}, opts).Decode(&last); err != nil && err != mongo.ErrNoDocuments {
+		return nil, 0, err
+	}
+	priority := 1
+	if last != nil {
+		priority = last.Priority + 1
+	}
+
+	rule := &Rule{
+		ID:        primitive.NewObjectID(),
+		UserID:    *userID,
+		Rule:      *input.rule,
+		Priority:  priority,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if _, err := h.Db.Rules.InsertOne(ctx, rule); err != nil {
+		return nil, 0, err
+	}
+
+	// existing transactions are only changed when asked for
+	applied := 0
+	if filter := scopeFilter(input.scope, input.from); filter != nil {
+		changes, err := h.ruleChanges(ctx, userID, categories, []*Rule{rule}, filter)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !h.applyRuleChanges(ctx, userID, changes) {
+			return nil, 0, errors.New("error applying rule")
+		}
+		applied = len(changes)
+	}
+	return rule, applied, nil
+}
+
+// Returns the changes a rule would make to existing transactions without saving anything.
+// Previews cover all history unless a from_date scope is given.
+func (h *Handler) PreviewRule(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	input, ok := parseRuleInput(c, categories)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	filter := bson.M{}
+	if input.scope == ScopeFromDate {
+		filter = scopeFilter(input.scope, input.from)
+	}
+	rule := &Rule{Rule: *input.rule}
+	changes, err := h.ruleChanges(ctx, userID, categories, []*Rule{rule}, filter)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"changes": changes,
+		"count":   len(changes),
+	})
+}
+
+// What happens to transactions a rule categorized when it is edited or deleted
+const (
+	RevertNone     = ""
+	RevertOriginal = "original"
+	RevertRerun    = "rerun"
+)
+
+// Restores the original category and name of every transaction the rule categorized.
+// With rerun the remaining rules are then applied to them again.
+func (h *Handler) revertRule(ctx context.Context, userID *primitive.ObjectID, categories CategorySet, ruleID primitive.ObjectID, mode string) (int, error) {
+	if mode == RevertNone {
+		return 0, nil
+	}
+
+	var remaining []*Rule
+	if mode == RevertRerun {
+		ruleset, err := GetUserRules(ctx, h.Db, userID)
+		if err != nil {
+			return 0, err
+		}
+		for _, rule := range ruleset {
+			if rule.ID != ruleID {
+				remaining = append(remaining, rule)
+			}
+		}
+	}
+	institutions, err := h.accountInstitutions(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var transactions []*Transaction
+	cursor, err := h.Db.Transactions.Find(ctx, bson.M{"user_id": *userID, "rule_id": ruleID})
+	if err != nil {
+		return 0, err
+	}
+	if err = cursor.All(ctx, &transactions); err != nil {
+		return 0, err
+	}
+
+	for _, transaction := range transactions {
+		category := transaction.OriginalCategory
+		if !categories.Contains(category) {
+			category = Uncategorized
+		}
+		name := transaction.Name
+		if transaction.OriginalName != "" && !transaction.IsLocked(LockName) {
+			name = transaction.OriginalName
+		}
+		transaction.Name = name
+
+		set := bson.M{
+			"category":   category,
+			"amount":     NormalizeAmount(transaction.Amount, categories.Kind(category)),
+			"name":       name,
+			"updated_at": time.Now(),
+		}
+		update := bson.M{"$set": set, "$unset": bson.M{"rule_id": ""}}
+
+		if mode == RevertRerun {
+			outcome := EvaluateRules(categories, remaining, transaction.RuleSubject(institutions[transaction.AccountID]))
+			if rerun := outcomeUpdate(categories, transaction, outcome); rerun != nil {
+				if rerunSet, ok := rerun["$set"].(bson.M); ok {
+					for key, value := range rerunSet {
+						set[key] = value
+					}
+					if outcome.RuleID != nil {
+						delete(update, "$unset")
+					}
+				}
+				if addToSet, ok := rerun["$addToSet"]; ok {
+					update["$addToSet"] = addToSet
+				}
+			}
+		}
+
+		filter := bson.M{"transaction_id": transaction.TransactionID, "user_id": *userID}
+		if _, err = h.Db.Transactions.UpdateOne(ctx, filter, update); err != nil {
+			return 0, err
+		}
+	}
+	return len(transactions), nil
+}
+
+// Replaces a rule's conditions and actions. The revert option restores or re-runs transactions
+// the old version categorized, and the scope option applies the new version to history.
+func (h *Handler) UpdateRule(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	ruleObjID, err := primitive.ObjectIDFromHex(c.Param("rule_id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var rule *Rule
+	if err = h.Db.Rules.FindOne(ctx, bson.M{"_id": ruleObjID, "user_id": *userID}).Decode(&rule); err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	input, ok := parseRuleInput(c, categories)
+	if !ok || (input.revert != RevertNone && input.revert != RevertOriginal && input.revert != RevertRerun) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	rule.Rule = *input.rule
+	rule.UpdatedAt = time.Now()
+	_, err = h.Db.Rules.UpdateOne(ctx, bson.M{"_id": rule.ID}, bson.M{"$set": bson.M{
+		"match":           rule.Match,
+		"conditions":      rule.Conditions,
+		"actions":         rule.Actions,
+		"stop_processing": rule.StopProcessing,
+		"updated_at":      rule.UpdatedAt,
+	}})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	reverted, err := h.revertRule(ctx, userID, categories, rule.ID, input.revert)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	applied := 0
+	if filter := scopeFilter(input.scope, input.from); filter != nil {
+		changes, err := h.ruleChanges(ctx, userID, categories, []*Rule{rule}, filter)
+		if err != nil || !h.applyRuleChanges(ctx, userID, changes) {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		applied = len(changes)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rule":     rule,
+		"reverted": reverted,
+		"applied":  applied,
+	})
+}
+
+// Deletes a rule, ?revert=original or ?revert=rerun also undoes what it did to transactions
+func (h *Handler) DeleteRule(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	ruleIDHex := c.Param("rule_id")
+	if util.ContainsEmpty(ruleIDHex) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	ruleObjID, err := primitive.ObjectIDFromHex(ruleIDHex)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	revert := c.Query("revert")
+	if revert != RevertNone && revert != RevertOriginal && revert != RevertRerun {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.Db.Rules.DeleteOne(ctx, bson.M{"_id": ruleObjID, "user_id": *userID})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if result.DeletedCount == 0 || revert == RevertNone {
+		return
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	reverted, err := h.revertRule(ctx, userID, categories, ruleObjID, revert)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reverted": reverted,
+	})
+}
+
+func (h *Handler) GetRules(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	ruleset, err := GetUserRules(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rules": ruleset,
+	})
+}
+
+// Sets rule priorities to the order of the given ids, which must include every one of the user's rules.
+// Existing transactions keep their categories, the new order applies from the next sync or rule change.
+func (h *Handler) ReorderRules(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	type Input struct {
+		RuleIDs []string `json:"rule_ids" validate:"required"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = v.Struct(input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	ruleset, err := GetUserRules(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	existing := map[string]bool{}
+	for _, rule := range ruleset {
+		existing[rule.ID.Hex()] = true
+	}
+	seen := map[string]bool{}
+	for _, id := range input.RuleIDs {
+		if !existing[id] || seen[id] {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		seen[id] = true
+	}
+	if len(seen) != len(existing) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	for i, id := range input.RuleIDs {
+		ruleObjID, _ := primitive.ObjectIDFromHex(id)
+		_, err = h.Db.Rules.UpdateOne(
+			ctx,
+			bson.M{"_id": ruleObjID, "user_id": *userID},
+			bson.M{"$set": bson.M{"priority": i + 1, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// Returns the user's rules that match a transaction, ignoring stop processing
+func MatchingRules(ruleset []*Rule, subject *rules.Subject) []*Rule {
+	matching := []*Rule{}
+	for _, rule := range ruleset {
+		if rule.Matches(subject) {
+			matching = append(matching, rule)
+		}
+	}
+	return matching
+}
+
+// Lists transactions matched by more than one rule along with the rules that match them
+func (h *Handler) GetRuleConflicts(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	ruleset, err := GetUserRules(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	institutions, err := h.accountInstitutions(ctx, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	var transactions []*Transaction
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := h.Db.Transactions.Find(ctx, bson.M{"user_id": *userID}, opts)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = cursor.All(ctx, &transactions); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	type Conflict struct {
+		Transaction *Transaction `json:"transaction"`
+		Rules       []*Rule      `json:"rules"`
+	}
+	conflicts := []*Conflict{}
+	for _, transaction := range transactions {
+		matching := MatchingRules(ruleset, transaction.RuleSubject(institutions[transaction.AccountID]))
+		if len(matching) > 1 {
+			conflicts = append(conflicts, &Conflict{Transaction: transaction, Rules: matching})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"conflicts": conflicts,
+		"count":     len(conflicts),
+	})
+}
@@ -0,0 +1,147 @@
+package finances
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Split is the part of a transaction counted towards one category.
+// Transactions with splits are counted by their splits instead of their own category.
+type Split struct {
+	Category string       `json:"category" bson:"category" validate:"required"`
+	Amount   money.Amount `json:"amount" bson:"amount"`
+	Note     string       `json:"note" bson:"note"`
+}
+
+// Returns true if the splits add up to the transaction amount once each split is signed by
+// the kind of its own category
+func SplitsMatch(amount money.Amount, splits []*Split, categories CategorySet) bool {
+	var total money.Amount
+	for _, split := range splits {
+		if split.Amount == 0 {
+			return false
+		}
+		total += NormalizeAmount(split.Amount, categories.Kind(split.Category))
+	}
+	return total == amount
+}
+
+// Returns aggregation stages that replace each transaction with one document per split,
+// transactions without splits pass through unchanged. Category and amount filters must come after.
+func splitStages() mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$addFields", Value: bson.M{
+			"parts": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$splits", bson.A{}}}}, 0}},
+				"$splits",
+				bson.A{bson.M{"category": "$category", "amount": "$amount"}},
+			}},
+		}}},
+		{{Key: "$unwind", Value: "$parts"}},
+		{{Key: "$set", Value: bson.M{
+			"category": "$parts.category",
+			"amount":   "$parts.amount",
+		}}},
+	}
+}
+
+// Replaces the splits of a transaction, an empty list removes them
+func (h *Handler) UpdateSplits(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	transactionID := c.Param("transaction_id")
+	if util.ContainsEmpty(transactionID) {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	type Input struct {
+		Splits []*Split `json:"splits" validate:"dive"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil || input == nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = v.Struct(input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var transaction *Transaction
+	if err = h.Db.Transactions.
+		FindOne(ctx, bson.M{"user_id": *userID, "transaction_id": transactionID}).
+		Decode(&transaction); err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	filter := bson.M{"transaction_id": transactionID, "user_id": *userID}
+	if len(input.Splits) == 0 {
+		_, err = h.Db.Transactions.UpdateOne(ctx, filter, bson.M{
+			"$unset": bson.M{"splits": ""},
+			"$set":   bson.M{"updated_at": time.Now()},
+		})
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	for _, split := range input.Splits {
+		if !categories.Contains(split.Category) {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if len(input.Splits) < 2 || !SplitsMatch(transaction.Amount, input.Splits, categories) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "splits must add up to the transaction amount"})
+		return
+	}
+	for _, split := range input.Splits {
+		split.Amount = NormalizeAmount(split.Amount, categories.Kind(split.Category))
+	}
+
+	_, err = h.Db.Transactions.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"splits":     input.Splits,
+		"updated_at": time.Now(),
+	}})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"splits": input.Splits,
+	})
+}
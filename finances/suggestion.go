@@ -0,0 +1,315 @@
+package finances
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/rules"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Manual recategorizations of similar transactions needed before a rule is suggested
+const MinSuggestionCount = 3
+
+// Recategorization is a category the user set by hand
+type Recategorization struct {
+	UserID        primitive.ObjectID `json:"user_id" bson:"user_id"`
+	TransactionID string             `json:"transaction_id" bson:"transaction_id"`
+	Name          string             `json:"name" bson:"name"`
+	FromCategory  string             `json:"from_category" bson:"from_category"`
+	ToCategory    string             `json:"to_category" bson:"to_category"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// RuleSuggestion is a rule proposed from repeated recategorizations
+type RuleSuggestion struct {
+	Substring string   `json:"substring" bson:"substring"`
+	Category  string   `json:"category" bson:"category"`
+	Count     int      `json:"count" bson:"-"`
+	Examples  []string `json:"examples" bson:"-"`
+
+	// the recategorizations the suggestion came from
+	history []*Recategorization
+}
+
+// Returns the part of a transaction name that stays the same across purchases, the words
+// before the first store number or reference. Names with nothing meaningful return "".
+func SuggestionSubstring(name string) string {
+	words := []string{}
+	for _, word := range strings.Fields(name) {
+		if strings.HasPrefix(word, "#") || strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+			break
+		}
+		words = append(words, word)
+	}
+	substring := strings.TrimRight(strings.Join(words, " "), " *-#.,:")
+	if len(substring) < 3 {
+		return ""
+	}
+	return substring
+}
+
+// Records a category set by hand so repeated ones can be suggested as rules
+func (h *Handler) recordRecategorization(ctx context.Context, userID *primitive.ObjectID, transaction *Transaction, category string) error {
+	if transaction.Category == category {
+		return nil
+	}
+	_, err := h.Db.Recategorizations.InsertOne(ctx, &Recategorization{
+		UserID:        *userID,
+		TransactionID: transaction.TransactionID,
+		Name:          transaction.Name,
+		FromCategory:  transaction.Category,
+		ToCategory:    category,
+		CreatedAt:     time.Now(),
+	})
+	return err
+}
+
+// Returns rules the user keeps applying by hand. Only the latest recategorization of each
+// transaction counts, and suggestions already dismissed or covered by a rule are left out.
+func (h *Handler) GetRuleSuggestions(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	ruleset, err := GetUserRules(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	var history []*Recategorization
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := h.Db.Recategorizations.Find(ctx, bson.M{"user_id": *userID}, opts)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = cursor.All(ctx, &history); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	dismissed := map[string]bool{}
+	cursor, err = h.Db.DismissedSuggestions.Find(ctx, bson.M{"user_id": *userID})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	var dismissals []*RuleSuggestion
+	if err = cursor.All(ctx, &dismissals); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	for _, d := range dismissals {
+		dismissed[suggestionKey(d.Substring, d.Category)] = true
+	}
+
+	latest := map[string]*Recategorization{}
+	for _, r := range history {
+		latest[r.TransactionID] = r
+	}
+
+	grouped := map[string]*RuleSuggestion{}
+	for _, r := range latest {
+		substring := SuggestionSubstring(r.Name)
+		if substring == "" || !categories.Contains(r.ToCategory) {
+			continue
+		}
+		key := suggestionKey(substring, r.ToCategory)
+		suggestion, ok := grouped[key]
+		if !ok {
+			suggestion = &RuleSuggestion{Substring: substring, Category: r.ToCategory, Examples: []string{}}
+			grouped[key] = suggestion
+		}
+		suggestion.Count++
+		suggestion.history = append(suggestion.history, r)
+		if len(suggestion.Examples) < 3 && !containsFold(suggestion.Examples, r.Name) {
+			suggestion.Examples = append(suggestion.Examples, r.Name)
+		}
+	}
+
+	// the recategorized transactions are what existing rules are checked against
+	transactionIDs := []string{}
+	for key, suggestion := range grouped {
+		if suggestion.Count >= MinSuggestionCount && !dismissed[key] {
+			for _, r := range suggestion.history {
+				transactionIDs = append(transactionIDs, r.TransactionID)
+			}
+		}
+	}
+	transactions := map[string]*Transaction{}
+	if len(transactionIDs) > 0 {
+		cursor, err = h.Db.Transactions.Find(ctx, bson.M{"user_id": *userID, "transaction_id": bson.M{"$in": transactionIDs}})
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		var found []*Transaction
+		if err = cursor.All(ctx, &found); err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		for _, t := range found {
+			transactions[t.TransactionID] = t
+		}
+	}
+	institutions, err := h.accountInstitutions(ctx, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	suggestions := []*RuleSuggestion{}
+	for key, suggestion := range grouped {
+		if suggestion.Count < MinSuggestionCount || dismissed[key] {
+			continue
+		}
+		// existing rules already do this for every transaction it came from
+		covered := true
+		for _, r := range suggestion.history {
+			subject := &rules.Subject{Name: r.Name}
+			if t, ok := transactions[r.TransactionID]; ok {
+				subject = t.RuleSubject(institutions[t.AccountID])
+			}
+			if EvaluateRules(categories, ruleset, subject).Category != suggestion.Category {
+				covered = false
+				break
+			}
+		}
+		if covered {
+			continue
+		}
+		sort.Strings(suggestion.Examples)
+		suggestions = append(suggestions, suggestion)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Count != suggestions[j].Count {
+			return suggestions[i].Count > suggestions[j].Count
+		}
+		return strings.ToLower(suggestions[i].Substring) < strings.ToLower(suggestions[j].Substring)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"suggestions": suggestions,
+	})
+}
+
+// Creates the rule for a suggestion. Unlike new rules, accepted suggestions are applied to
+// all history unless another scope is given since that history is what they came from.
+func (h *Handler) AcceptRuleSuggestion(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	input, ok := parseRuleInput(c, categories)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if input.scope == "" {
+		input.scope = ScopeAll
+	}
+
+	rule, applied, err := h.insertRule(ctx, userID, categories, input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rule":    rule,
+		"applied": applied,
+	})
+}
+
+// Hides a suggestion so it isn't proposed again
+func (h *Handler) DismissRuleSuggestion(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	type Input struct {
+		Substring string `json:"substring" validate:"required"`
+		Category  string `json:"category" validate:"required"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = v.Struct(input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	_, err = h.Db.DismissedSuggestions.UpdateOne(
+		ctx,
+		bson.M{
+			"user_id":   *userID,
+			"substring": strings.ToLower(strings.TrimSpace(input.Substring)),
+			"category":  input.Category,
+		},
+		bson.M{"$setOnInsert": bson.M{"created_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+}
+
+func suggestionKey(substring, category string) string {
+	return strings.ToLower(strings.TrimSpace(substring)) + "\x00" + category
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,369 @@
+package finances
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type CategoryTotal struct {
+	Category string       `json:"category"`
+	Path     string       `json:"path"`
+	Kind     string       `json:"kind"`
+	Amount   money.Amount `json:"amount"`
+	Total    money.Amount `json:"total"`
+}
+
+type MonthTotal struct {
+	Year    int          `json:"year"`
+	Month   int          `json:"month"`
+	Income  money.Amount `json:"income"`
+	Expense money.Amount `json:"expense"`
+	Net     money.Amount `json:"net"`
+}
+
+type AccountTotal struct {
+	AccountID   string       `json:"account_id"`
+	Name        string       `json:"name"`
+	Institution string       `json:"institution"`
+	Income      money.Amount `json:"income"`
+	Expense     money.Amount `json:"expense"`
+	Net         money.Amount `json:"net"`
+}
+
+type MerchantTotal struct {
+	Merchant    string       `json:"merchant"`
+	DisplayName string       `json:"display_name"`
+	LogoURL     string       `json:"logo_url,omitempty"`
+	Income      money.Amount `json:"income"`
+	Expense     money.Amount `json:"expense"`
+	Net         money.Amount `json:"net"`
+}
+
+type TagTotal struct {
+	Tag     string       `json:"tag"`
+	Income  money.Amount `json:"income"`
+	Expense money.Amount `json:"expense"`
+	Net     money.Amount `json:"net"`
+}
+
+// Parses optional from and to query dates (YYYY-MM-DD) into a date filter, to is inclusive
+func parseDateRange(c *gin.Context) (bson.M, bool) {
+	filter := bson.M{}
+	if from := c.Query("from"); from != "" {
+		fromDate, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return nil, false
+		}
+		filter["$gte"] = fromDate
+	}
+	if to := c.Query("to"); to != "" {
+		toDate, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return nil, false
+		}
+		filter["$lt"] = toDate.AddDate(0, 0, 1)
+	}
+	return filter, true
+}
+
+// Returns totals grouped by category, month, account, merchant and tag over a date range in the user's base currency.
+// Transfers, settled reimbursements and transactions in 'ignore' categories are left out and expenses are reported as positive amounts.
+func (h *Handler) GetSummary(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	dateFilter, ok := parseDateRange(c)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	match := bson.M{"user_id": *userID}
+	if len(dateFilter) > 0 {
+		match["date"] = dateFilter
+	}
+
+	since, _ := dateFilter["$gte"].(time.Time)
+	until, ok := dateFilter["$lt"].(time.Time)
+	if !ok {
+		until = time.Now()
+	}
+	converter, err := h.newConverter(ctx, userID, since, until)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	// amounts are summed per currency, and per day for other currencies so they can be converted at the
+	// rate of that day. Tags and merchants are grouped in their own branches so rows stay per category.
+	day := bson.M{"$cond": bson.A{
+		bson.M{"$in": bson.A{bson.M{"$ifNull": bson.A{"$currency", ""}}, bson.A{converter.Base, ""}}},
+		nil,
+		"$date",
+	}}
+	isIncome := bson.M{"$in": bson.A{"$category", categories.NamesOfKind(Income)}}
+	pipeline := totalsStages(match)
+	pipeline = append(pipeline, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"category": bson.M{"$nin": categories.NamesOfKind(Ignore)}}}},
+		{{Key: "$facet", Value: bson.M{
+			"totals": bson.A{
+				bson.M{"$group": bson.M{
+					"_id": bson.M{
+						"category":   "$category",
+						"account_id": "$account_id",
+						"currency":   "$currency",
+						"year":       bson.M{"$year": "$date"},
+						"month":      bson.M{"$month": "$date"},
+						"day":        day,
+					},
+					"amount": bson.M{"$sum": "$amount"},
+				}},
+			},
+			"by_tag": bson.A{
+				bson.M{"$unwind": "$tags"},
+				bson.M{"$group": bson.M{
+					"_id": bson.M{
+						"tag":       "$tags",
+						"is_income": isIncome,
+						"currency":  "$currency",
+						"day":       day,
+					},
+					"amount": bson.M{"$sum": "$amount"},
+				}},
+			},
+			"by_merchant": bson.A{
+				bson.M{"$match": bson.M{"merchant": bson.M{"$nin": bson.A{nil, ""}}}},
+				bson.M{"$group": bson.M{
+					"_id": bson.M{
+						"merchant":  "$merchant",
+						"is_income": isIncome,
+						"currency":  "$currency",
+						"day":       day,
+					},
+					"amount": bson.M{"$sum": "$amount"},
+				}},
+			},
+		}}},
+	}...)
+
+	cursor, err := h.Db.Transactions.Aggregate(ctx, pipeline)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	var results []struct {
+		Totals []struct {
+			ID struct {
+				Category  string    `bson:"category"`
+				AccountID string    `bson:"account_id"`
+				Currency  string    `bson:"currency"`
+				Year      int       `bson:"year"`
+				Month     int       `bson:"month"`
+				Day       time.Time `bson:"day"`
+			} `bson:"_id"`
+			Amount money.Amount `bson:"amount"`
+		} `bson:"totals"`
+		ByTag []struct {
+			ID struct {
+				Tag      string    `bson:"tag"`
+				IsIncome bool      `bson:"is_income"`
+				Currency string    `bson:"currency"`
+				Day      time.Time `bson:"day"`
+			} `bson:"_id"`
+			Amount money.Amount `bson:"amount"`
+		} `bson:"by_tag"`
+		ByMerchant []struct {
+			ID struct {
+				Merchant string    `bson:"merchant"`
+				IsIncome bool      `bson:"is_income"`
+				Currency string    `bson:"currency"`
+				Day      time.Time `bson:"day"`
+			} `bson:"_id"`
+			Amount money.Amount `bson:"amount"`
+		} `bson:"by_merchant"`
+	}
+	if err = cursor.All(ctx, &results); err != nil || len(results) != 1 {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	result := results[0]
+
+	type sums struct {
+		Income  money.Amount
+		Expense money.Amount
+	}
+	add := func(s *sums, amount money.Amount, isIncome bool) {
+		if isIncome {
+			s.Income += amount
+		} else {
+			s.Expense += amount
+		}
+	}
+
+	own := map[string]money.Amount{}
+	months := map[int]*sums{}
+	accountSums := map[string]*sums{}
+	var totals sums
+	for _, total := range result.Totals {
+		amount := converter.Convert(total.Amount, total.ID.Currency, total.ID.Day)
+		isIncome := categories.Kind(total.ID.Category) == Income
+
+		own[total.ID.Category] += amount
+
+		index := monthIndex(total.ID.Month, total.ID.Year)
+		if months[index] == nil {
+			months[index] = &sums{}
+		}
+		add(months[index], amount, isIncome)
+
+		if accountSums[total.ID.AccountID] == nil {
+			accountSums[total.ID.AccountID] = &sums{}
+		}
+		add(accountSums[total.ID.AccountID], amount, isIncome)
+
+		add(&totals, amount, isIncome)
+	}
+
+	// tagged transactions count towards every one of their tags
+	tagSums := map[string]*sums{}
+	for _, total := range result.ByTag {
+		if tagSums[total.ID.Tag] == nil {
+			tagSums[total.ID.Tag] = &sums{}
+		}
+		add(tagSums[total.ID.Tag], converter.Convert(total.Amount, total.ID.Currency, total.ID.Day), total.ID.IsIncome)
+	}
+
+	merchantSums := map[string]*sums{}
+	for _, total := range result.ByMerchant {
+		if merchantSums[total.ID.Merchant] == nil {
+			merchantSums[total.ID.Merchant] = &sums{}
+		}
+		add(merchantSums[total.ID.Merchant], converter.Convert(total.Amount, total.ID.Currency, total.ID.Day), total.ID.IsIncome)
+	}
+
+	byCategory := []*CategoryTotal{}
+	for name, total := range categories.Rollup(own) {
+		byCategory = append(byCategory, &CategoryTotal{
+			Category: name,
+			Path:     categories.Path(name),
+			Kind:     categories.Kind(name),
+			Amount:   own[name],
+			Total:    total,
+		})
+	}
+	sort.Slice(byCategory, func(i, j int) bool { return byCategory[i].Path < byCategory[j].Path })
+
+	byMonth := []*MonthTotal{}
+	for index, total := range months {
+		month, year := monthFromIndex(index)
+		byMonth = append(byMonth, &MonthTotal{
+			Year:    year,
+			Month:   month,
+			Income:  total.Income,
+			Expense: -1 * total.Expense,
+			Net:     total.Income + total.Expense,
+		})
+	}
+	sort.Slice(byMonth, func(i, j int) bool {
+		return monthIndex(byMonth[i].Month, byMonth[i].Year) < monthIndex(byMonth[j].Month, byMonth[j].Year)
+	})
+
+	var accounts []*Account
+	cursor, err = h.Db.Accounts.Find(ctx, bson.M{"user_id": *userID})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = cursor.All(ctx, &accounts); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	accountsByID := map[string]*Account{}
+	for _, account := range accounts {
+		accountsByID[account.AccountID] = account
+	}
+
+	byAccount := []*AccountTotal{}
+	for accountID, total := range accountSums {
+		accountTotal := &AccountTotal{
+			AccountID: accountID,
+			Income:    total.Income,
+			Expense:   -1 * total.Expense,
+			Net:       total.Income + total.Expense,
+		}
+		if account, ok := accountsByID[accountID]; ok {
+			accountTotal.Name = account.Name
+			accountTotal.Institution = account.Institution
+		}
+		byAccount = append(byAccount, accountTotal)
+	}
+	sort.Slice(byAccount, func(i, j int) bool { return byAccount[i].AccountID < byAccount[j].AccountID })
+
+	byTag := []*TagTotal{}
+	for tag, total := range tagSums {
+		byTag = append(byTag, &TagTotal{
+			Tag:     tag,
+			Income:  total.Income,
+			Expense: -1 * total.Expense,
+			Net:     total.Income + total.Expense,
+		})
+	}
+	sort.Slice(byTag, func(i, j int) bool { return byTag[i].Tag < byTag[j].Tag })
+
+	merchants, err := GetUserMerchants(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	byMerchant := []*MerchantTotal{}
+	for name, total := range merchantSums {
+		merchantTotal := &MerchantTotal{
+			Merchant:    name,
+			DisplayName: merchants.DisplayName(name),
+			Income:      total.Income,
+			Expense:     -1 * total.Expense,
+			Net:         total.Income + total.Expense,
+		}
+		if m := merchants.Get(name); m != nil {
+			merchantTotal.LogoURL = m.LogoURL
+		}
+		byMerchant = append(byMerchant, merchantTotal)
+	}
+	// biggest spending first
+	sort.Slice(byMerchant, func(i, j int) bool {
+		if byMerchant[i].Expense != byMerchant[j].Expense {
+			return byMerchant[i].Expense > byMerchant[j].Expense
+		}
+		return byMerchant[i].Merchant < byMerchant[j].Merchant
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"by_category": byCategory,
+		"by_tag":      byTag,
+		"by_merchant": byMerchant,
+		"by_month":    byMonth,
+		"by_account":  byAccount,
+		"income":      totals.Income,
+		"expense":     -1 * totals.Expense,
+		"net":         totals.Income + totals.Expense,
+		"currency":    converter.Base,
+		"unconverted": converter.Missing(),
+	})
+}
@@ -0,0 +1,128 @@
+package finances
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/util"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const maxTagLength = 50
+
+// Lowercases and trims tags, dropping empty and repeated ones
+func NormalizeTags(tags []string) []string {
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(util.RemoveDuplicateWhitespace(strings.TrimSpace(tag)))
+		if tag == "" || len(tag) > maxTagLength || util.Contains(&normalized, tag) {
+			continue
+		}
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// Adds tags to many transactions at once
+func (h *Handler) AddTags(c *gin.Context) {
+	h.updateTags(c, true)
+}
+
+// Removes tags from many transactions at once
+func (h *Handler) RemoveTags(c *gin.Context) {
+	h.updateTags(c, false)
+}
+
+func (h *Handler) updateTags(c *gin.Context, add bool) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	type Input struct {
+		TransactionIDs []string `json:"transaction_ids" validate:"required,min=1"`
+		Tags           []string `json:"tags" validate:"required,min=1"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = v.Struct(input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	tags := NormalizeTags(input.Tags)
+	if len(tags) == 0 {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	// removed tags are locked so rules don't add them back
+	update := bson.M{
+		"$pull":     bson.M{"tags": bson.M{"$in": tags}},
+		"$addToSet": lockFields(LockTags),
+	}
+	if add {
+		update = bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": tags}}}
+	}
+	update["$set"] = bson.M{"updated_at": time.Now()}
+
+	result, err := h.Db.Transactions.UpdateMany(
+		ctx,
+		bson.M{"user_id": *userID, "transaction_id": bson.M{"$in": input.TransactionIDs}},
+		update,
+	)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"updated": result.ModifiedCount,
+	})
+}
+
+// Returns every tag the user has used
+func (h *Handler) GetTags(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	values, err := h.Db.Transactions.Distinct(ctx, "tags", bson.M{"user_id": *userID})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	tags := []string{}
+	for _, value := range values {
+		if tag, ok := value.(string); ok {
+			tags = append(tags, tag)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tags": tags,
+	})
+}
@@ -0,0 +1,481 @@
+package finances
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/merchant"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How often a template repeats
+const (
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+)
+
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// Most days back a template catches up on, older occurrences are skipped
+const TemplateCatchUpDays = 366
+
+// Schedule is when a template creates transactions, e.g. monthly on the 1st or every 2 weeks
+type Schedule struct {
+	Frequency string `json:"frequency" bson:"frequency"`
+
+	// every n weeks or months
+	Interval int `json:"interval" bson:"interval"`
+
+	// day of the month for monthly schedules, days past the end of a month fall on its last day
+	DayOfMonth int `json:"day_of_month" bson:"day_of_month,omitempty"`
+
+	StartDate time.Time  `json:"start_date" bson:"start_date"`
+	EndDate   *time.Time `json:"end_date" bson:"end_date,omitempty"`
+}
+
+func (s *Schedule) Validate() error {
+	if s.Interval == 0 {
+		s.Interval = 1
+	}
+	if s.Interval < 1 || s.StartDate.IsZero() {
+		return ErrInvalidSchedule
+	}
+	switch s.Frequency {
+	case FrequencyWeekly:
+		s.DayOfMonth = 0
+	case FrequencyMonthly:
+		if s.DayOfMonth == 0 {
+			s.DayOfMonth = s.StartDate.Day()
+		}
+		if s.DayOfMonth < 1 || s.DayOfMonth > 31 {
+			return ErrInvalidSchedule
+		}
+	default:
+		return ErrInvalidSchedule
+	}
+	if s.EndDate != nil && s.EndDate.Before(s.StartDate) {
+		return ErrInvalidSchedule
+	}
+	return nil
+}
+
+// Returns the date of the nth occurrence counting from 0, the first is on or after the start date
+func (s *Schedule) Occurrence(n int) time.Time {
+	start := time.Date(s.StartDate.Year(), s.StartDate.Month(), s.StartDate.Day(), 0, 0, 0, 0, time.UTC)
+	if s.Frequency == FrequencyWeekly {
+		return start.AddDate(0, 0, 7*s.Interval*n)
+	}
+
+	monthly := func(months int) time.Time {
+		first := time.Date(start.Year(), start.Month()+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+		day := s.DayOfMonth
+		if last := first.AddDate(0, 1, -1).Day(); day > last {
+			day = last
+		}
+		return first.AddDate(0, 0, day-1)
+	}
+	offset := 0
+	if monthly(0).Before(start) {
+		offset = 1
+	}
+	return monthly(offset + s.Interval*n)
+}
+
+// Returns true if the nth occurrence is within the schedule
+func (s *Schedule) Includes(n int) bool {
+	return s.EndDate == nil || !s.Occurrence(n).After(*s.EndDate)
+}
+
+// Template is a manual transaction that is created again on a schedule
+type Template struct {
+	ID       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID   primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Name     string             `json:"name" bson:"name"`
+	Category string             `json:"category" bson:"category"`
+	Amount   money.Amount       `json:"amount" bson:"amount"`
+	Tags     []string           `json:"tags" bson:"tags"`
+	Notes    string             `json:"notes" bson:"notes"`
+	Schedule Schedule           `json:"schedule" bson:"schedule"`
+	Paused   bool               `json:"paused" bson:"paused"`
+
+	// occurrences already created and the date of the next one, ended templates have none left
+	Created  int       `json:"created" bson:"created"`
+	NextDate time.Time `json:"next_date" bson:"next_date"`
+	Ended    bool      `json:"ended" bson:"ended"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// Returns the id of the transaction created for an occurrence. Ids are derived from the template and
+// date so running the same occurrence twice is caught by the unique transaction_id index.
+func (t *Template) TransactionID(date time.Time) string {
+	return fmt.Sprintf("template-%s-%s", t.ID.Hex(), date.Format("2006-01-02"))
+}
+
+// Creates every occurrence of a template due by now, catching up on any that were missed within
+// the catch up window
+func materializeTemplate(ctx context.Context, db *db.MongoDb, template *Template, now time.Time) (int, error) {
+	categories, err := GetUserCategories(ctx, db, &template.UserID)
+	if err != nil {
+		return 0, err
+	}
+	amount := NormalizeAmount(template.Amount, categories.Kind(template.Category))
+
+	created := template.Created
+	if earliest := now.AddDate(0, 0, -TemplateCatchUpDays); template.Schedule.Occurrence(created).Before(earliest) {
+		created = firstOccurrenceFrom(&template.Schedule, earliest)
+	}
+	docs := []interface{}{}
+	for ; template.Schedule.Includes(created) && !template.Schedule.Occurrence(created).After(now); created++ {
+		date := template.Schedule.Occurrence(created)
+		docs = append(docs, bson.D{
+			{Key: "transaction_id", Value: template.TransactionID(date)},
+			{Key: "enrollment_id", Value: "user_created"},
+			{Key: "name", Value: template.Name},
+			{Key: "category", Value: template.Category},
+			{Key: "original_category", Value: template.Category},
+			{Key: "original_name", Value: template.Name},
+			{Key: "merchant", Value: merchant.Normalize(template.Name)},
+			{Key: "amount", Value: amount},
+			{Key: "currency", Value: money.DefaultCurrency},
+			{Key: "tags", Value: template.Tags},
+			{Key: "notes", Value: template.Notes},
+			{Key: "date", Value: date},
+			{Key: "user_id", Value: template.UserID},
+			{Key: "account_id", Value: "user_created"},
+			{Key: "template_id", Value: template.ID},
+			{Key: "created_at", Value: time.Now()},
+			{Key: "updated_at", Value: time.Now()},
+		})
+	}
+	// templates past their end date are marked so they aren't looked at again
+	ended := !template.Schedule.Includes(created)
+	if len(docs) == 0 && !ended {
+		return 0, nil
+	}
+
+	// occurrences saved before an interrupted run are skipped as duplicates
+	inserted := len(docs)
+	if len(docs) > 0 {
+		_, err = db.Transactions.InsertMany(ctx, docs, &options.InsertManyOptions{
+			Ordered: util.BoolPointer(false),
+		})
+		if err != nil && !strings.Contains(err.Error(), "duplicate key error") {
+			return 0, err
+		}
+		var bulkErr mongo.BulkWriteException
+		if errors.As(err, &bulkErr) {
+			inserted -= len(bulkErr.WriteErrors)
+		}
+	}
+
+	template.Created = created
+	template.NextDate = template.Schedule.Occurrence(created)
+	template.Ended = ended
+	_, err = db.Templates.UpdateOne(ctx, bson.M{"_id": template.ID}, bson.M{"$set": bson.M{
+		"created":    template.Created,
+		"next_date":  template.NextDate,
+		"ended":      template.Ended,
+		"updated_at": time.Now(),
+	}})
+	if err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
+
+// Creates the transactions of every template that has come due, returning how many were created
+func MaterializeTemplates(ctx context.Context, db *db.MongoDb, now time.Time) (int, error) {
+	var templates []*Template
+	cursor, err := db.Templates.Find(ctx, bson.M{"paused": false, "ended": bson.M{"$ne": true}, "next_date": bson.M{"$lte": now}})
+	if err != nil {
+		return 0, err
+	}
+	if err = cursor.All(ctx, &templates); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, template := range templates {
+		count, err := materializeTemplate(ctx, db, template, now)
+		if err != nil {
+			log.Printf("error creating transactions for template %s: %v", template.ID.Hex(), err)
+			continue
+		}
+		total += count
+	}
+	return total, nil
+}
+
+type templateInput struct {
+	Name       string   `json:"name" validate:"required"`
+	Category   string   `json:"category" validate:"required"`
+	Amount     string   `json:"amount" validate:"required"`
+	Tags       []string `json:"tags"`
+	Notes      string   `json:"notes"`
+	Frequency  string   `json:"frequency" validate:"required"`
+	Interval   int      `json:"interval"`
+	DayOfMonth int      `json:"day_of_month"`
+
+	// YYYY-MM-DD dates, end_date is optional
+	StartDate string `json:"start_date" validate:"required"`
+	EndDate   string `json:"end_date"`
+	Paused    bool   `json:"paused"`
+}
+
+// Parses a template from the request body, the amount is kept positive and signed by category kind when used
+func parseTemplateInput(c *gin.Context, categories CategorySet) (*Template, bool) {
+	var input *templateInput
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, false
+	}
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil || input == nil {
+		return nil, false
+	}
+	err = v.Struct(input)
+	if err != nil {
+		return nil, false
+	}
+
+	if !categories.Contains(input.Category) {
+		return nil, false
+	}
+	amount, err := money.Parse(strings.Replace(input.Amount, "-", "", -1))
+	if err != nil || amount == 0 {
+		return nil, false
+	}
+	startDate, err := time.Parse("2006-01-02", input.StartDate)
+	if err != nil {
+		return nil, false
+	}
+	schedule := Schedule{
+		Frequency:  input.Frequency,
+		Interval:   input.Interval,
+		DayOfMonth: input.DayOfMonth,
+		StartDate:  startDate,
+	}
+	if input.EndDate != "" {
+		endDate, err := time.Parse("2006-01-02", input.EndDate)
+		if err != nil {
+			return nil, false
+		}
+		schedule.EndDate = &endDate
+	}
+	if err = schedule.Validate(); err != nil {
+		return nil, false
+	}
+
+	return &Template{
+		Name:     util.RemoveDuplicateWhitespace(strings.TrimSpace(input.Name)),
+		Category: input.Category,
+		Amount:   amount,
+		Tags:     NormalizeTags(input.Tags),
+		Notes:    strings.TrimSpace(input.Notes),
+		Schedule: schedule,
+		Paused:   input.Paused,
+	}, true
+}
+
+// Returns the index of the first occurrence on or after a date
+func firstOccurrenceFrom(schedule *Schedule, date time.Time) int {
+	n := 0
+	for schedule.Occurrence(n).Before(date) {
+		n++
+	}
+	return n
+}
+
+func (h *Handler) GetTemplates(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	var templates []*Template
+	opts := options.Find().SetSort(bson.D{{Key: "next_date", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := h.Db.Templates.Find(ctx, bson.M{"user_id": *userID}, opts)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = cursor.All(ctx, &templates); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"templates": templates,
+	})
+}
+
+// Creates a template along with any of its transactions already due
+func (h *Handler) CreateTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	template, ok := parseTemplateInput(c, categories)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	template.ID = primitive.NewObjectID()
+	template.UserID = *userID
+	template.NextDate = template.Schedule.Occurrence(0)
+	template.CreatedAt = time.Now()
+	template.UpdatedAt = time.Now()
+	if _, err = h.Db.Templates.InsertOne(ctx, template); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	created := 0
+	if !template.Paused {
+		created, err = materializeTemplate(ctx, h.Db, template, time.Now())
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"template": template,
+		"created":  created,
+	})
+}
+
+// Replaces a template. Transactions it already created are left as they are, and occurrences
+// from while it was paused are skipped when it is resumed.
+func (h *Handler) UpdateTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	templateID, err := primitive.ObjectIDFromHex(c.Param("template_id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	categories, err := GetUserCategories(ctx, h.Db, userID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	update, ok := parseTemplateInput(c, categories)
+	if !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var template *Template
+	filter := bson.M{"_id": templateID, "user_id": *userID}
+	if err = h.Db.Templates.FindOne(ctx, filter).Decode(&template); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	// a new schedule starts over from today so past occurrences aren't created again
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	created := template.Created
+	if !sameOccurrences(&update.Schedule, &template.Schedule) {
+		created = firstOccurrenceFrom(&update.Schedule, today)
+	}
+	if template.Paused && !update.Paused {
+		if n := firstOccurrenceFrom(&update.Schedule, today); n > created {
+			created = n
+		}
+	}
+
+	template.Name = update.Name
+	template.Category = update.Category
+	template.Amount = update.Amount
+	template.Tags = update.Tags
+	template.Notes = update.Notes
+	template.Schedule = update.Schedule
+	template.Paused = update.Paused
+	template.Created = created
+	template.NextDate = update.Schedule.Occurrence(created)
+	template.Ended = !update.Schedule.Includes(created)
+	template.UpdatedAt = time.Now()
+	if _, err = h.Db.Templates.ReplaceOne(ctx, filter, template); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"template": template,
+	})
+}
+
+// Deletes a template, transactions it created are kept
+func (h *Handler) DeleteTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	templateID, err := primitive.ObjectIDFromHex(c.Param("template_id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.Db.Templates.DeleteOne(ctx, bson.M{"_id": templateID, "user_id": *userID})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+}
+
+// Returns true if two schedules fall on the same dates, end dates aside
+func sameOccurrences(a, b *Schedule) bool {
+	return a.Frequency == b.Frequency && a.Interval == b.Interval && a.DayOfMonth == b.DayOfMonth &&
+		a.StartDate.Equal(b.StartDate)
+}
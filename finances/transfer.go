@@ -0,0 +1,349 @@
+package finances
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tony-tvu/goexpense/auth"
+	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/money"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Most days apart the two sides of a transfer can post
+const TransferWindowDays = 3
+
+// Transfer statuses. Detected transfers wait for the user and still count, confirmed ones are
+// left out of totals and unlinked pairs are kept so they aren't detected again.
+const (
+	TransferDetected  = "detected"
+	TransferConfirmed = "confirmed"
+	TransferUnlinked  = "unlinked"
+)
+
+// Transfer links money leaving one of the user's accounts with the same money arriving in another
+type Transfer struct {
+	ID                primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID            primitive.ObjectID `json:"user_id" bson:"user_id"`
+	FromTransactionID string             `json:"from_transaction_id" bson:"from_transaction_id"`
+	ToTransactionID   string             `json:"to_transaction_id" bson:"to_transaction_id"`
+	Amount            money.Amount       `json:"amount" bson:"amount"`
+	Currency          string             `json:"currency" bson:"currency"`
+	Status            string             `json:"status" bson:"status"`
+	CreatedAt         time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt         time.Time          `json:"updated_at" bson:"updated_at"`
+
+	From *Transaction `json:"from,omitempty" bson:"-"`
+	To   *Transaction `json:"to,omitempty" bson:"-"`
+}
+
+// Filter matching transactions that count towards totals, which transfers don't
+var notTransfer = bson.M{"$exists": false}
+
+// Returns the key of an outflow and inflow pair
+func TransferKey(from, to string) string {
+	return from + "|" + to
+}
+
+// Pairs outflows with inflows of the same amount and currency in a different account within
+// the transfer window. Each outflow takes the closest inflow by date, pairs in skip are never made.
+// Refunds, reimbursements and transactions the user categorized by hand aren't transfers.
+func MatchTransfers(transactions []*Transaction, skip map[string]bool) [][2]*Transaction {
+	outflows := []*Transaction{}
+	inflows := map[string][]*Transaction{}
+	for _, t := range transactions {
+		if t.TransferID != nil || len(t.Splits) > 0 || t.RefundOf != "" || len(t.Reimburses) > 0 ||
+			t.Reimbursement != nil || t.IsLocked(LockCategory) {
+			continue
+		}
+		if t.Amount < 0 {
+			outflows = append(outflows, t)
+		} else if t.Amount > 0 {
+			key := transferMatchKey(t.Amount, t.Currency)
+			inflows[key] = append(inflows[key], t)
+		}
+	}
+	sort.SliceStable(outflows, func(i, j int) bool { return outflows[i].Date.Before(outflows[j].Date) })
+	for _, candidates := range inflows {
+		sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Date.Before(candidates[j].Date) })
+	}
+
+	// only inflows of the same amount and currency within the window around each outflow are compared
+	window := time.Duration(TransferWindowDays) * 24 * time.Hour
+	matched := map[string]bool{}
+	pairs := [][2]*Transaction{}
+	for _, out := range outflows {
+		candidates := inflows[transferMatchKey(out.Amount.Abs(), out.Currency)]
+		earliest := out.Date.Add(-window)
+		start := sort.Search(len(candidates), func(i int) bool { return !candidates[i].Date.Before(earliest) })
+
+		var best *Transaction
+		var bestGap time.Duration
+		for _, in := range candidates[start:] {
+			if in.Date.Sub(out.Date) > window {
+				break
+			}
+			if matched[in.TransactionID] || in.AccountID == out.AccountID || skip[TransferKey(out.TransactionID, in.TransactionID)] {
+				continue
+			}
+			gap := in.Date.Sub(out.Date)
+			if gap < 0 {
+				gap = -gap
+			}
+			if best == nil || gap < bestGap {
+				best, bestGap = in, gap
+			}
+		}
+		if best != nil {
+			matched[best.TransactionID] = true
+			pairs = append(pairs, [2]*Transaction{out, best})
+		}
+	}
+	return pairs
+}
+
+func transferMatchKey(amount money.Amount, currency string) string {
+	return amount.String() + "|" + currency
+}
+
+// Finds the user's unpaired transactions that look like transfers between their accounts for the
+// user to confirm, returning how many transfers were found. Only transactions from since on, less
+// the transfer window, are looked at. A zero since looks at all of them.
+func DetectTransfers(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID, since time.Time) (int, error) {
+	categories, err := GetUserCategories(ctx, db, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	filter := bson.M{
+		"user_id":     *userID,
+		"transfer_id": notTransfer,
+		"splits":      bson.M{"$exists": false},
+		"category":    bson.M{"$nin": categories.NamesOfKind(Ignore)},
+	}
+	if !since.IsZero() {
+		filter["date"] = bson.M{"$gte": since.AddDate(0, 0, -TransferWindowDays)}
+	}
+	var transactions []*Transaction
+	cursor, err := db.Transactions.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	if err = cursor.All(ctx, &transactions); err != nil {
+		return 0, err
+	}
+
+	// transactions waiting in a detected transfer aren't paired twice
+	var existing []*Transfer
+	cursor, err = db.Transfers.Find(ctx, bson.M{"user_id": *userID})
+	if err != nil {
+		return 0, err
+	}
+	if err = cursor.All(ctx, &existing); err != nil {
+		return 0, err
+	}
+	skip := map[string]bool{}
+	pending := map[string]bool{}
+	for _, transfer := range existing {
+		if transfer.Status == TransferUnlinked {
+			skip[TransferKey(transfer.FromTransactionID, transfer.ToTransactionID)] = true
+		} else {
+			pending[transfer.FromTransactionID] = true
+			pending[transfer.ToTransactionID] = true
+		}
+	}
+	unpaired := []*Transaction{}
+	for _, t := range transactions {
+		if !pending[t.TransactionID] {
+			unpaired = append(unpaired, t)
+		}
+	}
+
+	pairs := MatchTransfers(unpaired, skip)
+	for _, pair := range pairs {
+		transfer := &Transfer{
+			ID:                primitive.NewObjectID(),
+			UserID:            *userID,
+			FromTransactionID: pair[0].TransactionID,
+			ToTransactionID:   pair[1].TransactionID,
+			Amount:            pair[1].Amount,
+			Currency:          pair[1].Currency,
+			Status:            TransferDetected,
+			CreatedAt:         time.Now(),
+			UpdatedAt:         time.Now(),
+		}
+		if _, err = db.Transfers.InsertOne(ctx, transfer); err != nil {
+			return 0, err
+		}
+	}
+	return len(pairs), nil
+}
+
+// Deletes the detected or confirmed transfer of a transaction and unlinks the other side
+func deleteTransfer(ctx context.Context, db *db.MongoDb, userID *primitive.ObjectID, transactionID string) error {
+	var transfer *Transfer
+	err := db.Transfers.FindOneAndDelete(ctx, bson.M{
+		"user_id": *userID,
+		"status":  bson.M{"$ne": TransferUnlinked},
+		"$or": bson.A{
+			bson.M{"from_transaction_id": transactionID},
+			bson.M{"to_transaction_id": transactionID},
+		},
+	}).Decode(&transfer)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = db.Transactions.UpdateMany(
+		ctx,
+		bson.M{"user_id": *userID, "transfer_id": transfer.ID},
+		bson.M{"$unset": bson.M{"transfer_id": ""}, "$set": bson.M{"updated_at": time.Now()}},
+	)
+	return err
+}
+
+// Returns the user's linked transfers with both of their transactions, newest first
+func (h *Handler) GetTransfers(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	if c.Query("refresh") == "true" {
+		if _, err = DetectTransfers(ctx, h.Db, userID, time.Time{}); err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var transfers []*Transfer
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := h.Db.Transfers.Find(ctx, bson.M{"user_id": *userID, "status": bson.M{"$ne": TransferUnlinked}}, opts)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = cursor.All(ctx, &transfers); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	ids := bson.A{}
+	for _, transfer := range transfers {
+		ids = append(ids, transfer.FromTransactionID, transfer.ToTransactionID)
+	}
+	var transactions []*Transaction
+	cursor, err = h.Db.Transactions.Find(ctx, bson.M{"user_id": *userID, "transaction_id": bson.M{"$in": ids}})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if err = cursor.All(ctx, &transactions); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	byID := map[string]*Transaction{}
+	for _, t := range transactions {
+		byID[t.TransactionID] = t
+	}
+	for _, transfer := range transfers {
+		transfer.From = byID[transfer.FromTransactionID]
+		transfer.To = byID[transfer.ToTransactionID]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transfers": transfers,
+	})
+}
+
+// Marks a detected transfer as confirmed by the user, which leaves both transactions out of totals
+func (h *Handler) ConfirmTransfer(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	transferID, err := primitive.ObjectIDFromHex(c.Param("transfer_id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var transfer *Transfer
+	err = h.Db.Transfers.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": transferID, "user_id": *userID, "status": bson.M{"$ne": TransferUnlinked}},
+		bson.M{"$set": bson.M{"status": TransferConfirmed, "updated_at": time.Now()}},
+	).Decode(&transfer)
+	if err == mongo.ErrNoDocuments {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	_, err = h.Db.Transactions.UpdateMany(
+		ctx,
+		bson.M{"user_id": *userID, "transaction_id": bson.M{"$in": bson.A{transfer.FromTransactionID, transfer.ToTransactionID}}},
+		bson.M{"$set": bson.M{"transfer_id": transfer.ID, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+}
+
+// Unlinks a transfer so both transactions count towards totals again and aren't paired again
+func (h *Handler) UnlinkTransfer(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	transferID, err := primitive.ObjectIDFromHex(c.Param("transfer_id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var transfer *Transfer
+	err = h.Db.Transfers.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": transferID, "user_id": *userID, "status": bson.M{"$ne": TransferUnlinked}},
+		bson.M{"$set": bson.M{"status": TransferUnlinked, "updated_at": time.Now()}},
+	).Decode(&transfer)
+	if err == mongo.ErrNoDocuments {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	_, err = h.Db.Transactions.UpdateMany(
+		ctx,
+		bson.M{"user_id": *userID, "transfer_id": transfer.ID},
+		bson.M{"$unset": bson.M{"transfer_id": ""}, "$set": bson.M{"updated_at": time.Now()}},
+	)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,247 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tony-tvu/goexpense/money"
+)
+
+// How amounts in a single amount column are signed
+const (
+	ExpensesNegative = "expenses_negative"
+	ExpensesPositive = "expenses_positive"
+)
+
+var (
+	ErrInvalidMapping = errors.New("invalid mapping")
+	ErrMissingColumn  = errors.New("missing column")
+)
+
+// Date format tokens, longest first so "YYYY" isn't read as two "YY"
+var dateTokens = strings.NewReplacer(
+	"YYYY", "2006",
+	"YY", "06",
+	"MMM", "Jan",
+	"MM", "01",
+	"M", "1",
+	"DD", "02",
+	"D", "2",
+)
+
+// Mapping describes how the columns of a bank's CSV export map to transactions. Columns are
+// matched by their header, amounts come from one signed column or separate debit and credit columns.
+type Mapping struct {
+	DateColumn        string `json:"date_column" bson:"date_column"`
+	DateFormat        string `json:"date_format" bson:"date_format"`
+	DescriptionColumn string `json:"description_column" bson:"description_column"`
+	AmountColumn      string `json:"amount_column" bson:"amount_column,omitempty"`
+	DebitColumn       string `json:"debit_column" bson:"debit_column,omitempty"`
+	CreditColumn      string `json:"credit_column" bson:"credit_column,omitempty"`
+	SignConvention    string `json:"sign_convention" bson:"sign_convention,omitempty"`
+	Delimiter         string `json:"delimiter" bson:"delimiter,omitempty"`
+}
+
+// Row is one parsed statement line, expenses are negative. Lines are numbered from the header
+// as line 1, not counting empty lines.
+type Row struct {
+	Line        int          `json:"line"`
+	Date        time.Time    `json:"date"`
+	Description string       `json:"description"`
+	Amount      money.Amount `json:"amount"`
+}
+
+// RowError is a line that couldn't be parsed
+type RowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// Returns the Go layout of a date format written with YYYY, MM, DD style tokens
+func DateLayout(format string) string {
+	return dateTokens.Replace(format)
+}
+
+// Checks that the mapping names the columns it needs
+func (m *Mapping) Validate() error {
+	if strings.TrimSpace(m.DateColumn) == "" || strings.TrimSpace(m.DateFormat) == "" || strings.TrimSpace(m.DescriptionColumn) == "" {
+		return ErrInvalidMapping
+	}
+	hasAmount := strings.TrimSpace(m.AmountColumn) != ""
+	hasDebitCredit := strings.TrimSpace(m.DebitColumn) != "" && strings.TrimSpace(m.CreditColumn) != ""
+	if hasAmount == hasDebitCredit {
+		return ErrInvalidMapping
+	}
+	if m.SignConvention != "" && m.SignConvention != ExpensesNegative && m.SignConvention != ExpensesPositive {
+		return ErrInvalidMapping
+	}
+	if m.Delimiter != "" && utf8.RuneCountInString(m.Delimiter) != 1 {
+		return ErrInvalidMapping
+	}
+	return nil
+}
+
+// Parses a CSV export with a header row. Lines that can't be parsed are returned as row errors
+// so the rest of the file can still be imported, an error is only returned when the file itself
+// doesn't fit the mapping.
+func ParseCSV(r io.Reader, m *Mapping) ([]*Row, []*RowError, error) {
+	if err := m.Validate(); err != nil {
+		return nil, nil, err
+	}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	if m.Delimiter != "" {
+		reader.Comma, _ = utf8.DecodeRuneInString(m.Delimiter)
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		// excel adds a byte order mark to the first header
+		name = strings.TrimPrefix(name, "\ufeff")
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	column := func(name string) (int, error) {
+		if name == "" {
+			return -1, nil
+		}
+		i, ok := columns[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return -1, fmt.Errorf("%w: %s", ErrMissingColumn, name)
+		}
+		return i, nil
+	}
+	indexes := map[string]int{}
+	for key, name := range map[string]string{
+		"date":        m.DateColumn,
+		"description": m.DescriptionColumn,
+		"amount":      m.AmountColumn,
+		"debit":       m.DebitColumn,
+		"credit":      m.CreditColumn,
+	} {
+		if indexes[key], err = column(name); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	layout := DateLayout(m.DateFormat)
+	rows := []*Row{}
+	rowErrors := []*RowError{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, &RowError{Line: line, Error: err.Error()})
+			continue
+		}
+		if isBlank(record) {
+			continue
+		}
+		row, err := parseRecord(record, indexes, layout, m.SignConvention)
+		if err != nil {
+			rowErrors = append(rowErrors, &RowError{Line: line, Error: err.Error()})
+			continue
+		}
+		row.Line = line
+		rows = append(rows, row)
+	}
+	return rows, rowErrors, nil
+}
+
+func parseRecord(record []string, indexes map[string]int, layout, signConvention string) (*Row, error) {
+	field := func(key string) string {
+		i := indexes[key]
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	date, err := time.Parse(layout, field("date"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q", field("date"))
+	}
+	description := strings.Join(strings.Fields(field("description")), " ")
+	if description == "" {
+		return nil, errors.New("missing description")
+	}
+
+	var amount money.Amount
+	if indexes["amount"] >= 0 {
+		if amount, err = ParseAmount(field("amount")); err != nil {
+			return nil, fmt.Errorf("invalid amount %q", field("amount"))
+		}
+		if signConvention == ExpensesPositive {
+			amount = -amount
+		}
+	} else {
+		// banks put each side in its own column and leave the other empty
+		debit, credit := field("debit"), field("credit")
+		if debit != "" {
+			parsed, err := ParseAmount(debit)
+			if err != nil {
+				return nil, fmt.Errorf("invalid debit %q", debit)
+			}
+			amount -= parsed.Abs()
+		}
+		if credit != "" {
+			parsed, err := ParseAmount(credit)
+			if err != nil {
+				return nil, fmt.Errorf("invalid credit %q", credit)
+			}
+			amount += parsed.Abs()
+		}
+	}
+	if amount == 0 {
+		return nil, errors.New("missing amount")
+	}
+
+	return &Row{
+		Date:        time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC),
+		Description: description,
+		Amount:      amount,
+	}, nil
+}
+
+// Parses amounts the way statements write them, like "$1,234.56", "-12.00" or "(12.00)"
+func ParseAmount(s string) (money.Amount, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		negative = true
+		s = s[1 : len(s)-1]
+	}
+	s = strings.NewReplacer("$", "", "€", "", "£", "", ",", "", " ", "").Replace(s)
+	if strings.HasSuffix(s, "-") {
+		negative = true
+		s = strings.TrimSuffix(s, "-")
+	}
+	amount, err := money.Parse(s)
+	if err != nil {
+		return 0, err
+	}
+	if negative {
+		amount = -amount.Abs()
+	}
+	return amount, nil
+}
+
+func isBlank(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,270 @@
+package importer
+
+import (
+	"errors"
+	"html"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tony-tvu/goexpense/money"
+)
+
+var ErrInvalidOFX = errors.New("invalid ofx")
+
+// Statement is one account's statement from an OFX or QFX file
+type Statement struct {
+	AccountID    string
+	Currency     string
+	Transactions []*StatementTransaction
+
+	// ledger balance and when it was taken, nil when the statement has none
+	Balance     *money.Amount
+	BalanceDate time.Time
+}
+
+// StatementTransaction is a STMTTRN entry, expenses are negative
+type StatementTransaction struct {
+	FITID  string
+	Type   string
+	Date   time.Time
+	Amount money.Amount
+	Name   string
+	Memo   string
+}
+
+// node is an OFX aggregate, or an element when it has a value
+type node struct {
+	name     string
+	value    string
+	children []*node
+}
+
+// Returns the first descendant with a name, following a path of names
+func (n *node) find(path ...string) *node {
+	current := n
+	for _, name := range path {
+		var next *node
+		for _, child := range current.children {
+			if child.name == name {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		current = next
+	}
+	return current
+}
+
+// Returns the value of the element at a path, empty if it isn't there
+func (n *node) text(path ...string) string {
+	if found := n.find(path...); found != nil {
+		return found.value
+	}
+	return ""
+}
+
+// Returns every descendant with a name
+func (n *node) all(name string) []*node {
+	found := []*node{}
+	for _, child := range n.children {
+		if child.name == name {
+			found = append(found, child)
+		}
+		found = append(found, child.all(name)...)
+	}
+	return found
+}
+
+// Parses OFX 1.x, which is SGML where elements don't need closing tags, and OFX 2.x, which is XML.
+// QFX files are OFX with a few extra Quicken elements that are ignored.
+func ParseOFX(r io.Reader) ([]*Statement, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseOFXTree(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	statements := []*Statement{}
+	for _, stmt := range append(root.all("STMTRS"), root.all("CCSTMTRS")...) {
+		statement := &Statement{
+			Currency:     strings.ToUpper(stmt.text("CURDEF")),
+			Transactions: []*StatementTransaction{},
+		}
+		if from := stmt.find("BANKACCTFROM"); from != nil {
+			statement.AccountID = from.text("ACCTID")
+		} else if from := stmt.find("CCACCTFROM"); from != nil {
+			statement.AccountID = from.text("ACCTID")
+		}
+
+		if list := stmt.find("BANKTRANLIST"); list != nil {
+			for _, entry := range list.children {
+				if entry.name != "STMTTRN" {
+					continue
+				}
+				transaction, err := parseStatementTransaction(entry)
+				if err != nil {
+					return nil, err
+				}
+				statement.Transactions = append(statement.Transactions, transaction)
+			}
+		}
+
+		if ledger := stmt.find("LEDGERBAL"); ledger != nil {
+			balance, err := parseOFXAmount(ledger.text("BALAMT"))
+			if err != nil {
+				return nil, err
+			}
+			date, err := parseOFXDate(ledger.text("DTASOF"))
+			if err != nil {
+				return nil, err
+			}
+			statement.Balance, statement.BalanceDate = &balance, date
+		}
+		statements = append(statements, statement)
+	}
+	if len(statements) == 0 {
+		return nil, ErrInvalidOFX
+	}
+	return statements, nil
+}
+
+func parseStatementTransaction(entry *node) (*StatementTransaction, error) {
+	fitID := entry.text("FITID")
+	if fitID == "" {
+		return nil, ErrInvalidOFX
+	}
+	amount, err := parseOFXAmount(entry.text("TRNAMT"))
+	if err != nil {
+		return nil, err
+	}
+	date, err := parseOFXDate(entry.text("DTPOSTED"))
+	if err != nil {
+		return nil, err
+	}
+
+	// some banks only send a payee aggregate or just a memo
+	name := entry.text("NAME")
+	if name == "" {
+		name = entry.text("PAYEE", "NAME")
+	}
+	memo := entry.text("MEMO")
+	if name == "" {
+		name = memo
+	}
+	return &StatementTransaction{
+		FITID:  fitID,
+		Type:   strings.ToUpper(entry.text("TRNTYPE")),
+		Date:   date,
+		Amount: amount,
+		Name:   strings.Join(strings.Fields(name), " "),
+		Memo:   strings.Join(strings.Fields(memo), " "),
+	}, nil
+}
+
+// ofxElements are the tags that always hold a value, so they're elements even when it's empty
+var ofxElements = map[string]bool{
+	"CODE": true, "SEVERITY": true, "DTSERVER": true, "LANGUAGE": true, "TRNUID": true,
+	"CURDEF": true, "BANKID": true, "ACCTID": true, "ACCTTYPE": true, "DTSTART": true, "DTEND": true,
+	"TRNTYPE": true, "DTPOSTED": true, "DTUSER": true, "TRNAMT": true, "FITID": true, "NAME": true,
+	"MEMO": true, "CHECKNUM": true, "REFNUM": true, "PAYEEID": true, "SIC": true,
+	"BALAMT": true, "DTASOF": true,
+}
+
+// Builds the element tree of an OFX document. Elements are tags followed by a value, with or
+// without a closing tag, and aggregates are tags followed directly by other tags. Self-closing
+// tags and known elements without a value are empty elements.
+func parseOFXTree(data string) (*node, error) {
+	start := strings.Index(strings.ToUpper(data), "<OFX>")
+	if start < 0 {
+		return nil, ErrInvalidOFX
+	}
+	data = data[start:]
+
+	root := &node{}
+	stack := []*node{root}
+	for len(data) > 0 {
+		open := strings.Index(data, "<")
+		if open < 0 {
+			break
+		}
+		end := strings.Index(data[open:], ">")
+		if end < 0 {
+			return nil, ErrInvalidOFX
+		}
+		tag := strings.ToUpper(strings.TrimSpace(data[open+1 : open+end]))
+		data = data[open+end+1:]
+
+		// processing instructions and comments
+		if strings.HasPrefix(tag, "?") || strings.HasPrefix(tag, "!") {
+			continue
+		}
+
+		if strings.HasPrefix(tag, "/") {
+			name := tag[1:]
+			// closing an element that already ended with its value, or an aggregate
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+			continue
+		}
+
+		parent := stack[len(stack)-1]
+		if strings.HasSuffix(tag, "/") {
+			name := strings.TrimSpace(strings.TrimSuffix(tag, "/"))
+			parent.children = append(parent.children, &node{name: name})
+			continue
+		}
+
+		value := data
+		if next := strings.Index(data, "<"); next >= 0 {
+			value = data[:next]
+		}
+		value = strings.TrimSpace(value)
+
+		child := &node{name: tag, value: html.UnescapeString(value)}
+		parent.children = append(parent.children, child)
+		if value == "" && !ofxElements[tag] {
+			stack = append(stack, child)
+		}
+	}
+	if len(root.children) == 0 {
+		return nil, ErrInvalidOFX
+	}
+	return root, nil
+}
+
+// Parses OFX amounts, which may use a comma as the decimal separator
+func parseOFXAmount(s string) (money.Amount, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, ",") && !strings.Contains(s, ".") {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	amount, err := money.Parse(s)
+	if err != nil {
+		return 0, ErrInvalidOFX
+	}
+	return amount, nil
+}
+
+// Parses OFX dates like 20220314, 20220314120000 or 20220314120000.000[-5:EST], keeping only the day
+func parseOFXDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 8 {
+		return time.Time{}, ErrInvalidOFX
+	}
+	date, err := time.Parse("20060102", s[:8])
+	if err != nil {
+		return time.Time{}, ErrInvalidOFX
+	}
+	return date, nil
+}
@@ -6,8 +6,10 @@ import (
 	"time"
 
 	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/finances"
 	"github.com/tony-tvu/goexpense/teller"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Jobs struct {
@@ -15,6 +17,8 @@ type Jobs struct {
 	Enabled              bool
 	BalancesInterval     int
 	TransactionsInterval int
+	TemplatesInterval    int
+	RecurringInterval    int
 	TellerClient         *teller.TellerClient
 }
 
@@ -22,6 +26,8 @@ func (j *Jobs) Start(ctx context.Context) {
 	if j.Enabled {
 		go j.refreshTransactionsTask(ctx)
 		go j.refreshBalancesTask(ctx)
+		go j.materializeTemplatesTask(ctx)
+		go j.detectRecurringTask(ctx)
 	}
 }
 
@@ -60,3 +66,41 @@ func (t *Jobs) refreshTransactionsTask(ctx context.Context) {
 
 	}
 }
+
+// Looks for recurring charges of users with linked banks. Series change slowly so this runs far
+// less often than transactions are refreshed.
+func (t *Jobs) detectRecurringTask(ctx context.Context) {
+	for {
+		values, err := t.Db.Enrollments.Distinct(ctx, "user_id", bson.M{})
+		if err != nil {
+			log.Printf("error finding enrollments in detectRecurringTask: %v\n", err)
+		}
+
+		for _, value := range values {
+			userID, ok := value.(primitive.ObjectID)
+			if !ok {
+				continue
+			}
+			if _, err := finances.DetectRecurring(ctx, t.Db, &userID, time.Now()); err != nil {
+				log.Printf("error detecting recurring transactions for user %s: %v\n", userID.Hex(), err)
+			}
+		}
+
+		time.Sleep(time.Duration(t.RecurringInterval) * time.Second)
+	}
+}
+
+// Creates the transactions of scheduled templates, any missed while the app was down are caught up
+func (t *Jobs) materializeTemplatesTask(ctx context.Context) {
+	for {
+		created, err := finances.MaterializeTemplates(ctx, t.Db, time.Now())
+		if err != nil {
+			log.Printf("error creating scheduled transactions: %v\n", err)
+		}
+		if created > 0 {
+			log.Printf("created %d scheduled transactions\n", created)
+		}
+
+		time.Sleep(time.Duration(t.TemplatesInterval) * time.Second)
+	}
+}
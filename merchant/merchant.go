@@ -0,0 +1,87 @@
+package merchant
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// payment processors put their own prefix before the merchant, e.g. "SQ *BLUE BOTTLE"
+	processorPrefix = regexp.MustCompile(`^(SQ|TST|SP|PY|PP|PAYPAL|GOOGLE|APL|APPLE|IN|WPY|DD|BT|CKE|FSP|PAR|SMP|LEVELUP|EB)\s?\*\s*`)
+
+	// card network and bank wording in front of the merchant
+	purchasePrefix = regexp.MustCompile(`^(POS|DEBIT|DEBIT CARD|CHECKCARD|CHECK CARD|PURCHASE|RECURRING|ACH)( PURCHASE| DEBIT| PAYMENT)?(\s+\d{2}/\d{2})?\s+`)
+
+	// store numbers and references, everything from the first one on is dropped
+	storeNumber = regexp.MustCompile(`(^|\s)(#\S*|\S*\d{3,}\S*)`)
+
+	states = map[string]bool{
+		"AL": true, "AK": true, "AZ": true, "AR": true, "CA": true, "CO": true, "CT": true, "DE": true,
+		"DC": true, "FL": true, "GA": true, "HI": true, "ID": true, "IL": true, "IN": true, "IA": true,
+		"KS": true, "KY": true, "LA": true, "ME": true, "MD": true, "MA": true, "MI": true, "MN": true,
+		"MS": true, "MO": true, "MT": true, "NE": true, "NV": true, "NH": true, "NJ": true, "NM": true,
+		"NY": true, "NC": true, "ND": true, "OH": true, "OK": true, "OR": true, "PA": true, "RI": true,
+		"SC": true, "SD": true, "TN": true, "TX": true, "UT": true, "VT": true, "VA": true, "WA": true,
+		"WV": true, "WI": true, "WY": true,
+	}
+
+	// words that start multi-word city names, e.g. "SAN FRANCISCO" or "SALT LAKE CITY"
+	cityPrefixes = map[string]bool{
+		"SAN": true, "SANTA": true, "LOS": true, "LAS": true, "LA": true, "EL": true, "DEL": true,
+		"NEW": true, "ST": true, "SAINT": true, "FT": true, "FORT": true, "PORT": true, "MT": true,
+		"MOUNT": true, "NORTH": true, "SOUTH": true, "EAST": true, "WEST": true, "SALT": true,
+		"LAKE": true, "PALO": true, "PALM": true, "LONG": true, "GRAND": true, "BATON": true,
+		"CORPUS": true, "COLORADO": true, "KANSAS": true, "OKLAHOMA": true, "JERSEY": true,
+		"VIRGINIA": true, "SIOUX": true, "CEDAR": true, "DES": true, "ANN": true, "BEVERLY": true,
+		"REDWOOD": true, "DALY": true, "MENLO": true, "CULVER": true, "HOT": true, "CAPE": true,
+	}
+)
+
+// Returns the merchant behind a raw bank descriptor, e.g. "SQ *BLUE BOTTLE 0423 OAKLAND CA"
+// becomes "Blue Bottle". Processor prefixes, store numbers and trailing locations are removed.
+func Normalize(descriptor string) string {
+	name := strings.ToUpper(strings.Join(strings.Fields(descriptor), " "))
+	name = purchasePrefix.ReplaceAllString(name, "")
+	name = processorPrefix.ReplaceAllString(name, "")
+
+	// the rest of a descriptor like "AMAZON.COM*2K4R81" is an order reference
+	if i := strings.Index(name, "*"); i > 0 {
+		name = name[:i]
+	}
+	if loc := storeNumber.FindStringIndex(name); loc != nil && loc[0] > 0 {
+		name = name[:loc[0]]
+	}
+
+	// a trailing state code follows the city, whose name can be more than one word
+	words := strings.Fields(name)
+	if len(words) >= 3 && states[words[len(words)-1]] {
+		words = words[:len(words)-2]
+		for len(words) > 1 && cityPrefixes[words[len(words)-1]] {
+			words = words[:len(words)-1]
+		}
+	}
+	name = strings.Trim(strings.Join(words, " "), " -*#.,:")
+	if name == "" {
+		return Title(strings.Join(strings.Fields(descriptor), " "))
+	}
+	return Title(name)
+}
+
+// Returns the lookup key of a merchant name
+func Key(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// Capitalizes the first letter of each word and lowercases the rest
+func Title(name string) string {
+	runes := []rune(strings.ToLower(name))
+	start := true
+	for i, r := range runes {
+		if start && unicode.IsLetter(r) {
+			runes[i] = unicode.ToUpper(r)
+		}
+		start = unicode.IsSpace(r) || r == '-' || r == '/'
+	}
+	return string(runes)
+}
@@ -0,0 +1,118 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Currency used for manually created transactions and accounts without one
+const DefaultCurrency = "USD"
+
+// Amount is an exact quantity of money stored as integer minor units (hundredths of the
+// currency unit). It is saved to mongo as an int64 and sent over JSON as a decimal number.
+// Currencies with three decimal places such as KWD can't be held exactly, so amounts with
+// more than two decimal places are rejected rather than rounded.
+type Amount int64
+
+const scale = 100
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// Parses a decimal string such as "-1234.56" without going through floating point.
+// Digits past the second decimal place must be zeros.
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+
+	whole, fraction := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		whole, fraction = s[:i], s[i+1:]
+	}
+	if whole == "" && fraction == "" {
+		return 0, ErrInvalidAmount
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	for _, r := range whole + fraction {
+		if r < '0' || r > '9' {
+			return 0, ErrInvalidAmount
+		}
+	}
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || units > math.MaxInt64/scale-1 {
+		return 0, ErrInvalidAmount
+	}
+
+	if len(fraction) > 2 {
+		if strings.Trim(fraction[2:], "0") != "" {
+			return 0, ErrInvalidAmount
+		}
+		fraction = fraction[:2]
+	}
+	cents, _ := strconv.ParseInt((fraction + "00")[:2], 10, 64)
+
+	amount := units*scale + cents
+	if negative {
+		amount = -amount
+	}
+	return Amount(amount), nil
+}
+
+// Converts a float to the nearest minor unit, only meant for legacy values and rates
+func FromFloat(f float64) Amount {
+	return Amount(math.Round(f * scale))
+}
+
+func (a Amount) Float() float64 {
+	return float64(a) / scale
+}
+
+func (a Amount) Abs() Amount {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// Returns the amount as a decimal string with two decimal places, e.g. "-12.30"
+func (a Amount) String() string {
+	sign := ""
+	abs := int64(a)
+	if abs < 0 {
+		sign = "-"
+		abs = -abs
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, abs/scale, abs%scale)
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// Accepts both JSON numbers and strings
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "null" || s == "" {
+		*a = 0
+		return nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && strings.ContainsAny(s, "eE") {
+		// numbers in exponent form
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
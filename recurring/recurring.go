@@ -0,0 +1,137 @@
+package recurring
+
+import (
+	"sort"
+	"time"
+
+	"github.com/tony-tvu/goexpense/money"
+)
+
+// How often a series repeats
+const (
+	Weekly  = "weekly"
+	Monthly = "monthly"
+	Annual  = "annual"
+)
+
+// Charges in a series may differ from the smallest by this fraction, enough for taxes and small price changes
+const amountTolerance = 0.3
+
+// Series with more missed charges than this are treated as ended
+const maxMissed = 2
+
+type cadence struct {
+	name string
+
+	// range of days between charges and how late a charge can be before it counts as missed
+	minDays, maxDays, graceDays int
+
+	minCharges int
+	next       func(time.Time) time.Time
+}
+
+var cadences = []cadence{
+	{name: Weekly, minDays: 5, maxDays: 9, graceDays: 3, minCharges: 3, next: func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }},
+	{name: Monthly, minDays: 26, maxDays: 35, graceDays: 7, minCharges: 3, next: func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+	{name: Annual, minDays: 350, maxDays: 380, graceDays: 14, minCharges: 2, next: func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+}
+
+// Charge is one transaction from a merchant
+type Charge struct {
+	TransactionID string
+	Date          time.Time
+	Amount        money.Amount
+}
+
+// Series is a run of similar charges at a regular interval
+type Series struct {
+	Cadence        string
+	Charges        []*Charge
+	AverageAmount  money.Amount
+	LastAmount     money.Amount
+	PreviousAmount money.Amount
+	LastDate       time.Time
+	NextDate       time.Time
+
+	// the latest charge cost more than the one before it
+	PriceIncrease bool
+
+	// expected charges that are overdue as of the time of detection
+	MissedCharges int
+	Active        bool
+}
+
+// Finds recurring series among the charges of a single merchant. Charges are grouped by
+// similar amounts first so one merchant can have more than one series.
+func Detect(charges []*Charge, now time.Time) []*Series {
+	series := []*Series{}
+	for _, group := range groupByAmount(charges) {
+		if s := detectSeries(group, now); s != nil {
+			series = append(series, s)
+		}
+	}
+	return series
+}
+
+func groupByAmount(charges []*Charge) [][]*Charge {
+	sorted := append([]*Charge{}, charges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Amount.Abs() < sorted[j].Amount.Abs() })
+
+	groups := [][]*Charge{}
+	var smallest money.Amount
+	for _, charge := range sorted {
+		if len(groups) == 0 || float64(charge.Amount.Abs()) > float64(smallest)*(1+amountTolerance) {
+			groups = append(groups, []*Charge{})
+			smallest = charge.Amount.Abs()
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], charge)
+	}
+	return groups
+}
+
+func detectSeries(charges []*Charge, now time.Time) *Series {
+	sort.Slice(charges, func(i, j int) bool { return charges[i].Date.Before(charges[j].Date) })
+
+	for _, c := range cadences {
+		if len(charges) < c.minCharges {
+			continue
+		}
+		// most intervals have to fit, allowing for the odd early or late charge
+		regular := 0
+		for i := 1; i < len(charges); i++ {
+			days := int(charges[i].Date.Sub(charges[i-1].Date).Hours() / 24)
+			if days >= c.minDays && days <= c.maxDays {
+				regular++
+			}
+		}
+		if float64(regular) < 0.75*float64(len(charges)-1) {
+			continue
+		}
+
+		var total money.Amount
+		for _, charge := range charges {
+			total += charge.Amount
+		}
+		last := charges[len(charges)-1]
+		previous := charges[len(charges)-2]
+		s := &Series{
+			Cadence:        c.name,
+			Charges:        charges,
+			AverageAmount:  total / money.Amount(len(charges)),
+			LastAmount:     last.Amount,
+			PreviousAmount: previous.Amount,
+			LastDate:       last.Date,
+			NextDate:       c.next(last.Date),
+			PriceIncrease:  last.Amount.Abs() > previous.Amount.Abs(),
+		}
+		for expected := s.NextDate; now.After(expected.AddDate(0, 0, c.graceDays)); expected = c.next(expected) {
+			s.MissedCharges++
+			if s.MissedCharges > maxMissed {
+				break
+			}
+		}
+		s.Active = s.MissedCharges <= maxMissed
+		return s
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package rules
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/util"
+)
+
+// How a rule combines its conditions
+const (
+	MatchAll = "all"
+	MatchAny = "any"
+)
+
+// Condition fields
+const (
+	FieldName         = "name"
+	FieldAmount       = "amount"
+	FieldAccountID    = "account_id"
+	FieldEnrollmentID = "enrollment_id"
+	FieldInstitution  = "institution"
+	FieldDay          = "day"
+	FieldSign         = "sign"
+)
+
+// Condition operators. Text matches are case-insensitive, amounts are compared by absolute value.
+const (
+	OpContains = "contains"
+	OpEquals   = "equals"
+	OpRegex    = "regex"
+	OpEq       = "eq"
+	OpGt       = "gt"
+	OpGte      = "gte"
+	OpLt       = "lt"
+	OpLte      = "lte"
+	OpIs       = "is"
+)
+
+// Values of the sign condition
+const (
+	Positive = "positive"
+	Negative = "negative"
+)
+
+// Action types
+const (
+	SetCategory = "set_category"
+	AddTag      = "add_tag"
+	Rename      = "rename"
+	MarkIgnore  = "ignore"
+)
+
+var (
+	textFields     = []string{FieldName, FieldAccountID, FieldEnrollmentID, FieldInstitution}
+	textOps        = []string{OpContains, OpEquals, OpRegex}
+	numberOps      = []string{OpEq, OpGt, OpGte, OpLt, OpLte}
+	actionTypes    = []string{SetCategory, AddTag, Rename, MarkIgnore}
+	ErrInvalidRule = errors.New("invalid rule")
+)
+
+type Condition struct {
+	Field string `json:"field" bson:"field" validate:"required"`
+	Op    string `json:"op" bson:"op" validate:"required"`
+	Value string `json:"value" bson:"value"`
+
+	re *regexp.Regexp
+}
+
+type Action struct {
+	Type  string `json:"type" bson:"type" validate:"required"`
+	Value string `json:"value" bson:"value"`
+}
+
+// Rule is a set of conditions and the actions taken on transactions that meet them
+type Rule struct {
+	Match      string       `json:"match" bson:"match"`
+	Conditions []*Condition `json:"conditions" bson:"conditions" validate:"dive"`
+	Actions    []*Action    `json:"actions" bson:"actions" validate:"dive"`
+
+	// no rules after this one are evaluated when it matches
+	StopProcessing bool `json:"stop_processing" bson:"stop_processing"`
+}
+
+// Subject is the transaction a rule is evaluated against
+type Subject struct {
+	Name         string
+	Amount       money.Amount
+	AccountID    string
+	EnrollmentID string
+	Institution  string
+	Date         time.Time
+}
+
+// Outcome is the combined result of every matching rule
+type Outcome struct {
+	Category string
+	Name     string
+	Tags     []string
+	Ignore   bool
+
+	// indexes of the rules that matched, in order
+	Matched []int
+
+	// index of the rule that set the category or ignore, -1 if none did
+	CategorizedBy int
+}
+
+// Checks conditions and actions are well formed and compiles regular expressions
+func (r *Rule) Validate() error {
+	if r.Match == "" {
+		r.Match = MatchAll
+	}
+	if r.Match != MatchAll && r.Match != MatchAny {
+		return ErrInvalidRule
+	}
+	if len(r.Conditions) == 0 || len(r.Actions) == 0 {
+		return ErrInvalidRule
+	}
+	for _, condition := range r.Conditions {
+		if err := condition.compile(); err != nil {
+			return err
+		}
+	}
+	for _, action := range r.Actions {
+		if !util.Contains(&actionTypes, action.Type) {
+			return ErrInvalidRule
+		}
+		if action.Type != MarkIgnore && strings.TrimSpace(action.Value) == "" {
+			return ErrInvalidRule
+		}
+	}
+	return nil
+}
+
+func (c *Condition) compile() error {
+	switch {
+	case util.Contains(&textFields, c.Field):
+		if !util.Contains(&textOps, c.Op) || c.Value == "" {
+			return ErrInvalidRule
+		}
+		if c.Op == OpRegex {
+			re, err := regexp.Compile("(?i)" + c.Value)
+			if err != nil {
+				return err
+			}
+			c.re = re
+		}
+	case c.Field == FieldAmount:
+		if _, err := money.Parse(c.Value); err != nil || !util.Contains(&numberOps, c.Op) {
+			return ErrInvalidRule
+		}
+	case c.Field == FieldDay:
+		day, err := strconv.Atoi(c.Value)
+		if err != nil || day < 1 || day > 31 || !util.Contains(&numberOps, c.Op) {
+			return ErrInvalidRule
+		}
+	case c.Field == FieldSign:
+		if c.Op != OpIs || (c.Value != Positive && c.Value != Negative) {
+			return ErrInvalidRule
+		}
+	default:
+		return ErrInvalidRule
+	}
+	return nil
+}
+
+func compareText(op, value, target string, re *regexp.Regexp) bool {
+	switch op {
+	case OpContains:
+		return strings.Contains(strings.ToLower(value), strings.ToLower(target))
+	case OpEquals:
+		return strings.EqualFold(value, target)
+	case OpRegex:
+		return re != nil && re.MatchString(value)
+	}
+	return false
+}
+
+func compareNumber(op string, value, target int64) bool {
+	switch op {
+	case OpEq:
+		return value == target
+	case OpGt:
+		return value > target
+	case OpGte:
+		return value >= target
+	case OpLt:
+		return value < target
+	case OpLte:
+		return value <= target
+	}
+	return false
+}
+
+func (c *Condition) matches(s *Subject) bool {
+	if c.Op == OpRegex && c.re == nil {
+		if err := c.compile(); err != nil {
+			return false
+		}
+	}
+
+	switch c.Field {
+	case FieldName:
+		return compareText(c.Op, s.Name, c.Value, c.re)
+	case FieldAccountID:
+		return compareText(c.Op, s.AccountID, c.Value, c.re)
+	case FieldEnrollmentID:
+		return compareText(c.Op, s.EnrollmentID, c.Value, c.re)
+	case FieldInstitution:
+		return compareText(c.Op, s.Institution, c.Value, c.re)
+	case FieldAmount:
+		target, err := money.Parse(c.Value)
+		if err != nil {
+			return false
+		}
+		return compareNumber(c.Op, int64(s.Amount.Abs()), int64(target.Abs()))
+	case FieldDay:
+		target, err := strconv.Atoi(c.Value)
+		if err != nil {
+			return false
+		}
+		return compareNumber(c.Op, int64(s.Date.Day()), int64(target))
+	case FieldSign:
+		if c.Value == Positive {
+			return s.Amount > 0
+		}
+		return s.Amount < 0
+	}
+	return false
+}
+
+// Returns true if the subject meets all or any of the rule's conditions
+func (r *Rule) Matches(s *Subject) bool {
+	if len(r.Conditions) == 0 {
+		return false
+	}
+	for _, condition := range r.Conditions {
+		matched := condition.matches(s)
+		if r.Match == MatchAny && matched {
+			return true
+		}
+		if r.Match != MatchAny && !matched {
+			return false
+		}
+	}
+	return r.Match != MatchAny
+}
+
+// Evaluates rules in priority order against the subject. The first matching rule to set a
+// category or name wins while tags from every matching rule are kept. Conditions always see the
+// original transaction, so a rename by one rule doesn't change what later rules match.
+func Apply(rules []*Rule, s *Subject) *Outcome {
+	outcome := &Outcome{Tags: []string{}, CategorizedBy: -1}
+	for i, rule := range rules {
+		if !rule.Matches(s) {
+			continue
+		}
+		outcome.Matched = append(outcome.Matched, i)
+		categorized := outcome.Category != "" || outcome.Ignore
+		for _, action := range rule.Actions {
+			switch action.Type {
+			case SetCategory:
+				if !categorized {
+					outcome.Category = action.Value
+					outcome.CategorizedBy = i
+				}
+			case AddTag:
+				outcome.Tags = append(outcome.Tags, action.Value)
+			case Rename:
+				if outcome.Name == "" {
+					outcome.Name = action.Value
+				}
+			case MarkIgnore:
+				if !categorized {
+					outcome.Ignore = true
+					outcome.CategorizedBy = i
+				}
+			}
+		}
+		if rule.StopProcessing {
+			break
+		}
+	}
+	return outcome
+}
@@ -6,12 +6,13 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/tony-tvu/goexpense/db"
 	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/merchant"
+	"github.com/tony-tvu/goexpense/money"
 	"github.com/tony-tvu/goexpense/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -25,6 +26,10 @@ type TellerClient struct {
 
 var BASE_URL = "https://api.teller.io"
 
+// Days back from a sync that new transactions are linked to transfers and refunds. Teller
+// resends full history, but only recently posted transactions are new.
+const syncLookbackDays = 30
+
 type TellerAccountRes struct {
 	AccountID   string `json:"id"`
 	Type        string `json:"type"`
@@ -83,7 +88,7 @@ func (t *TellerClient) FetchAccounts(accessToken *string) (*[]TellerAccountRes,
 }
 
 // Fetch account balance for a given account_id from teller api
-func (t *TellerClient) FetchBalance(account *finances.Account) (*float64, error) {
+func (t *TellerClient) FetchBalance(account *finances.Account) (*money.Amount, error) {
 	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/accounts/%s/balances", BASE_URL, account.AccountID), nil)
 	req.SetBasicAuth(account.AccessToken, "")
 	res, err := t.Client.Do(req)
@@ -100,7 +105,7 @@ func (t *TellerClient) FetchBalance(account *finances.Account) (*float64, error)
 	} else {
 		balanceStr = tellerBalance.Available
 	}
-	balance, err := strconv.ParseFloat(balanceStr, 64)
+	balance, err := money.Parse(balanceStr)
 	if err != nil {
 		return nil, err
 	}
@@ -151,7 +156,7 @@ func (t *TellerClient) PopulateAccounts(userID *primitive.ObjectID, accessToken,
 				{Key: "status", Value: account.Status},
 				{Key: "name", Value: account.Name},
 				{Key: "institution", Value: account.Institution.Name},
-				{Key: "balance", Value: 0},
+				{Key: "balance", Value: money.Amount(0)},
 				{Key: "currency", Value: account.Currency},
 				{Key: "last_four", Value: account.LastFour},
 				{Key: "created_at", Value: time.Now()},
@@ -252,12 +257,29 @@ func (t *TellerClient) RefreshTransactions(userID *primitive.ObjectID, accessTok
 		log.Printf("error finding accounts for access_token %s: %v", *accessToken, err)
 	}
 
-	var rules []*finances.Rule
-	cursor, _ = t.Db.Rules.Find(ctx, bson.M{"user_id": userID})
-	if err := cursor.All(ctx, &rules); err != nil {
+	ruleset, err := finances.GetUserRules(ctx, t.Db, userID)
+	if err != nil {
 		log.Printf("error finding rules for access_token %s: %v", *accessToken, err)
 	}
 
+	categories, err := finances.GetUserCategories(ctx, t.Db, userID)
+	if err != nil {
+		log.Printf("error finding categories for access_token %s: %v", *accessToken, err)
+		return
+	}
+
+	merchants, err := finances.GetUserMerchants(ctx, t.Db, userID)
+	if err != nil {
+		log.Printf("error finding merchants for access_token %s: %v", *accessToken, err)
+	}
+
+	model, err := finances.LoadClassifier(ctx, t.Db, userID)
+	if err != nil {
+		log.Printf("error loading classifier for access_token %s: %v", *accessToken, err)
+	}
+
+	categorizer := &finances.Categorizer{Categories: categories, Rules: ruleset, Merchants: merchants, Model: model}
+
 	retryLimit := 3
 	count := 0
 
@@ -281,7 +303,7 @@ func (t *TellerClient) RefreshTransactions(userID *primitive.ObjectID, accessTok
 				if t.Status != "posted" {
 					continue
 				}
-				amount, err := strconv.ParseFloat(t.Amount, 32)
+				amount, err := money.Parse(t.Amount)
 				if err != nil {
 					log.Printf("error parsing amount for transaction %v: %v", t, err)
 					success = false
@@ -296,46 +318,44 @@ func (t *TellerClient) RefreshTransactions(userID *primitive.ObjectID, accessTok
 					success = false
 				}
 
-				category := "uncategorized"
-				if util.Contains(&finances.Categories, t.Details.Category) {
-					category = t.Details.Category
+				// teller's counterparty is already a clean merchant name when it has one
+				transaction := &finances.Transaction{
+					Name:         t.Description,
+					Amount:       amount,
+					Date:         date,
+					AccountID:    account.AccountID,
+					EnrollmentID: account.EnrollmentID,
 				}
-				if category != "income" && category != "ignore" && amount > 0 {
-					category = "income"
-				}
-
-				// apply rules
-				name := util.RemoveDuplicateWhitespace(t.Description) 
-				for _, rule := range rules {
-					if strings.Contains(name, rule.Substring) {
-
-						// make transaction amount positive if category to changed to 'income'
-						if rule.Category == "income" && amount < 0 {
-							amount = -1 * amount
-						}
-						// make transaction amount negative if category is not 'income'/'ignore'
-						if rule.Category != "income" && rule.Category != "ignore" && amount > 0 {
-							amount = -1 * amount
-						}
-
-						category = rule.Category
-					}
+				if t.Details.Counterparty.Name != "" {
+					transaction.Merchant = merchant.Title(t.Details.Counterparty.Name)
 				}
+				categorizer.Categorize(transaction, t.Details.Category, account.Institution)
 
 				doc := bson.D{
 					{Key: "transaction_id", Value: t.TransactionID},
 					{Key: "enrollment_id", Value: account.EnrollmentID},
-					{Key: "name", Value: name},
-					{Key: "category", Value: category},
-					{Key: "amount", Value: amount},
+					{Key: "name", Value: transaction.Name},
+					{Key: "category", Value: transaction.Category},
+					{Key: "original_category", Value: transaction.OriginalCategory},
+					{Key: "original_name", Value: transaction.OriginalName},
+					{Key: "merchant", Value: transaction.Merchant},
+					{Key: "rule_id", Value: transaction.RuleID},
+					{Key: "amount", Value: transaction.Amount},
+					{Key: "currency", Value: account.Currency},
+					{Key: "tags", Value: transaction.Tags},
 					{Key: "date", Value: date},
 					{Key: "user_id", Value: account.UserID},
 					{Key: "account_id", Value: account.AccountID},
 					{Key: "created_at", Value: time.Now()},
 					{Key: "updated_at", Value: time.Now()},
 				}
+				if transaction.Confidence > 0 {
+					doc = append(doc, bson.E{Key: "confidence", Value: transaction.Confidence})
+				}
 				docs = append(docs, doc)
 			}
+			// transactions already saved are skipped as duplicates, so fields the user
+			// edited and locked are never overwritten by a sync
 			_, err = t.Db.Transactions.InsertMany(ctx, docs, &options.InsertManyOptions{
 				Ordered: util.BoolPointer(false),
 			})
@@ -365,6 +385,19 @@ func (t *TellerClient) RefreshTransactions(userID *primitive.ObjectID, accessTok
 			time.Sleep(30 * time.Second)
 		}
 	}
+
+	// refunds and reimbursements are linked first so they aren't taken for one side of a payment
+	// between the user's own accounts
+	since := time.Now().AddDate(0, 0, -syncLookbackDays)
+	if _, err := finances.DetectRefunds(ctx, t.Db, userID, since); err != nil {
+		log.Printf("error detecting refunds for access_token %s: %v", *accessToken, err)
+	}
+	if _, err := finances.ReconcileReimbursements(ctx, t.Db, userID, since); err != nil {
+		log.Printf("error reconciling reimbursements for access_token %s: %v", *accessToken, err)
+	}
+	if _, err := finances.DetectTransfers(ctx, t.Db, userID, since); err != nil {
+		log.Printf("error detecting transfers for access_token %s: %v", *accessToken, err)
+	}
 }
 
 func (t *TellerClient) DeleteAccount(accessToken, accountID *string) error {
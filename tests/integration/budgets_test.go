@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Budget progress counts expenses against budgets and skips income
+func TestBudgetProgress(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	now := time.Now()
+	body := map[string]interface{}{"category": "groceries", "amount": "300", "recurring": true}
+	res := makeRequest(t, "POST", "/api/budgets", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// same recurring budget twice is rejected
+	res = makeRequest(t, "POST", "/api/budgets", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// income categories can't be budgeted
+	body = map[string]interface{}{"category": "income", "amount": "300", "recurring": true}
+	res = makeRequest(t, "POST", "/api/budgets", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	for _, tr := range []map[string]string{
+		{"name": "market", "category": "groceries", "amount": "120"},
+		{"name": "paycheck", "category": "income", "amount": "1000"},
+	} {
+		tr["date"] = now.Format(time.RFC1123)
+		res = makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, tr)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	url := fmt.Sprintf("/api/budgets/progress?month=%d&year=%d", int(now.Month()), now.Year())
+	res = makeRequest(t, "GET", url, &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var data struct {
+		Progress []struct {
+			Category  string  `json:"category"`
+			Budgeted  float32 `json:"budgeted"`
+			Spent     float32 `json:"spent"`
+			Remaining float32 `json:"remaining"`
+		} `json:"progress"`
+	}
+	json.NewDecoder(res.Body).Decode(&data)
+	assert.Equal(t, 1, len(data.Progress))
+	assert.Equal(t, float32(300), data.Progress[0].Budgeted)
+	assert.Equal(t, float32(120), data.Progress[0].Spent)
+	assert.Equal(t, float32(180), data.Progress[0].Remaining)
+}
+
+// Unspent rollover budgets carry into the next month and persist until recomputed
+func TestBudgetRollover(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	now := time.Now()
+	lastMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+	for _, date := range []time.Time{lastMonth, now} {
+		body := map[string]interface{}{
+			"category": "restaurant",
+			"amount":   "100",
+			"month":    int(date.Month()),
+			"year":     date.Year(),
+			"rollover": true,
+		}
+		res := makeRequest(t, "POST", "/api/budgets", &accessToken, &refreshToken, body)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	body := map[string]string{
+		"date":     lastMonth.Format(time.RFC1123),
+		"name":     "diner",
+		"category": "restaurant",
+		"amount":   "40",
+	}
+	res := makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	type progress struct {
+		Progress []struct {
+			CarriedIn float32 `json:"carried_in"`
+			Remaining float32 `json:"remaining"`
+		} `json:"progress"`
+	}
+	url := fmt.Sprintf("/api/budgets/progress?month=%d&year=%d", int(now.Month()), now.Year())
+	res = makeRequest(t, "GET", url, &accessToken, &refreshToken)
+	var data progress
+	json.NewDecoder(res.Body).Decode(&data)
+	assert.Equal(t, 1, len(data.Progress))
+	assert.Equal(t, float32(60), data.Progress[0].CarriedIn)
+	assert.Equal(t, float32(160), data.Progress[0].Remaining)
+
+	// editing last month doesn't shift the closed envelope
+	body["name"] = "second diner"
+	res = makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res = makeRequest(t, "GET", url, &accessToken, &refreshToken)
+	json.NewDecoder(res.Body).Decode(&data)
+	assert.Equal(t, float32(60), data.Progress[0].CarriedIn)
+
+	// until it's recomputed
+	recompute := map[string]interface{}{"month": int(lastMonth.Month()), "year": lastMonth.Year()}
+	res = makeRequest(t, "POST", "/api/budgets/envelopes/recompute", &accessToken, &refreshToken, recompute)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res = makeRequest(t, "GET", url, &accessToken, &refreshToken)
+	json.NewDecoder(res.Body).Decode(&data)
+	assert.Equal(t, float32(20), data.Progress[0].CarriedIn)
+}
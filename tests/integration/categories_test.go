@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Users start with default categories and can add their own
+func TestCreateCategory(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	body := map[string]string{"name": "Pets", "color": "#000000", "icon": "paw", "kind": finances.Expense}
+	res := makeRequest(t, "POST", "/api/categories", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// duplicate names are rejected
+	res = makeRequest(t, "POST", "/api/categories", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// unknown kinds are rejected
+	body = map[string]string{"name": "childcare", "kind": "other"}
+	res = makeRequest(t, "POST", "/api/categories", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// parents are matched like names
+	body = map[string]string{"name": "vet", "parent": " PETS ", "kind": finances.Expense}
+	res = makeRequest(t, "POST", "/api/categories", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// children share their parent's kind
+	body = map[string]string{"name": "pet sitting", "parent": "pets", "kind": finances.Income}
+	res = makeRequest(t, "POST", "/api/categories", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = makeRequest(t, "GET", "/api/categories", &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var data struct {
+		Categories []*finances.Category `json:"categories"`
+	}
+	json.NewDecoder(res.Body).Decode(&data)
+	assert.Equal(t, len(finances.DefaultCategories)+2, len(data.Categories))
+	for _, category := range data.Categories {
+		if category.Name == "vet" {
+			assert.Equal(t, "pets", category.Parent)
+		}
+	}
+
+	// transactions can use the new category
+	body = map[string]string{
+		"date":     time.Now().Format(time.RFC1123),
+		"name":     "vet visit",
+		"category": "pets",
+		"amount":   "50",
+	}
+	res = makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+// Renaming and deleting categories re-maps transactions and rules
+func TestEditAndDeleteCategory(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	body := map[string]string{"name": "health", "kind": finances.Expense}
+	res := makeRequest(t, "POST", "/api/categories", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	body = map[string]string{
+		"date":     time.Now().Format(time.RFC1123),
+		"name":     "pharmacy",
+		"category": "health",
+		"amount":   "20",
+	}
+	res = makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	body = map[string]string{"substring": "pharmacy", "category": "health"}
+	res = makeRequest(t, "POST", "/api/rules", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var category *finances.Category
+	if err := testApp.Db.Categories.FindOne(ctx, bson.M{"user_id": testUser.ID, "name": "health"}).Decode(&category); err != nil {
+		t.FailNow()
+	}
+
+	// rename category
+	body = map[string]string{"id": category.ID.Hex(), "name": "medical", "kind": finances.Expense}
+	res = makeRequest(t, "PATCH", "/api/categories", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// should have transactions and rules moved to the new name
+	count, _ := testApp.Db.Transactions.CountDocuments(ctx, bson.M{"user_id": testUser.ID, "category": "medical"})
+	assert.Equal(t, int64(1), count)
+	count, _ = testApp.Db.Rules.CountDocuments(ctx, bson.M{"user_id": testUser.ID, "actions.value": "medical"})
+	assert.Equal(t, int64(1), count)
+
+	// delete category
+	res = makeRequest(t, "DELETE", "/api/categories/"+category.ID.Hex(), &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// should have transactions and rules moved to uncategorized
+	count, _ = testApp.Db.Transactions.CountDocuments(ctx, bson.M{"user_id": testUser.ID, "category": finances.Uncategorized})
+	assert.Equal(t, int64(1), count)
+	count, _ = testApp.Db.Rules.CountDocuments(ctx, bson.M{"user_id": testUser.ID, "actions.value": finances.Uncategorized})
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/money"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Retraining learns from the user's categorized transactions
+func TestRetrainClassifier(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	date := time.Date(2022, time.June, 3, 0, 0, 0, 0, time.UTC).Format(time.RFC1123)
+	for _, tr := range []map[string]interface{}{
+		{"name": "Starbucks", "category": "restaurant", "amount": "5"},
+		{"name": "Shell Gas", "category": "transportation", "amount": "45"},
+		{"name": "Mystery Charge", "category": "uncategorized", "amount": "12"},
+	} {
+		tr["date"] = date
+		res := makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, tr)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	// transactions the classifier categorized aren't learned from
+	_, err := testApp.Db.Transactions.InsertOne(ctx, bson.M{
+		"transaction_id": uuid.New().String(),
+		"user_id":        testUser.ID,
+		"name":           "Starbucks Reserve",
+		"category":       "restaurant",
+		"amount":         money.Amount(-700),
+		"date":           time.Date(2022, time.June, 4, 0, 0, 0, 0, time.UTC),
+		"confidence":     0.9,
+	})
+	assert.Nil(t, err)
+
+	res := makeRequest(t, "POST", "/api/classifier/retrain", &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var data struct {
+		Documents  int `json:"documents"`
+		Categories int `json:"categories"`
+	}
+	json.NewDecoder(res.Body).Decode(&data)
+	assert.Equal(t, 2, data.Documents)
+	assert.Equal(t, 2, data.Categories)
+}
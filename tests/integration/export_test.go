@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/exporter"
+	"github.com/tony-tvu/goexpense/importer"
+	"github.com/tony-tvu/goexpense/money"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Exports use the transactions list filters and include each transaction's account
+func TestExport(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	accountID := "checking-" + testUser.Username
+	_, err := testApp.Db.Accounts.InsertOne(ctx, bson.M{
+		"user_id":      testUser.ID,
+		"account_id":   accountID,
+		"name":         "Everyday Checking",
+		"institution":  "Chase",
+		"account_type": "depository",
+		"balance":      money.Amount(250000),
+		"currency":     money.DefaultCurrency,
+	})
+	assert.Nil(t, err)
+
+	day := time.Date(2022, time.November, 7, 0, 0, 0, 0, time.UTC)
+	for i, tr := range []struct {
+		name     string
+		category string
+		amount   money.Amount
+		tags     []string
+	}{
+		{"WHOLE FOODS", "groceries", -6400, []string{"food"}},
+		{"NETFLIX", "entertainment", -1599, nil},
+		{"PAYROLL", "income", 300000, nil},
+	} {
+		_, err := testApp.Db.Transactions.InsertOne(ctx, bson.M{
+			"transaction_id": uuid.New().String(),
+			"user_id":        testUser.ID,
+			"account_id":     accountID,
+			"name":           tr.name,
+			"category":       tr.category,
+			"amount":         tr.amount,
+			"currency":       money.DefaultCurrency,
+			"tags":           tr.tags,
+			"date":           day.AddDate(0, 0, i),
+		})
+		assert.Nil(t, err)
+	}
+
+	export := func(query string) (*http.Response, string) {
+		res := makeRequest(t, "GET", "/api/export?"+query, &accessToken, &refreshToken)
+		body, _ := io.ReadAll(res.Body)
+		return res, string(body)
+	}
+
+	res, body := export("format=csv&category=groceries&category=entertainment&order=asc")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/csv", res.Header.Get("Content-Type"))
+	lines, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	assert.Nil(t, err)
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "WHOLE FOODS", lines[1][1])
+	assert.Equal(t, "food", lines[1][6])
+	assert.Equal(t, "Everyday Checking", lines[1][8])
+	assert.Equal(t, "Chase", lines[1][9])
+
+	res, body = export("format=json&min_amount=0")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var records []*exporter.Record
+	assert.Nil(t, json.Unmarshal([]byte(body), &records))
+	assert.Len(t, records, 1)
+	assert.Equal(t, "PAYROLL", records[0].Name)
+
+	res, body = export("format=ofx")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	statements, err := importer.ParseOFX(strings.NewReader(body))
+	assert.Nil(t, err)
+	assert.Len(t, statements, 1)
+	assert.Len(t, statements[0].Transactions, 3)
+	assert.Equal(t, money.Amount(250000), *statements[0].Balance)
+
+	// past months don't carry today's balance
+	res, body = export("format=ofx&month=11&year=2022")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	statements, err = importer.ParseOFX(strings.NewReader(body))
+	assert.Nil(t, err)
+	assert.Len(t, statements, 1)
+	assert.Nil(t, statements[0].Balance)
+
+	res, body = export("format=qif")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, body, "NEveryday Checking\n")
+	assert.Equal(t, 3, strings.Count(body, "\nP"))
+
+	res, _ = export("format=xls")
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res, _ = export("format=csv&sort=nope")
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
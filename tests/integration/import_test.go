@@ -0,0 +1,185 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/importer"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/rules"
+)
+
+// Creates a manual account to import statements into and returns its account_id
+func createManualAccount(t *testing.T, accessToken, refreshToken *string) string {
+	t.Helper()
+	res := makeRequest(t, "POST", "/api/accounts", accessToken, refreshToken, map[string]interface{}{
+		"name":        "Old Checking",
+		"institution": "Local Credit Union",
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var data struct {
+		Account *finances.Account `json:"account"`
+	}
+	json.NewDecoder(res.Body).Decode(&data)
+	assert.Equal(t, finances.ManualEnrollment, data.Account.EnrollmentID)
+	return data.Account.AccountID
+}
+
+// CSV statements are previewed, categorized by rules and imported once
+func TestImportCSV(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	accountID := createManualAccount(t, &accessToken, &refreshToken)
+
+	res := makeRequest(t, "POST", "/api/import/profiles", &accessToken, &refreshToken, map[string]interface{}{
+		"name":               "Credit Union",
+		"date_column":        "Date",
+		"date_format":        "MM/DD/YYYY",
+		"description_column": "Description",
+		"debit_column":       "Withdrawal",
+		"credit_column":      "Deposit",
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var saved struct {
+		Profile *finances.ImportProfile `json:"profile"`
+	}
+	json.NewDecoder(res.Body).Decode(&saved)
+
+	// profiles have to say where the amounts are
+	res = makeRequest(t, "POST", "/api/import/profiles", &accessToken, &refreshToken, map[string]interface{}{
+		"name":               "Broken",
+		"date_column":        "Date",
+		"date_format":        "MM/DD/YYYY",
+		"description_column": "Description",
+	})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	rule := map[string]interface{}{
+		"conditions": []map[string]string{{"field": rules.FieldName, "op": rules.OpContains, "value": "CITY WATER"}},
+		"actions":    []map[string]string{{"type": rules.SetCategory, "value": "bills"}},
+		"scope":      finances.ScopeFuture,
+	}
+	res = makeRequest(t, "POST", "/api/rules", &accessToken, &refreshToken, rule)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	file := []byte("Date,Description,Withdrawal,Deposit\n" +
+		"01/03/2019,CITY WATER DEPT,45.10,\n" +
+		"01/04/2019,PAYCHECK,,1500.00\n" +
+		"01/05/2019,COFFEE,3.00,\n" +
+		"01/05/2019,COFFEE,3.00,\n" +
+		"bad,ROW,1,\n")
+	fields := map[string]string{"profile_id": saved.Profile.ID.Hex(), "account_id": accountID, "preview": "true"}
+
+	res = makeUploadRequest(t, "/api/import/csv", &accessToken, &refreshToken, fields, file)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var preview struct {
+		Rows   []*finances.ImportedRow `json:"rows"`
+		Errors []*importer.RowError    `json:"errors"`
+	}
+	json.NewDecoder(res.Body).Decode(&preview)
+	assert.Len(t, preview.Rows, 4)
+	assert.Len(t, preview.Errors, 1)
+	assert.Equal(t, "bills", preview.Rows[0].Transaction.Category)
+	assert.Equal(t, money.Amount(-4510), preview.Rows[0].Transaction.Amount)
+	assert.Equal(t, "income", preview.Rows[1].Transaction.Category)
+	assert.NotEqual(t, preview.Rows[2].Transaction.TransactionID, preview.Rows[3].Transaction.TransactionID)
+
+	// nothing is saved by a preview
+	data := getTransactions(t, &accessToken, &refreshToken, url.Values{"account_id": {accountID}})
+	assert.Equal(t, 0, data.Count)
+
+	type result struct {
+		Imported   int `json:"imported"`
+		Duplicates int `json:"duplicates"`
+	}
+	importFile := func() *result {
+		fields["preview"] = "false"
+		res := makeUploadRequest(t, "/api/import/csv", &accessToken, &refreshToken, fields, file)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var data *result
+		json.NewDecoder(res.Body).Decode(&data)
+		return data
+	}
+	assert.Equal(t, &result{Imported: 4, Duplicates: 0}, importFile())
+	assert.Equal(t, &result{Imported: 0, Duplicates: 4}, importFile())
+
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"account_id": {accountID}})
+	assert.Equal(t, 4, data.Count)
+
+	// only manual accounts can be imported into
+	fields["account_id"] = "not-an-account"
+	res = makeUploadRequest(t, "/api/import/csv", &accessToken, &refreshToken, fields, file)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+// OFX entries are de-duplicated by FITID and the ledger balance is kept from the latest statement
+func TestImportOFX(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	accountID := createManualAccount(t, &accessToken, &refreshToken)
+
+	statement := func(balance, asOf string, fitIDs ...string) []byte {
+		entries := ""
+		for _, fitID := range fitIDs {
+			entries += "<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20220314<TRNAMT>-10.00<FITID>" + fitID + "<NAME>CORNER STORE</STMTTRN>\n"
+		}
+		return []byte("OFXHEADER:100\nDATA:OFXSGML\n\n<OFX><BANKMSGSRSV1><STMTTRNRS><STMTRS><CURDEF>USD\n" +
+			"<BANKACCTFROM><ACCTID>9876</BANKACCTFROM><BANKTRANLIST>\n" + entries +
+			"</BANKTRANLIST><LEDGERBAL><BALAMT>" + balance + "<DTASOF>" + asOf + "</LEDGERBAL></STMTRS></STMTTRNRS></BANKMSGSRSV1></OFX>")
+	}
+	type result struct {
+		Imported   int `json:"imported"`
+		Duplicates int `json:"duplicates"`
+	}
+	importFile := func(file []byte) *result {
+		res := makeUploadRequest(t, "/api/import/ofx", &accessToken, &refreshToken, map[string]string{"account_id": accountID}, file)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var data *result
+		json.NewDecoder(res.Body).Decode(&data)
+		return data
+	}
+	getBalance := func() money.Amount {
+		res := makeRequest(t, "GET", "/api/accounts", &accessToken, &refreshToken)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var data struct {
+			Accounts []*finances.Account `json:"accounts"`
+		}
+		json.NewDecoder(res.Body).Decode(&data)
+		for _, account := range data.Accounts {
+			if account.AccountID == accountID {
+				return account.Balance
+			}
+		}
+		return 0
+	}
+
+	assert.Equal(t, &result{Imported: 2, Duplicates: 0}, importFile(statement("500.00", "20220331", "a1", "a2")))
+	assert.Equal(t, money.Amount(50000), getBalance())
+
+	// overlapping statements only add new entries
+	assert.Equal(t, &result{Imported: 1, Duplicates: 1}, importFile(statement("480.00", "20220430", "a2", "a3")))
+	assert.Equal(t, money.Amount(48000), getBalance())
+
+	// an older statement doesn't replace a newer balance
+	assert.Equal(t, &result{Imported: 0, Duplicates: 1}, importFile(statement("100.00", "20220228", "a1")))
+	assert.Equal(t, money.Amount(48000), getBalance())
+
+	data := getTransactions(t, &accessToken, &refreshToken, url.Values{"account_id": {accountID}})
+	assert.Equal(t, 3, data.Count)
+	assert.Equal(t, finances.OFXTransactionID(accountID, "a3"), data.Transactions[0].TransactionID)
+
+	res := makeUploadRequest(t, "/api/import/ofx", &accessToken, &refreshToken, map[string]string{"account_id": accountID}, []byte("not ofx"))
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
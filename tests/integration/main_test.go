@@ -60,13 +60,28 @@ func TestMain(m *testing.M) {
 		}
 	}()
 	testApp.Db.SetCollections(mongoclient, dbName)
-	testApp.Db.CreateUniqueConstraints(ctx)
 
 	// clear tables
+	testApp.Db.Budgets.Drop(ctx)
+	testApp.Db.Categories.Drop(ctx)
+	testApp.Db.Classifiers.Drop(ctx)
+	testApp.Db.DismissedSuggestions.Drop(ctx)
+	testApp.Db.Envelopes.Drop(ctx)
+	testApp.Db.ExchangeRates.Drop(ctx)
+	testApp.Db.ImportProfiles.Drop(ctx)
+	testApp.Db.Merchants.Drop(ctx)
+	testApp.Db.Recategorizations.Drop(ctx)
+	testApp.Db.Recurring.Drop(ctx)
+	testApp.Db.Rules.Drop(ctx)
 	testApp.Db.Sessions.Drop(ctx)
+	testApp.Db.Templates.Drop(ctx)
 	testApp.Db.Transactions.Drop(ctx)
+	testApp.Db.Transfers.Drop(ctx)
 	testApp.Db.Users.Drop(ctx)
 
+	testApp.Db.CreateUniqueConstraints(ctx)
+	testApp.Db.RunMigrations(ctx)
+
 	// start test server
 	srv = httptest.NewServer(testApp.Router)
 
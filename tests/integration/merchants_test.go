@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/money"
+)
+
+// Transactions are grouped by merchant, which users can rename and give a logo
+func TestMerchants(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	date := time.Date(2022, time.August, 8, 0, 0, 0, 0, time.UTC).Format(time.RFC1123)
+	for _, tr := range []map[string]interface{}{
+		{"name": "SQ *BLUE BOTTLE 0423 OAKLAND CA", "amount": "6"},
+		{"name": "SQ *BLUE BOTTLE 0977 OAKLAND CA", "amount": "4"},
+		{"name": "SAFEWAY #1234", "amount": "50"},
+	} {
+		tr["date"] = date
+		tr["category"] = "restaurant"
+		res := makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, tr)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	data := getTransactions(t, &accessToken, &refreshToken, url.Values{"merchant": {"Blue Bottle"}})
+	assert.Equal(t, 2, data.Count)
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"merchant": {"blue  bottle"}})
+	assert.Equal(t, 2, data.Count)
+
+	res := makeRequest(t, "PATCH", "/api/merchants", &accessToken, &refreshToken, map[string]string{
+		"name":             "Blue Bottle",
+		"display_name":     "Blue Bottle Coffee",
+		"default_category": "restaurant",
+		"logo_url":         "https://example.com/bluebottle.png",
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res = makeRequest(t, "PATCH", "/api/merchants", &accessToken, &refreshToken, map[string]string{
+		"name":             "Safeway",
+		"default_category": "not-a-category",
+	})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = makeRequest(t, "GET", "/api/merchants", &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var merchants struct {
+		Merchants []*finances.Merchant `json:"merchants"`
+	}
+	json.NewDecoder(res.Body).Decode(&merchants)
+	assert.Len(t, merchants.Merchants, 2)
+	assert.Equal(t, "Blue Bottle Coffee", merchants.Merchants[0].DisplayName)
+	assert.Equal(t, 2, merchants.Merchants[0].Transactions)
+	assert.Equal(t, "Safeway", merchants.Merchants[1].DisplayName)
+
+	res = makeRequest(t, "GET", "/api/summary?from=2022-08-01&to=2022-08-31", &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var summary struct {
+		ByMerchant []*finances.MerchantTotal `json:"by_merchant"`
+	}
+	json.NewDecoder(res.Body).Decode(&summary)
+	assert.Len(t, summary.ByMerchant, 2)
+	assert.Equal(t, "Safeway", summary.ByMerchant[0].Merchant)
+	assert.Equal(t, money.Amount(5000), summary.ByMerchant[0].Expense)
+	assert.Equal(t, "Blue Bottle Coffee", summary.ByMerchant[1].DisplayName)
+	assert.Equal(t, money.Amount(1000), summary.ByMerchant[1].Expense)
+}
@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/recurring"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Monthly charges from one merchant are detected as a subscription
+func TestRecurring(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for i, amount := range []string{"17.99", "15.49", "15.49", "15.49"} {
+		res := makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, map[string]interface{}{
+			"name":     "NETFLIX.COM 866-579-7172",
+			"category": "entertainment",
+			"amount":   amount,
+			"date":     today.AddDate(0, -i, 0).Format(time.RFC1123),
+		})
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+	for _, day := range []int{2, 13, 40} {
+		res := makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, map[string]interface{}{
+			"name":     "SAFEWAY #1234",
+			"category": "groceries",
+			"amount":   "60",
+			"date":     today.AddDate(0, 0, -day).Format(time.RFC1123),
+		})
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	res := makeRequest(t, "GET", "/api/recurring", &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var data struct {
+		Recurring []*finances.RecurringSeries `json:"recurring"`
+	}
+	json.NewDecoder(res.Body).Decode(&data)
+	assert.Len(t, data.Recurring, 1)
+	series := data.Recurring[0]
+	assert.Equal(t, "Netflix.com", series.Merchant)
+	assert.Equal(t, recurring.Monthly, series.Cadence)
+	assert.Equal(t, 4, series.Charges)
+	assert.Equal(t, money.Amount(-1799), series.LastAmount)
+	assert.True(t, series.PriceIncrease)
+	assert.True(t, series.NextDate.After(today))
+
+	// detecting again keeps the series id
+	res = makeRequest(t, "GET", "/api/recurring?refresh=true", &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var refreshed struct {
+		Recurring []*finances.RecurringSeries `json:"recurring"`
+	}
+	json.NewDecoder(res.Body).Decode(&refreshed)
+	assert.Len(t, refreshed.Recurring, 1)
+	assert.Equal(t, series.ID, refreshed.Recurring[0].ID)
+	count, err := testApp.Db.Recurring.CountDocuments(ctx, bson.M{"user_id": testUser.ID})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,245 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/money"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Refunds net out in the category of their purchase and reimbursed expenses drop out of totals
+func TestRefundsAndReimbursements(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	day := time.Date(2022, time.October, 3, 0, 0, 0, 0, time.UTC)
+	ids := map[string]string{}
+	for _, tr := range []struct {
+		name     string
+		merchant string
+		category string
+		amount   money.Amount
+		days     int
+	}{
+		{"AMAZON MKTPLACE", "Amazon", "groceries", -8000, 0},
+		{"AMAZON REFUND", "Amazon", "income", 2000, 6},
+		{"HOTEL", "Marriott", "vacation", -30000, 1},
+		{"ACME CORP EXPENSES", "Acme Corp", "income", 30000, 12},
+		{"ACME CORP BONUS", "Acme Corp", "income", 35000, 14},
+	} {
+		ids[tr.name] = uuid.New().String()
+		_, err := testApp.Db.Transactions.InsertOne(ctx, bson.M{
+			"transaction_id": ids[tr.name],
+			"user_id":        testUser.ID,
+			"account_id":     "checking-" + testUser.Username,
+			"name":           tr.name,
+			"merchant":       tr.merchant,
+			"category":       tr.category,
+			"amount":         tr.amount,
+			"currency":       money.DefaultCurrency,
+			"date":           day.AddDate(0, 0, tr.days),
+		})
+		assert.Nil(t, err)
+	}
+
+	linked, err := finances.DetectRefunds(ctx, testApp.Db, &testUser.ID, time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, linked)
+
+	getSummary := func() (money.Amount, money.Amount) {
+		res := makeRequest(t, "GET", "/api/summary?from=2022-10-01&to=2022-10-31", &accessToken, &refreshToken)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var summary struct {
+			Income  money.Amount `json:"income"`
+			Expense money.Amount `json:"expense"`
+		}
+		json.NewDecoder(res.Body).Decode(&summary)
+		return summary.Income, summary.Expense
+	}
+	income, expense := getSummary()
+	assert.Equal(t, money.Amount(65000), income)
+	assert.Equal(t, money.Amount(36000), expense)
+
+	// the hotel is owed back and settled by the payment that names the payer
+	res := makeRequest(t, "PATCH", "/api/transactions/"+ids["HOTEL"]+"/reimbursable", &accessToken, &refreshToken, map[string]interface{}{
+		"payer": "acme",
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	getReimbursements := func(status string) ([]*finances.Transaction, []*finances.PayerTotal) {
+		res := makeRequest(t, "GET", "/api/reimbursements?status="+status, &accessToken, &refreshToken)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var data struct {
+			Transactions []*finances.Transaction `json:"transactions"`
+			Payers       []*finances.PayerTotal  `json:"payers"`
+		}
+		json.NewDecoder(res.Body).Decode(&data)
+		return data.Transactions, data.Payers
+	}
+	owed, payers := getReimbursements(finances.ReimbursementOwed)
+	assert.Len(t, owed, 1)
+	assert.Len(t, payers, 1)
+	assert.Equal(t, money.Amount(30000), payers[0].Owed)
+
+	reconciled, err := finances.ReconcileReimbursements(ctx, testApp.Db, &testUser.ID, time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, reconciled)
+
+	reimbursed, _ := getReimbursements(finances.Reimbursed)
+	assert.Len(t, reimbursed, 1)
+	assert.Equal(t, ids["ACME CORP EXPENSES"], reimbursed[0].Reimbursement.PaymentID)
+	income, expense = getSummary()
+	assert.Equal(t, money.Amount(35000), income)
+	assert.Equal(t, money.Amount(6000), expense)
+
+	// unlinking the payment makes the hotel owed again and counts the payment, which isn't matched again
+	res = makeRequest(t, "DELETE", "/api/reimbursements/"+ids["ACME CORP EXPENSES"], &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	owed, _ = getReimbursements(finances.ReimbursementOwed)
+	assert.Len(t, owed, 1)
+	reconciled, err = finances.ReconcileReimbursements(ctx, testApp.Db, &testUser.ID, time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, reconciled)
+	income, expense = getSummary()
+	assert.Equal(t, money.Amount(65000), income)
+	assert.Equal(t, money.Amount(36000), expense)
+
+	// refunds and payments that don't cover the expenses can't settle them
+	res = makeRequest(t, "POST", "/api/reimbursements/reconcile", &accessToken, &refreshToken, map[string]interface{}{
+		"payment_id":      ids["AMAZON REFUND"],
+		"transaction_ids": []string{ids["HOTEL"]},
+	})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	_, err = testApp.Db.Transactions.UpdateOne(ctx, bson.M{"transaction_id": ids["HOTEL"]}, bson.M{"$set": bson.M{"amount": money.Amount(-36000)}})
+	assert.Nil(t, err)
+	res = makeRequest(t, "POST", "/api/reimbursements/reconcile", &accessToken, &refreshToken, map[string]interface{}{
+		"payment_id":      ids["ACME CORP BONUS"],
+		"transaction_ids": []string{ids["HOTEL"]},
+	})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	_, err = testApp.Db.Transactions.UpdateOne(ctx, bson.M{"transaction_id": ids["HOTEL"]}, bson.M{"$set": bson.M{"amount": money.Amount(-30000)}})
+	assert.Nil(t, err)
+
+	// only the part of a payment that covered the expenses is left out
+	res = makeRequest(t, "POST", "/api/reimbursements/reconcile", &accessToken, &refreshToken, map[string]interface{}{
+		"payment_id":      ids["ACME CORP BONUS"],
+		"transaction_ids": []string{ids["HOTEL"]},
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var result struct {
+		Reconciled int          `json:"reconciled"`
+		Difference money.Amount `json:"difference"`
+	}
+	json.NewDecoder(res.Body).Decode(&result)
+	assert.Equal(t, 1, result.Reconciled)
+	assert.Equal(t, money.Amount(5000), result.Difference)
+	income, expense = getSummary()
+	assert.Equal(t, money.Amount(35000), income)
+	assert.Equal(t, money.Amount(6000), expense)
+
+	// picking the refund's category again keeps it linked
+	res = makeRequest(t, "PATCH", "/api/transactions/category", &accessToken, &refreshToken, map[string]string{
+		"transaction_id": ids["AMAZON REFUND"],
+		"category":       "groceries",
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	income, expense = getSummary()
+	assert.Equal(t, money.Amount(35000), income)
+	assert.Equal(t, money.Amount(6000), expense)
+
+	// unlinking the refund counts it as income again
+	res = makeRequest(t, "PATCH", "/api/transactions/"+ids["AMAZON REFUND"]+"/refund", &accessToken, &refreshToken, map[string]interface{}{
+		"refund_of": "",
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	income, expense = getSummary()
+	assert.Equal(t, money.Amount(37000), income)
+	assert.Equal(t, money.Amount(8000), expense)
+
+	// only refunds can be unlinked and only deposits can be linked
+	res = makeRequest(t, "PATCH", "/api/transactions/"+ids["AMAZON REFUND"]+"/refund", &accessToken, &refreshToken, map[string]interface{}{
+		"refund_of": "",
+	})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res = makeRequest(t, "PATCH", "/api/transactions/"+ids["HOTEL"]+"/refund", &accessToken, &refreshToken, map[string]interface{}{
+		"refund_of": ids["AMAZON MKTPLACE"],
+	})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	income, expense = getSummary()
+	assert.Equal(t, money.Amount(37000), income)
+	assert.Equal(t, money.Amount(8000), expense)
+}
+
+// Deleting a purchase or a payment releases the refunds and reimbursements linked to it
+func TestDeleteRefundedAndReimbursing(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	day := time.Date(2022, time.November, 7, 0, 0, 0, 0, time.UTC)
+	ids := map[string]string{}
+	for _, tr := range []struct {
+		name     string
+		merchant string
+		category string
+		amount   money.Amount
+		days     int
+	}{
+		{"BEST BUY", "Best Buy", "shopping", -12000, 0},
+		{"BEST BUY REFUND", "Best Buy", "income", 12000, 3},
+		{"TAXI", "Yellow Cab", "transportation", -4000, 1},
+		{"ACME CORP EXPENSES", "Acme Corp", "income", 4000, 9},
+	} {
+		ids[tr.name] = uuid.New().String()
+		_, err := testApp.Db.Transactions.InsertOne(ctx, bson.M{
+			"transaction_id": ids[tr.name],
+			"user_id":        testUser.ID,
+			"account_id":     "checking-" + testUser.Username,
+			"name":           tr.name,
+			"merchant":       tr.merchant,
+			"category":       tr.category,
+			"amount":         tr.amount,
+			"currency":       money.DefaultCurrency,
+			"date":           day.AddDate(0, 0, tr.days),
+		})
+		assert.Nil(t, err)
+	}
+
+	linked, err := finances.DetectRefunds(ctx, testApp.Db, &testUser.ID, time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, linked)
+	res := makeRequest(t, "PATCH", "/api/transactions/"+ids["TAXI"]+"/reimbursable", &accessToken, &refreshToken, map[string]interface{}{
+		"payer": "acme",
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	reconciled, err := finances.ReconcileReimbursements(ctx, testApp.Db, &testUser.ID, time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, reconciled)
+
+	for _, name := range []string{"BEST BUY", "ACME CORP EXPENSES"} {
+		res = makeRequest(t, "DELETE", "/api/transactions/"+ids[name], &accessToken, &refreshToken)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	var refund *finances.Transaction
+	assert.Nil(t, testApp.Db.Transactions.FindOne(ctx, bson.M{"transaction_id": ids["BEST BUY REFUND"]}).Decode(&refund))
+	assert.Equal(t, "", refund.RefundOf)
+	assert.Equal(t, "income", refund.Category)
+	assert.Equal(t, money.Amount(12000), refund.Amount)
+
+	var expense *finances.Transaction
+	assert.Nil(t, testApp.Db.Transactions.FindOne(ctx, bson.M{"transaction_id": ids["TAXI"]}).Decode(&expense))
+	assert.Equal(t, finances.ReimbursementOwed, expense.Reimbursement.Status)
+	assert.Equal(t, "", expense.Reimbursement.PaymentID)
+}
@@ -0,0 +1,434 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/rules"
+)
+
+// Rules with several conditions and actions are applied to existing transactions
+func TestRuleConditionsAndActions(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	date := time.Date(2022, time.June, 3, 0, 0, 0, 0, time.UTC).Format(time.RFC1123)
+	for _, tr := range []map[string]string{
+		{"name": "NETFLIX.COM 866-579", "category": "bills", "amount": "15.49"},
+		{"name": "netflix gift card", "category": "bills", "amount": "50"},
+	} {
+		tr["date"] = date
+		res := makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, tr)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	rule := map[string]interface{}{
+		"match": rules.MatchAll,
+		"conditions": []map[string]string{
+			{"field": rules.FieldName, "op": rules.OpRegex, "value": "^netflix"},
+			{"field": rules.FieldAmount, "op": rules.OpLt, "value": "20"},
+		},
+		"actions": []map[string]string{
+			{"type": rules.SetCategory, "value": "entertainment"},
+			{"type": rules.Rename, "value": "Netflix"},
+			{"type": rules.AddTag, "value": "Subscription"},
+		},
+		"scope": finances.ScopeAll,
+	}
+	res := makeRequest(t, "POST", "/api/rules", &accessToken, &refreshToken, rule)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	data := getTransactions(t, &accessToken, &refreshToken, url.Values{"category": {"entertainment"}})
+	assert.Equal(t, 1, data.Count)
+	assert.Equal(t, "Netflix", data.Transactions[0].Name)
+	assert.Equal(t, []string{"subscription"}, data.Transactions[0].Tags)
+
+	// ignore actions move matches to the ignore category
+	rule = map[string]interface{}{
+		"conditions": []map[string]string{{"field": rules.FieldName, "op": rules.OpContains, "value": "GIFT CARD"}},
+		"actions":    []map[string]string{{"type": rules.MarkIgnore}},
+		"scope":      finances.ScopeAll,
+	}
+	res = makeRequest(t, "POST", "/api/rules", &accessToken, &refreshToken, rule)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"category": {"ignore"}})
+	assert.Equal(t, 1, data.Count)
+
+	// unknown categories and bad conditions are rejected
+	rule = map[string]interface{}{
+		"conditions": []map[string]string{{"field": rules.FieldName, "op": rules.OpContains, "value": "x"}},
+		"actions":    []map[string]string{{"type": rules.SetCategory, "value": "nope"}},
+	}
+	res = makeRequest(t, "POST", "/api/rules", &accessToken, &refreshToken, rule)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	rule = map[string]interface{}{
+		"conditions": []map[string]string{{"field": rules.FieldAmount, "op": rules.OpGt, "value": "ten"}},
+		"actions":    []map[string]string{{"type": rules.SetCategory, "value": "bills"}},
+	}
+	res = makeRequest(t, "POST", "/api/rules", &accessToken, &refreshToken, rule)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+// Rules run in priority order, can be reordered and overlapping rules are reported
+func TestRulePriorityAndConflicts(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	body := map[string]string{
+		"date":     time.Date(2022, time.July, 8, 0, 0, 0, 0, time.UTC).Format(time.RFC1123),
+		"name":     "Amazon Fresh",
+		"category": "bills",
+		"amount":   "64",
+	}
+	res := makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	for _, rule := range []map[string]string{
+		{"substring": "Amazon", "category": "entertainment"},
+		{"substring": "Fresh", "category": "groceries"},
+	} {
+		res = makeRequest(t, "POST", "/api/rules", &accessToken, &refreshToken, rule)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	getRules := func() []*finances.Rule {
+		res := makeRequest(t, "GET", "/api/rules", &accessToken, &refreshToken)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var data struct {
+			Rules []*finances.Rule `json:"rules"`
+		}
+		json.NewDecoder(res.Body).Decode(&data)
+		return data.Rules
+	}
+	ruleset := getRules()
+	assert.Equal(t, 2, len(ruleset))
+	assert.Equal(t, 1, ruleset[0].Priority)
+	assert.Equal(t, 2, ruleset[1].Priority)
+
+	res = makeRequest(t, "GET", "/api/rules/conflicts", &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var conflicts struct {
+		Conflicts []struct {
+			Transaction *finances.Transaction `json:"transaction"`
+			Rules       []*finances.Rule      `json:"rules"`
+		} `json:"conflicts"`
+	}
+	json.NewDecoder(res.Body).Decode(&conflicts)
+	assert.Equal(t, 1, len(conflicts.Conflicts))
+	assert.Equal(t, "Amazon Fresh", conflicts.Conflicts[0].Transaction.Name)
+	assert.Equal(t, 2, len(conflicts.Conflicts[0].Rules))
+
+	// every rule must be included exactly once
+	res = makeRequest(t, "PATCH", "/api/rules/order", &accessToken, &refreshToken, map[string]interface{}{
+		"rule_ids": []string{ruleset[1].ID.Hex()},
+	})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = makeRequest(t, "PATCH", "/api/rules/order", &accessToken, &refreshToken, map[string]interface{}{
+		"rule_ids": []string{ruleset[1].ID.Hex(), ruleset[0].ID.Hex()},
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	reordered := getRules()
+	assert.Equal(t, ruleset[1].ID, reordered[0].ID)
+	assert.Equal(t, ruleset[0].ID, reordered[1].ID)
+}
+
+// Previews list changes without saving and rules only touch history inside their scope
+func TestRulePreviewAndScope(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	for _, day := range []int{1, 20} {
+		body := map[string]string{
+			"date":     time.Date(2022, time.August, day, 0, 0, 0, 0, time.UTC).Format(time.RFC1123),
+			"name":     "Shell Oil",
+			"category": "bills",
+			"amount":   "45",
+		}
+		res := makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, body)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	rule := map[string]interface{}{"substring": "shell", "category": "transportation"}
+	res := makeRequest(t, "POST", "/api/rules/preview", &accessToken, &refreshToken, rule)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var preview struct {
+		Changes []*finances.RuleChange `json:"changes"`
+		Count   int                    `json:"count"`
+	}
+	json.NewDecoder(res.Body).Decode(&preview)
+	assert.Equal(t, 2, preview.Count)
+	assert.Equal(t, "bills", preview.Changes[0].FromCategory)
+	assert.Equal(t, "transportation", preview.Changes[0].ToCategory)
+	assert.Equal(t, preview.Changes[0].FromAmount, preview.Changes[0].ToAmount)
+
+	// nothing is saved by a preview
+	data := getTransactions(t, &accessToken, &refreshToken, url.Values{"category": {"transportation"}})
+	assert.Equal(t, 0, data.Count)
+
+	// future only rules leave history alone
+	rule["scope"] = finances.ScopeFuture
+	res = makeRequest(t, "POST", "/api/rules", &accessToken, &refreshToken, rule)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"category": {"transportation"}})
+	assert.Equal(t, 0, data.Count)
+
+	rule["scope"] = finances.ScopeFromDate
+	rule["from"] = "2022-08-10"
+	res = makeRequest(t, "POST", "/api/rules", &accessToken, &refreshToken, rule)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"category": {"transportation"}})
+	assert.Equal(t, 1, data.Count)
+	assert.Equal(t, 20, data.Transactions[0].Date.Day())
+
+	rule["from"] = "August 10"
+	res = makeRequest(t, "POST", "/api/rules", &accessToken, &refreshToken, rule)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// rules created without a scope apply to all history
+	delete(rule, "scope")
+	delete(rule, "from")
+	res = makeRequest(t, "POST", "/api/rules", &accessToken, &refreshToken, rule)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"category": {"transportation"}})
+	assert.Equal(t, 2, data.Count)
+}
+
+// Editing or deleting a rule can revert what it did or re-run the remaining rules
+func TestRuleEditAndRevert(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	body := map[string]string{
+		"date":     time.Date(2022, time.September, 5, 0, 0, 0, 0, time.UTC).Format(time.RFC1123),
+		"name":     "Starbucks Reserve",
+		"category": "bills",
+		"amount":   "7.25",
+	}
+	res := makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	createRule := func(rule map[string]interface{}) *finances.Rule {
+		rule["scope"] = finances.ScopeAll
+		res := makeRequest(t, "POST", "/api/rules", &accessToken, &refreshToken, rule)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var data struct {
+			Rule *finances.Rule `json:"rule"`
+		}
+		json.NewDecoder(res.Body).Decode(&data)
+		return data.Rule
+	}
+	getTransaction := func() *finances.Transaction {
+		data := getTransactions(t, &accessToken, &refreshToken, url.Values{"search": {"Starbucks"}})
+		assert.Equal(t, 1, data.Count)
+		return data.Transactions[0]
+	}
+
+	first := createRule(map[string]interface{}{"substring": "starbucks", "category": "restaurant"})
+	second := createRule(map[string]interface{}{"substring": "reserve", "category": "entertainment"})
+
+	// the higher priority rule categorized it
+	transaction := getTransaction()
+	assert.Equal(t, "restaurant", transaction.Category)
+	assert.Equal(t, first.ID, *transaction.RuleID)
+	assert.Equal(t, "bills", transaction.OriginalCategory)
+
+	// editing with rerun hands the transaction to the remaining rules
+	res = makeRequest(t, "PATCH", "/api/rules/"+first.ID.Hex(), &accessToken, &refreshToken, map[string]interface{}{
+		"substring": "dunkin",
+		"category":  "restaurant",
+		"revert":    finances.RevertRerun,
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	transaction = getTransaction()
+	assert.Equal(t, "entertainment", transaction.Category)
+	assert.Equal(t, second.ID, *transaction.RuleID)
+
+	// deleting with revert restores the original category
+	res = makeRequest(t, "DELETE", "/api/rules/"+second.ID.Hex()+"?revert="+finances.RevertOriginal, &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	transaction = getTransaction()
+	assert.Equal(t, "bills", transaction.Category)
+	assert.Nil(t, transaction.RuleID)
+
+	res = makeRequest(t, "DELETE", "/api/rules/"+first.ID.Hex()+"?revert=sometimes", &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+// Fields edited by hand are locked against rules until unlocked
+func TestLockedFields(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	for _, name := range []string{"Target 0042", "Target 0777"} {
+		body := map[string]string{
+			"date":     time.Date(2022, time.October, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC1123),
+			"name":     name,
+			"category": "bills",
+			"amount":   "30",
+		}
+		res := makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, body)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+	data := getTransactions(t, &accessToken, &refreshToken, url.Values{"search": {"Target 0042"}})
+	assert.Equal(t, 1, data.Count)
+	locked := data.Transactions[0]
+
+	res := makeRequest(t, "PATCH", "/api/transactions/category", &accessToken, &refreshToken, map[string]string{
+		"transaction_id": locked.TransactionID,
+		"category":       "vacation",
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// picking the category a transaction already has doesn't lock it
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"search": {"Target 0777"}})
+	assert.Equal(t, 1, data.Count)
+	res = makeRequest(t, "PATCH", "/api/transactions/category", &accessToken, &refreshToken, map[string]string{
+		"transaction_id": data.Transactions[0].TransactionID,
+		"category":       "bills",
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	rule := map[string]interface{}{
+		"conditions": []map[string]string{{"field": rules.FieldName, "op": rules.OpContains, "value": "target"}},
+		"actions": []map[string]string{
+			{"type": rules.SetCategory, "value": "groceries"},
+			{"type": rules.Rename, "value": "Target"},
+		},
+		"scope": finances.ScopeAll,
+	}
+	res = makeRequest(t, "POST", "/api/rules", &accessToken, &refreshToken, rule)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// the locked category is kept while the unlocked name is changed
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"category": {"vacation"}})
+	assert.Equal(t, 1, data.Count)
+	assert.Equal(t, "Target", data.Transactions[0].Name)
+	assert.Equal(t, []string{finances.LockCategory}, data.Transactions[0].Locked)
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"category": {"groceries"}})
+	assert.Equal(t, 1, data.Count)
+
+	res = makeRequest(t, "PATCH", "/api/transactions/"+locked.TransactionID+"/unlock", &accessToken, &refreshToken, map[string]interface{}{
+		"fields": []string{"color"},
+	})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res = makeRequest(t, "PATCH", "/api/transactions/"+locked.TransactionID+"/unlock", &accessToken, &refreshToken, map[string]interface{}{
+		"fields": []string{finances.LockCategory},
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = makeRequest(t, "POST", "/api/rules", &accessToken, &refreshToken, rule)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"category": {"groceries"}})
+	assert.Equal(t, 2, data.Count)
+
+	// a category edit that flips the sign doesn't lock the amount
+	edited := data.Transactions[0]
+	res = makeRequest(t, "PATCH", "/api/transactions", &accessToken, &refreshToken, map[string]string{
+		"transaction_id": edited.TransactionID,
+		"date":           edited.Date.Format(time.RFC1123),
+		"name":           edited.Name,
+		"category":       "income",
+		"amount":         "30",
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"category": {"income"}})
+	assert.Equal(t, 1, data.Count)
+	assert.Equal(t, money.Amount(3000), data.Transactions[0].Amount)
+	assert.Equal(t, []string{finances.LockCategory}, data.Transactions[0].Locked)
+}
+
+// Repeated recategorizations are suggested as rules that can be accepted or dismissed
+func TestRuleSuggestions(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	date := time.Date(2022, time.July, 2, 0, 0, 0, 0, time.UTC).Format(time.RFC1123)
+	for _, name := range []string{"TRADER JOE'S #123", "TRADER JOE'S #456", "TRADER JOE'S #789", "TRADER JOE'S #999", "SHELL 4411", "SHELL 5522", "SHELL 6633"} {
+		res := makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, map[string]interface{}{
+			"name": name, "category": "uncategorized", "amount": "20", "date": date,
+		})
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	data := getTransactions(t, &accessToken, &refreshToken, url.Values{})
+	for _, tr := range data.Transactions {
+		category := "groceries"
+		if strings.HasPrefix(tr.Name, "SHELL") {
+			category = "transportation"
+		}
+		// the fourth trader joe's is left for the accepted rule
+		if tr.Name == "TRADER JOE'S #999" {
+			continue
+		}
+		res := makeRequest(t, "PATCH", "/api/transactions/category", &accessToken, &refreshToken, map[string]string{
+			"transaction_id": tr.TransactionID,
+			"category":       category,
+		})
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	getSuggestions := func() []*finances.RuleSuggestion {
+		res := makeRequest(t, "GET", "/api/rules/suggestions", &accessToken, &refreshToken)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var data struct {
+			Suggestions []*finances.RuleSuggestion `json:"suggestions"`
+		}
+		json.NewDecoder(res.Body).Decode(&data)
+		return data.Suggestions
+	}
+	// a rule that matches the substring but not the transactions doesn't cover the suggestion
+	res := makeRequest(t, "POST", "/api/rules", &accessToken, &refreshToken, map[string]interface{}{
+		"conditions": []map[string]string{{"field": rules.FieldName, "op": rules.OpEquals, "value": "shell"}},
+		"actions":    []map[string]string{{"type": rules.SetCategory, "value": "transportation"}},
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	suggestions := getSuggestions()
+	assert.Len(t, suggestions, 2)
+	assert.Equal(t, "SHELL", suggestions[0].Substring)
+	assert.Equal(t, "transportation", suggestions[0].Category)
+	assert.Equal(t, "TRADER JOE'S", suggestions[1].Substring)
+	assert.Equal(t, 3, suggestions[1].Count)
+
+	res = makeRequest(t, "POST", "/api/rules/suggestions/dismiss", &accessToken, &refreshToken, map[string]string{
+		"substring": "SHELL",
+		"category":  "transportation",
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res = makeRequest(t, "POST", "/api/rules/suggestions/accept", &accessToken, &refreshToken, map[string]string{
+		"substring": "TRADER JOE'S",
+		"category":  "groceries",
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// accepted suggestions apply to history and both are gone from the list
+	assert.Len(t, getSuggestions(), 0)
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"category": {"groceries"}})
+	assert.Equal(t, 4, data.Count)
+}
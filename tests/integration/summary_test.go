@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Summary totals income and expenses by category, month and account
+func TestSummary(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	date := time.Date(2022, time.March, 15, 0, 0, 0, 0, time.UTC)
+	for _, tr := range []map[string]string{
+		{"name": "market", "category": "groceries", "amount": "80"},
+		{"name": "cafe", "category": "restaurant", "amount": "20"},
+		{"name": "paycheck", "category": "income", "amount": "1000"},
+		{"name": "card payment", "category": "ignore", "amount": "500"},
+	} {
+		tr["date"] = date.Format(time.RFC1123)
+		res := makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, tr)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	res := makeRequest(t, "GET", "/api/summary?from=2022-03-01&to=2022-03-31", &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var data struct {
+		ByCategory []struct {
+			Category string  `json:"category"`
+			Amount   float32 `json:"amount"`
+		} `json:"by_category"`
+		ByMonth []struct {
+			Month   int     `json:"month"`
+			Expense float32 `json:"expense"`
+		} `json:"by_month"`
+		ByAccount []struct {
+			AccountID string  `json:"account_id"`
+			Net       float32 `json:"net"`
+		} `json:"by_account"`
+		Income  float32 `json:"income"`
+		Expense float32 `json:"expense"`
+		Net     float32 `json:"net"`
+	}
+	json.NewDecoder(res.Body).Decode(&data)
+
+	// ignored transactions are left out
+	assert.Equal(t, 3, len(data.ByCategory))
+	assert.Equal(t, float32(1000), data.Income)
+	assert.Equal(t, float32(100), data.Expense)
+	assert.Equal(t, float32(900), data.Net)
+	assert.Equal(t, 1, len(data.ByMonth))
+	assert.Equal(t, 3, data.ByMonth[0].Month)
+	assert.Equal(t, 1, len(data.ByAccount))
+	assert.Equal(t, "user_created", data.ByAccount[0].AccountID)
+
+	// bad dates are rejected
+	res = makeRequest(t, "GET", "/api/summary?from=03-01-2022", &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
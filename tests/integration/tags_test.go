@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/money"
+)
+
+// Tags are added and removed in bulk, filtered on and totalled in the summary
+func TestTransactionTags(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	date := time.Date(2022, time.May, 10, 0, 0, 0, 0, time.UTC).Format(time.RFC1123)
+	for _, tr := range []map[string]interface{}{
+		{"name": "Tokyo Hotel", "category": "vacation", "amount": "300", "notes": "two nights"},
+		{"name": "Ramen Shop", "category": "restaurant", "amount": "15"},
+		{"name": "Office Depot", "category": "bills", "amount": "40", "tags": []string{"Reimbursable"}},
+	} {
+		tr["date"] = date
+		res := makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, tr)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	data := getTransactions(t, &accessToken, &refreshToken, url.Values{"month": {"5"}, "year": {"2022"}})
+	assert.Equal(t, 3, data.Count)
+	ids := map[string]string{}
+	for _, tr := range data.Transactions {
+		ids[tr.Name] = tr.TransactionID
+		if tr.Name == "Tokyo Hotel" {
+			assert.Equal(t, "two nights", tr.Notes)
+		}
+	}
+
+	res := makeRequest(t, "PATCH", "/api/transactions/tags/add", &accessToken, &refreshToken, map[string]interface{}{
+		"transaction_ids": []string{ids["Tokyo Hotel"], ids["Ramen Shop"]},
+		"tags":            []string{"trip-japan", "Tax-Deductible"},
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = makeRequest(t, "PATCH", "/api/transactions/tags/remove", &accessToken, &refreshToken, map[string]interface{}{
+		"transaction_ids": []string{ids["Ramen Shop"]},
+		"tags":            []string{"tax-deductible"},
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"tag": {"trip-japan"}})
+	assert.Equal(t, 2, data.Count)
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"tag": {"tax-deductible"}})
+	assert.Equal(t, 1, data.Count)
+
+	// rules can apply tags without changing the category
+	res = makeRequest(t, "POST", "/api/rules", &accessToken, &refreshToken, map[string]interface{}{
+		"substring": "Office",
+		"tags":      []string{"work"},
+		"scope":     finances.ScopeAll,
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"tag": {"work"}})
+	assert.Equal(t, 1, data.Count)
+	assert.Equal(t, "bills", data.Transactions[0].Category)
+	assert.Equal(t, []string{"reimbursable", "work"}, data.Transactions[0].Tags)
+
+	res = makeRequest(t, "GET", "/api/summary?from=2022-05-01&to=2022-05-31", &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var summary struct {
+		ByTag []struct {
+			Tag     string       `json:"tag"`
+			Expense money.Amount `json:"expense"`
+		} `json:"by_tag"`
+	}
+	json.NewDecoder(res.Body).Decode(&summary)
+	expenses := map[string]money.Amount{}
+	for _, total := range summary.ByTag {
+		expenses[total.Tag] = total.Expense
+	}
+	assert.Equal(t, money.Amount(31500), expenses["trip-japan"])
+	assert.Equal(t, money.Amount(30000), expenses["tax-deductible"])
+	assert.Equal(t, money.Amount(4000), expenses["work"])
+}
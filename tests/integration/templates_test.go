@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/money"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Templates create their transactions on schedule, catching up once and only once
+func TestTemplates(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	res := makeRequest(t, "POST", "/api/templates", &accessToken, &refreshToken, map[string]interface{}{
+		"name":       "Cash Rent",
+		"category":   "bills",
+		"amount":     "1200",
+		"frequency":  finances.FrequencyWeekly,
+		"interval":   2,
+		"start_date": today.AddDate(0, 0, -28).Format("2006-01-02"),
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var created struct {
+		Template *finances.Template `json:"template"`
+		Created  int                `json:"created"`
+	}
+	json.NewDecoder(res.Body).Decode(&created)
+	assert.Equal(t, 3, created.Created)
+	assert.Equal(t, today.AddDate(0, 0, 14), created.Template.NextDate)
+
+	// a second run has nothing left to create
+	count, err := finances.MaterializeTemplates(ctx, testApp.Db, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+
+	data := getTransactions(t, &accessToken, &refreshToken, url.Values{"search": {"Cash Rent"}})
+	assert.Equal(t, 3, data.Count)
+	assert.Equal(t, money.Amount(-120000), data.Transactions[0].Amount)
+	assert.Equal(t, today, data.Transactions[0].Date)
+
+	// catches up on every occurrence missed while jobs weren't running
+	_, err = testApp.Db.Templates.UpdateOne(ctx, bson.M{"_id": created.Template.ID}, bson.M{"$set": bson.M{"created": 1}})
+	assert.Nil(t, err)
+	_, err = testApp.Db.Transactions.DeleteOne(ctx, bson.M{"transaction_id": created.Template.TransactionID(today)})
+	assert.Nil(t, err)
+	count, err = finances.MaterializeTemplates(ctx, testApp.Db, today.AddDate(0, 0, 15))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"search": {"Cash Rent"}})
+	assert.Equal(t, 4, data.Count)
+
+	res = makeRequest(t, "DELETE", "/api/templates/"+created.Template.ID.Hex(), &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res = makeRequest(t, "GET", "/api/templates", &accessToken, &refreshToken)
+	var templates struct {
+		Templates []*finances.Template `json:"templates"`
+	}
+	json.NewDecoder(res.Body).Decode(&templates)
+	assert.Len(t, templates.Templates, 0)
+}
+
+// Templates only catch up within the catch up window and stop being run once they've ended
+func TestTemplateCatchUpAndEnd(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	res := makeRequest(t, "POST", "/api/templates", &accessToken, &refreshToken, map[string]interface{}{
+		"name":       "Allowance",
+		"category":   "bills",
+		"amount":     "10",
+		"frequency":  finances.FrequencyWeekly,
+		"start_date": "1900-01-01",
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var created struct {
+		Template *finances.Template `json:"template"`
+		Created  int                `json:"created"`
+	}
+	json.NewDecoder(res.Body).Decode(&created)
+	assert.LessOrEqual(t, created.Created, finances.TemplateCatchUpDays/7+1)
+	assert.False(t, created.Template.Ended)
+
+	res = makeRequest(t, "POST", "/api/templates", &accessToken, &refreshToken, map[string]interface{}{
+		"name":       "Gym Class",
+		"category":   "bills",
+		"amount":     "25",
+		"frequency":  finances.FrequencyWeekly,
+		"start_date": today.AddDate(0, 0, -60).Format("2006-01-02"),
+		"end_date":   today.AddDate(0, 0, -30).Format("2006-01-02"),
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	json.NewDecoder(res.Body).Decode(&created)
+	assert.Equal(t, 5, created.Created)
+	assert.True(t, created.Template.Ended)
+
+	var ended *finances.Template
+	assert.Nil(t, testApp.Db.Templates.FindOne(ctx, bson.M{"_id": created.Template.ID}).Decode(&ended))
+	assert.True(t, ended.Ended)
+	count, err := testApp.Db.Templates.CountDocuments(ctx, bson.M{
+		"user_id":   testUser.ID,
+		"ended":     bson.M{"$ne": true},
+		"next_date": bson.M{"$lte": time.Now()},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), count)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"mime/multipart"
 	"net/http"
 	"testing"
 	"time"
@@ -104,7 +105,7 @@ func getCookies(t *testing.T, cookies_res []*http.Cookie) map[string]string {
 	}
 	return cookies
 }
-func makeRequest(t *testing.T, method string, url string, accessToken *string, refreshToken *string, body ...map[string]string) (res *http.Response) {
+func makeRequest(t *testing.T, method string, url string, accessToken *string, refreshToken *string, body ...interface{}) (res *http.Response) {
 	t.Helper()
 
 	var req *http.Request
@@ -134,3 +135,32 @@ func makeRequest(t *testing.T, method string, url string, accessToken *string, r
 	require.NoError(t, err)
 	return res
 }
+
+// Sends a multipart form with a file, the way statement imports are uploaded
+func makeUploadRequest(t *testing.T, url string, accessToken *string, refreshToken *string, fields map[string]string, file []byte) (res *http.Response) {
+	t.Helper()
+
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	for key, value := range fields {
+		require.NoError(t, writer.WriteField(key, value))
+	}
+	part, err := writer.CreateFormFile("file", "statement")
+	require.NoError(t, err)
+	_, err = part.Write(file)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("%s%s", srv.URL, url), body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.AddCookie(&http.Cookie{
+		Name:  "goexpense_access",
+		Value: *accessToken})
+	req.AddCookie(&http.Cookie{
+		Name:  "goexpense_refresh",
+		Value: *refreshToken})
+
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return res
+}
@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/money"
+)
+
+type transactionsResponse struct {
+	Transactions []*finances.Transaction `json:"transactions"`
+	Count        int                     `json:"count"`
+	Years        []int                   `json:"years"`
+	NextCursor   string                  `json:"next_cursor"`
+}
+
+func getTransactions(t *testing.T, accessToken, refreshToken *string, query url.Values) transactionsResponse {
+	t.Helper()
+
+	res := makeRequest(t, "GET", "/api/transactions?"+query.Encode(), accessToken, refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var data transactionsResponse
+	json.NewDecoder(res.Body).Decode(&data)
+	return data
+}
+
+// Transactions list pages through results with a cursor and applies filters
+func TestTransactionsPagination(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	start := time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		category := "groceries"
+		if i%2 == 0 {
+			category = "restaurant"
+		}
+		body := map[string]string{
+			"date":     start.AddDate(0, 0, i).Format(time.RFC1123),
+			"name":     fmt.Sprintf("Store %d", i),
+			"category": category,
+			"amount":   fmt.Sprint(10 * (i + 1)),
+		}
+		res := makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, body)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	// page through everything two at a time
+	seen := []string{}
+	query := url.Values{"limit": {"2"}}
+	for {
+		data := getTransactions(t, &accessToken, &refreshToken, query)
+		for _, tr := range data.Transactions {
+			seen = append(seen, tr.Name)
+		}
+		if data.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", data.NextCursor)
+	}
+	assert.Equal(t, []string{"Store 4", "Store 3", "Store 2", "Store 1", "Store 0"}, seen)
+
+	// filters
+	data := getTransactions(t, &accessToken, &refreshToken, url.Values{"category": {"restaurant"}})
+	assert.Equal(t, 3, data.Count)
+
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"search": {"store 1"}})
+	assert.Equal(t, 1, data.Count)
+
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"min_amount": {"-30"}, "sort": {"amount"}, "order": {"asc"}})
+	assert.Equal(t, 3, data.Count)
+	assert.Equal(t, money.Amount(-3000), data.Transactions[0].Amount)
+
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"from": {"2021-01-02"}, "to": {"2021-01-03"}})
+	assert.Equal(t, 2, data.Count)
+	assert.Equal(t, []int{2021}, data.Years)
+
+	// invalid sort fields are rejected
+	res := makeRequest(t, "GET", "/api/transactions?sort=user_id", &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+// Split transactions are counted by their splits in totals and filters
+func TestTransactionSplits(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	body := map[string]string{
+		"date":     time.Date(2022, time.April, 2, 0, 0, 0, 0, time.UTC).Format(time.RFC1123),
+		"name":     "Costco",
+		"category": "groceries",
+		"amount":   "100",
+	}
+	res := makeRequest(t, "POST", "/api/transactions", &accessToken, &refreshToken, body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	data := getTransactions(t, &accessToken, &refreshToken, url.Values{"month": {"4"}, "year": {"2022"}})
+	assert.Equal(t, 1, data.Count)
+	transactionID := data.Transactions[0].TransactionID
+	splitsURL := "/api/transactions/" + transactionID + "/splits"
+
+	// splits must add up to the transaction amount
+	res = makeRequest(t, "PATCH", splitsURL, &accessToken, &refreshToken, map[string]interface{}{
+		"splits": []map[string]interface{}{
+			{"category": "groceries", "amount": 60},
+			{"category": "bills", "amount": 30},
+		},
+	})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = makeRequest(t, "PATCH", splitsURL, &accessToken, &refreshToken, map[string]interface{}{
+		"splits": []map[string]interface{}{
+			{"category": "groceries", "amount": 60},
+			{"category": "bills", "amount": 40, "note": "paper towels"},
+		},
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// the list filter matches split categories
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"category": {"bills"}})
+	assert.Equal(t, 1, data.Count)
+	assert.Equal(t, 2, len(data.Transactions[0].Splits))
+	assert.Equal(t, money.Amount(-4000), data.Transactions[0].Splits[1].Amount)
+
+	res = makeRequest(t, "GET", "/api/categories/totals?month=4&year=2022", &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var totals struct {
+		Totals []struct {
+			Category string       `json:"category"`
+			Amount   money.Amount `json:"amount"`
+		} `json:"totals"`
+	}
+	json.NewDecoder(res.Body).Decode(&totals)
+	amounts := map[string]money.Amount{}
+	for _, total := range totals.Totals {
+		amounts[total.Category] = total.Amount
+	}
+	assert.Equal(t, money.Amount(-6000), amounts["groceries"])
+	assert.Equal(t, money.Amount(-4000), amounts["bills"])
+
+	// an empty list removes the splits
+	res = makeRequest(t, "PATCH", splitsURL, &accessToken, &refreshToken, map[string]interface{}{
+		"splits": []map[string]interface{}{},
+	})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"category": {"bills"}})
+	assert.Equal(t, 0, data.Count)
+}
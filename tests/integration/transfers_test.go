@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/money"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Payments between a user's accounts are detected and left out of totals once confirmed until unlinked
+func TestTransfers(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	day := time.Date(2022, time.September, 5, 0, 0, 0, 0, time.UTC)
+	ids := map[string]string{}
+	for _, tr := range []struct {
+		name     string
+		account  string
+		category string
+		amount   money.Amount
+		days     int
+	}{
+		{"CARD PAYMENT", "checking-" + testUser.Username, "bills", -50000, 0},
+		{"PAYMENT THANK YOU", "credit-" + testUser.Username, "income", 50000, 2},
+		{"GROCERIES", "credit-" + testUser.Username, "groceries", -8000, 1},
+	} {
+		ids[tr.name] = uuid.New().String()
+		_, err := testApp.Db.Transactions.InsertOne(ctx, bson.M{
+			"transaction_id": ids[tr.name],
+			"user_id":        testUser.ID,
+			"account_id":     tr.account,
+			"name":           tr.name,
+			"category":       tr.category,
+			"amount":         tr.amount,
+			"currency":       money.DefaultCurrency,
+			"date":           day.AddDate(0, 0, tr.days),
+		})
+		assert.Nil(t, err)
+	}
+
+	getTransfers := func(query string) []*finances.Transfer {
+		res := makeRequest(t, "GET", "/api/transfers"+query, &accessToken, &refreshToken)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var data struct {
+			Transfers []*finances.Transfer `json:"transfers"`
+		}
+		json.NewDecoder(res.Body).Decode(&data)
+		return data.Transfers
+	}
+	transfers := getTransfers("?refresh=true")
+	assert.Len(t, transfers, 1)
+	assert.Equal(t, ids["CARD PAYMENT"], transfers[0].FromTransactionID)
+	assert.Equal(t, ids["PAYMENT THANK YOU"], transfers[0].To.TransactionID)
+	assert.Equal(t, finances.TransferDetected, transfers[0].Status)
+
+	getSummary := func() (money.Amount, money.Amount) {
+		res := makeRequest(t, "GET", "/api/summary?from=2022-09-01&to=2022-09-30", &accessToken, &refreshToken)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var summary struct {
+			Income  money.Amount `json:"income"`
+			Expense money.Amount `json:"expense"`
+		}
+		json.NewDecoder(res.Body).Decode(&summary)
+		return summary.Income, summary.Expense
+	}
+
+	// detected transfers count until the user confirms them
+	income, expense := getSummary()
+	assert.Equal(t, money.Amount(50000), income)
+	assert.Equal(t, money.Amount(58000), expense)
+	data := getTransactions(t, &accessToken, &refreshToken, url.Values{"transfer": {"true"}})
+	assert.Equal(t, 0, data.Count)
+	assert.Len(t, getTransfers("?refresh=true"), 1)
+
+	res := makeRequest(t, "PATCH", "/api/transfers/"+transfers[0].ID.Hex()+"/confirm", &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, finances.TransferConfirmed, getTransfers("")[0].Status)
+	income, expense = getSummary()
+	assert.Equal(t, money.Amount(0), income)
+	assert.Equal(t, money.Amount(8000), expense)
+	data = getTransactions(t, &accessToken, &refreshToken, url.Values{"transfer": {"true"}})
+	assert.Equal(t, 2, data.Count)
+
+	// unlinked pairs count again and aren't detected a second time
+	res = makeRequest(t, "DELETE", "/api/transfers/"+transfers[0].ID.Hex(), &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Len(t, getTransfers("?refresh=true"), 0)
+	income, expense = getSummary()
+	assert.Equal(t, money.Amount(50000), income)
+	assert.Equal(t, money.Amount(58000), expense)
+}
+
+// Deleting one side of a transfer deletes the transfer and counts the other side again
+func TestDeleteTransferSide(t *testing.T) {
+	t.Parallel()
+
+	testUser, cleanup := createTestUser(t)
+	defer cleanup()
+	accessToken, refreshToken, _ := logUserIn(t, testUser.Username, testUser.Password)
+
+	day := time.Date(2022, time.September, 5, 0, 0, 0, 0, time.UTC)
+	fromID, toID := uuid.New().String(), uuid.New().String()
+	for _, tr := range []bson.M{
+		{"transaction_id": fromID, "account_id": "checking-" + testUser.Username, "name": "TRANSFER TO SAVINGS", "amount": money.Amount(-20000)},
+		{"transaction_id": toID, "account_id": "savings-" + testUser.Username, "name": "TRANSFER FROM CHECKING", "amount": money.Amount(20000)},
+	} {
+		tr["user_id"] = testUser.ID
+		tr["category"] = "income"
+		tr["currency"] = money.DefaultCurrency
+		tr["date"] = day
+		_, err := testApp.Db.Transactions.InsertOne(ctx, tr)
+		assert.Nil(t, err)
+	}
+
+	detected, err := finances.DetectTransfers(ctx, testApp.Db, &testUser.ID, time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, detected)
+	var transfer *finances.Transfer
+	assert.Nil(t, testApp.Db.Transfers.FindOne(ctx, bson.M{"user_id": testUser.ID}).Decode(&transfer))
+	res := makeRequest(t, "PATCH", "/api/transfers/"+transfer.ID.Hex()+"/confirm", &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res = makeRequest(t, "DELETE", "/api/transactions/"+fromID, &accessToken, &refreshToken)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	count, err := testApp.Db.Transfers.CountDocuments(ctx, bson.M{"user_id": testUser.ID})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), count)
+	var remaining *finances.Transaction
+	assert.Nil(t, testApp.Db.Transactions.FindOne(ctx, bson.M{"transaction_id": toID}).Decode(&remaining))
+	assert.Nil(t, remaining.TransferID)
+}
@@ -0,0 +1,32 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/money"
+)
+
+func TestEffectiveBudgets(t *testing.T) {
+	t.Run("should prefer month specific budgets over recurring ones", func(t *testing.T) {
+		t.Parallel()
+
+		budgets := []*finances.Budget{
+			{Category: "groceries", Amount: 40000, Recurring: true},
+			{Category: "groceries", Amount: 60000, Month: 12, Year: 2022},
+			{Category: "bills", Amount: 20000, Month: 11, Year: 2022},
+			{Category: "vacation", Amount: 10000, Recurring: true},
+		}
+
+		december := finances.EffectiveBudgets(budgets, 12, 2022)
+		assert.Equal(t, 2, len(december))
+		assert.Equal(t, money.Amount(60000), december["groceries"].Amount)
+		assert.Equal(t, money.Amount(10000), december["vacation"].Amount)
+
+		november := finances.EffectiveBudgets(budgets, 11, 2022)
+		assert.Equal(t, 3, len(november))
+		assert.Equal(t, money.Amount(40000), november["groceries"].Amount)
+		assert.Equal(t, money.Amount(20000), november["bills"].Amount)
+	})
+}
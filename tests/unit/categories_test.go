@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/money"
+)
+
+func TestCategorySet(t *testing.T) {
+	t.Run("should look up kinds and fallback income category", func(t *testing.T) {
+		t.Parallel()
+
+		categories := finances.CategorySet{
+			"pets":                 {Name: "pets", Kind: finances.Expense},
+			"salary":               {Name: "salary", Kind: finances.Income},
+			finances.Uncategorized: {Name: finances.Uncategorized, Kind: finances.Expense},
+		}
+
+		assert.Equal(t, true, categories.Contains("pets"))
+		assert.Equal(t, false, categories.Contains("childcare"))
+		assert.Equal(t, finances.Income, categories.Kind("salary"))
+
+		// unknown categories count as expenses
+		assert.Equal(t, finances.Expense, categories.Kind("childcare"))
+
+		// no category named 'income' so first income kind is used
+		assert.Equal(t, "salary", categories.IncomeCategory())
+	})
+}
+
+func TestNormalizeAmount(t *testing.T) {
+	t.Run("should sign amounts by category kind", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, money.Amount(1250), finances.NormalizeAmount(-1250, finances.Income))
+		assert.Equal(t, money.Amount(-1250), finances.NormalizeAmount(1250, finances.Expense))
+		assert.Equal(t, money.Amount(1250), finances.NormalizeAmount(1250, finances.Ignore))
+		assert.Equal(t, money.Amount(-1250), finances.NormalizeAmount(-1250, finances.Ignore))
+	})
+}
+
+func TestCategoryHierarchy(t *testing.T) {
+	t.Run("should build paths and roll child totals into parents", func(t *testing.T) {
+		t.Parallel()
+
+		categories := finances.CategorySet{
+			"food":       {Name: "food", Kind: finances.Expense},
+			"groceries":  {Name: "groceries", Parent: "food", Kind: finances.Expense},
+			"restaurant": {Name: "restaurant", Parent: "food", Kind: finances.Expense},
+			"sushi":      {Name: "sushi", Parent: "restaurant", Kind: finances.Expense},
+			"bills":      {Name: "bills", Kind: finances.Expense},
+		}
+
+		assert.Equal(t, "food > restaurant > sushi", categories.Path("sushi"))
+		assert.Equal(t, []string{"restaurant", "food"}, categories.Ancestors("sushi"))
+
+		rolled := categories.Rollup(map[string]money.Amount{
+			"groceries":  -100,
+			"restaurant": -20,
+			"sushi":      -30,
+			"bills":      -50,
+		})
+		assert.Equal(t, money.Amount(-150), rolled["food"])
+		assert.Equal(t, money.Amount(-50), rolled["restaurant"])
+		assert.Equal(t, money.Amount(-50), rolled["bills"])
+	})
+
+	t.Run("should reject parents that create cycles", func(t *testing.T) {
+		t.Parallel()
+
+		categories := finances.CategorySet{
+			"food":      {Name: "food", Kind: finances.Expense},
+			"groceries": {Name: "groceries", Parent: "food", Kind: finances.Expense},
+		}
+
+		assert.Equal(t, true, categories.ValidParent("groceries", finances.Expense, "food"))
+		assert.Equal(t, true, categories.ValidParent("food", finances.Expense, ""))
+		assert.Equal(t, false, categories.ValidParent("food", finances.Expense, "groceries"))
+		assert.Equal(t, false, categories.ValidParent("food", finances.Expense, "food"))
+		assert.Equal(t, false, categories.ValidParent("food", finances.Expense, "missing"))
+	})
+
+	t.Run("should reject parents and children of another kind", func(t *testing.T) {
+		t.Parallel()
+
+		categories := finances.CategorySet{
+			"food":      {Name: "food", Kind: finances.Expense},
+			"groceries": {Name: "groceries", Parent: "food", Kind: finances.Expense},
+		}
+
+		assert.Equal(t, false, categories.ValidParent("cashback", finances.Income, "food"))
+		assert.Equal(t, false, categories.ValidParent("food", finances.Income, ""))
+	})
+}
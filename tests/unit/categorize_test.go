@@ -0,0 +1,42 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/merchant"
+	"github.com/tony-tvu/goexpense/money"
+)
+
+func TestCategorizer(t *testing.T) {
+	categorizer := &finances.Categorizer{
+		Categories: finances.CategorySet{
+			finances.Uncategorized: &finances.Category{Name: finances.Uncategorized, Kind: finances.Expense},
+			finances.Income:        &finances.Category{Name: finances.Income, Kind: finances.Income},
+			"shopping":             &finances.Category{Name: "shopping", Kind: finances.Expense},
+		},
+		Merchants: finances.MerchantSet{
+			merchant.Key("Amazon"): &finances.Merchant{Name: "Amazon", DefaultCategory: "shopping"},
+		},
+	}
+
+	t.Run("should use the merchant's default category for purchases", func(t *testing.T) {
+		t.Parallel()
+
+		transaction := &finances.Transaction{Name: "AMAZON", Amount: -3000}
+		categorizer.Categorize(transaction, "", "")
+		assert.Equal(t, "Amazon", transaction.Merchant)
+		assert.Equal(t, "shopping", transaction.Category)
+		assert.Equal(t, money.Amount(-3000), transaction.Amount)
+	})
+
+	t.Run("should keep refunds from a merchant with a default category as income", func(t *testing.T) {
+		t.Parallel()
+
+		transaction := &finances.Transaction{Name: "AMAZON", Amount: 3000}
+		categorizer.Categorize(transaction, "", "")
+		assert.Equal(t, finances.Income, transaction.Category)
+		assert.Equal(t, money.Amount(3000), transaction.Amount)
+	})
+}
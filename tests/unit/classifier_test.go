@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/classifier"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/money"
+)
+
+func TestClassifier(t *testing.T) {
+	examples := []*classifier.Example{
+		{Name: "STARBUCKS STORE #1234", Amount: -550, Category: "restaurants"},
+		{Name: "Starbucks Coffee", Amount: -475, Category: "restaurants"},
+		{Name: "CHIPOTLE 0891", Amount: -1250, Category: "restaurants"},
+		{Name: "SHELL OIL 5721", Amount: -4500, Category: "transportation"},
+		{Name: "Chevron Gas", Amount: -5200, Category: "transportation"},
+		{Name: "SHELL SERVICE STATION", Amount: -3800, Category: "transportation"},
+	}
+
+	t.Run("should tokenize names without numbers or single characters", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, []string{"starbucks", "store"}, classifier.Tokenize("STARBUCKS STORE #1234 x"))
+		assert.Equal(t, []string{"7eleven"}, classifier.Tokenize("7eleven"))
+		assert.Equal(t, []string{}, classifier.Tokenize("1234"))
+	})
+
+	t.Run("should bucket amounts by size and direction", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, "amount:out:0", classifier.AmountBucket(-550))
+		assert.Equal(t, "amount:out:3", classifier.AmountBucket(-5200))
+		assert.Equal(t, "amount:in:7", classifier.AmountBucket(money.Amount(250000)))
+	})
+
+	t.Run("should predict the most likely category", func(t *testing.T) {
+		t.Parallel()
+
+		model := classifier.Train(examples)
+		assert.Equal(t, 6, model.Documents)
+		assert.Len(t, model.Classes, 2)
+
+		category, confidence := model.Predict("STARBUCKS STORE #9876", -600)
+		assert.Equal(t, "restaurants", category)
+		assert.Greater(t, confidence, 0.9)
+
+		category, _ = model.Predict("Shell 1111", -4000)
+		assert.Equal(t, "transportation", category)
+	})
+
+	t.Run("should not predict without known features", func(t *testing.T) {
+		t.Parallel()
+
+		category, confidence := (&classifier.Model{}).Predict("starbucks", -500)
+		assert.Equal(t, "", category)
+		assert.Equal(t, float64(0), confidence)
+
+		var model *classifier.Model
+		category, _ = model.Predict("starbucks", -500)
+		assert.Equal(t, "", category)
+
+		// a known amount isn't enough without a known word
+		category, confidence = classifier.Train(examples).Predict("WALGREENS 4421", -550)
+		assert.Equal(t, "", category)
+		assert.Equal(t, float64(0), confidence)
+
+		// a model that only knows one category can't tell categories apart
+		category, confidence = classifier.Train(examples[:3]).Predict("Starbucks", -500)
+		assert.Equal(t, "", category)
+		assert.Equal(t, float64(0), confidence)
+	})
+
+	t.Run("should only use confident predictions of existing categories", func(t *testing.T) {
+		t.Parallel()
+
+		model := classifier.Train(examples)
+		categories := finances.CategorySet{"restaurants": &finances.Category{Name: "restaurants", Kind: finances.Expense}}
+
+		category, confidence, ok := finances.PredictCategory(model, categories, "Starbucks", -500)
+		assert.True(t, ok)
+		assert.Equal(t, "restaurants", category)
+		assert.GreaterOrEqual(t, confidence, finances.MinConfidence)
+
+		_, _, ok = finances.PredictCategory(model, categories, "Shell", -4000)
+		assert.False(t, ok)
+	})
+}
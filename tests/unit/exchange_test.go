@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/exchange"
+	"github.com/tony-tvu/goexpense/money"
+)
+
+func TestParseExchangeRates(t *testing.T) {
+	t.Run("should parse csv rates", func(t *testing.T) {
+		t.Parallel()
+
+		rates, err := exchange.ParseCSV(strings.NewReader("date,base,currency,rate\n2022-03-01,usd,eur,0.9\n2022-03-02,USD,CAD,1.27\n"))
+		assert.NoError(t, err)
+		assert.Len(t, rates, 2)
+		assert.Equal(t, "USD", rates[0].Base)
+		assert.Equal(t, "EUR", rates[0].Currency)
+		assert.Equal(t, time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), rates[0].Date)
+		assert.Equal(t, 1.27, rates[1].Rate)
+
+		_, err = exchange.ParseCSV(strings.NewReader("date,currency,rate\n2022-03-01,EUR,0.9\n"))
+		assert.Error(t, err)
+	})
+
+	t.Run("should parse ecb reference rates", func(t *testing.T) {
+		t.Parallel()
+
+		xml := `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2022-03-02">
+			<Cube currency="USD" rate="1.1120"/>
+			<Cube currency="GBP" rate="0.83"/>
+		</Cube>
+		<Cube time="2022-03-01">
+			<Cube currency="USD" rate="1.1"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+		rates, err := exchange.ParseECB(strings.NewReader(xml))
+		assert.NoError(t, err)
+		assert.Len(t, rates, 3)
+		assert.Equal(t, "EUR", rates[0].Base)
+		assert.Equal(t, "USD", rates[0].Currency)
+		assert.Equal(t, 1.112, rates[0].Rate)
+		assert.Equal(t, time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), rates[2].Date)
+	})
+}
+
+func TestConvertCurrency(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2022, 3, d, 0, 0, 0, 0, time.UTC) }
+	table := exchange.NewTable("EUR", []*exchange.Rate{
+		{Base: "EUR", Currency: "USD", Date: day(3), Rate: 1.25},
+		{Base: "EUR", Currency: "USD", Date: day(1), Rate: 1.0},
+		{Base: "EUR", Currency: "GBP", Date: day(1), Rate: 0.8},
+	})
+
+	t.Run("should use the latest rate on or before the date", func(t *testing.T) {
+		t.Parallel()
+
+		amount, ok := table.Convert(money.Amount(1000), "EUR", "USD", day(2))
+		assert.True(t, ok)
+		assert.Equal(t, money.Amount(1000), amount)
+
+		amount, ok = table.Convert(money.Amount(1000), "EUR", "USD", day(5))
+		assert.True(t, ok)
+		assert.Equal(t, money.Amount(1250), amount)
+
+		// dates before the first rate use the earliest one
+		amount, ok = table.Convert(money.Amount(1000), "USD", "EUR", day(1).AddDate(0, -1, 0))
+		assert.True(t, ok)
+		assert.Equal(t, money.Amount(1000), amount)
+	})
+
+	t.Run("should cross convert through the base currency", func(t *testing.T) {
+		t.Parallel()
+
+		amount, ok := table.Convert(money.Amount(-2500), "USD", "GBP", day(3))
+		assert.True(t, ok)
+		assert.Equal(t, money.Amount(-1600), amount)
+	})
+
+	t.Run("should report unknown currencies", func(t *testing.T) {
+		t.Parallel()
+
+		amount, ok := table.Convert(money.Amount(500), "JPY", "EUR", day(3))
+		assert.False(t, ok)
+		assert.Equal(t, money.Amount(500), amount)
+
+		amount, ok = table.Convert(money.Amount(500), "JPY", "JPY", day(3))
+		assert.True(t, ok)
+		assert.Equal(t, money.Amount(500), amount)
+	})
+}
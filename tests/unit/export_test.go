@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/exporter"
+	"github.com/tony-tvu/goexpense/importer"
+	"github.com/tony-tvu/goexpense/money"
+)
+
+func exportRecords(t *testing.T, format string, records []*exporter.Record, accounts map[string]*exporter.Account) string {
+	t.Helper()
+	b := new(bytes.Buffer)
+	from := time.Date(2022, time.March, 1, 0, 0, 0, 0, time.UTC)
+	writer, err := exporter.NewWriter(format, b, accounts, from, from.AddDate(0, 1, -1))
+	assert.Nil(t, err)
+	for _, record := range records {
+		assert.Nil(t, writer.Write(record))
+	}
+	assert.Nil(t, writer.Close())
+	return b.String()
+}
+
+func TestExport(t *testing.T) {
+	day := time.Date(2022, time.March, 14, 0, 0, 0, 0, time.UTC)
+	records := []*exporter.Record{
+		{
+			TransactionID: "t1", Date: day, Name: "Trader Joe's & Co", Merchant: "Trader Joe's", Category: "groceries",
+			Amount: -4217, Currency: "USD", Tags: []string{"weekly"}, AccountID: "checking", AccountName: "Checking",
+			AccountType: "depository", Institution: "Chase",
+		},
+		{
+			TransactionID: "t2", Date: day.AddDate(0, 0, 1), Name: "Costco", Category: "groceries", Amount: -10000,
+			Currency: "USD", Tags: []string{}, AccountID: "credit", AccountName: "Sapphire", AccountType: "credit",
+			Splits: []*exporter.Split{{Category: "groceries", Amount: -6000}, {Category: "bills", Amount: -4000, Note: "gas"}},
+		},
+	}
+	balance := money.Amount(120000)
+	accounts := map[string]*exporter.Account{
+		"checking": {ID: "checking", Type: "depository", Currency: "USD", Balance: &balance},
+		"credit":   {ID: "credit", Type: "credit", Currency: "USD"},
+	}
+
+	t.Run("should write csv with a header", func(t *testing.T) {
+		t.Parallel()
+
+		lines, err := csv.NewReader(strings.NewReader(exportRecords(t, exporter.CSV, records, accounts))).ReadAll()
+		assert.Nil(t, err)
+		assert.Len(t, lines, 3)
+		assert.Equal(t, []string{"2022-03-14", "Trader Joe's & Co", "Trader Joe's", "groceries", "-42.17", "USD", "weekly", "", "Checking", "Chase", "t1"}, lines[1])
+		assert.Equal(t, "groceries; bills", lines[2][3])
+	})
+
+	t.Run("should write a json array", func(t *testing.T) {
+		t.Parallel()
+
+		var exported []*exporter.Record
+		assert.Nil(t, json.Unmarshal([]byte(exportRecords(t, exporter.JSON, records, accounts)), &exported))
+		assert.Len(t, exported, 2)
+		assert.Equal(t, "Chase", exported[0].Institution)
+		assert.Len(t, exported[1].Splits, 2)
+
+		assert.Equal(t, "[]\n", exportRecords(t, exporter.JSON, nil, accounts))
+	})
+
+	t.Run("should write ofx that imports back", func(t *testing.T) {
+		t.Parallel()
+
+		statements, err := importer.ParseOFX(strings.NewReader(exportRecords(t, exporter.OFX, records, accounts)))
+		assert.Nil(t, err)
+		assert.Len(t, statements, 2)
+		assert.Equal(t, "checking", statements[0].AccountID)
+		assert.Equal(t, money.Amount(120000), *statements[0].Balance)
+		assert.Equal(t, "t1", statements[0].Transactions[0].FITID)
+		assert.Equal(t, "Trader Joe's & Co", statements[0].Transactions[0].Name)
+		assert.Equal(t, "groceries #weekly", statements[0].Transactions[0].Memo)
+		assert.Equal(t, "credit", statements[1].AccountID)
+		assert.Nil(t, statements[1].Balance)
+		assert.Equal(t, money.Amount(-10000), statements[1].Transactions[0].Amount)
+	})
+
+	t.Run("should write an ofx document without transactions", func(t *testing.T) {
+		t.Parallel()
+
+		ofx := exportRecords(t, exporter.OFX, nil, accounts)
+		assert.Contains(t, ofx, "<OFX>\n<SIGNONMSGSRSV1>")
+		assert.True(t, strings.HasSuffix(ofx, "</OFX>\n"))
+	})
+
+	t.Run("should write qif with an account block per account", func(t *testing.T) {
+		t.Parallel()
+
+		qif := exportRecords(t, exporter.QIF, records, accounts)
+		assert.Contains(t, qif, "!Account\nNChecking\nTBank\nDChase\n^\n!Type:Bank\nD03/14/2022\nT-42.17\nPTrader Joe's & Co\nLgroceries\nM#weekly\n^\n")
+		assert.Contains(t, qif, "!Type:CCard\n")
+		assert.Contains(t, qif, "Sgroceries\n$-60.00\nSbills\n$-40.00\nEgas\n")
+	})
+
+	t.Run("should reject unknown formats", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := exporter.NewWriter("xls", new(bytes.Buffer), nil, time.Time{}, time.Time{})
+		assert.ErrorIs(t, err, exporter.ErrUnknownFormat)
+	})
+}
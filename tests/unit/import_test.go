@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/importer"
+	"github.com/tony-tvu/goexpense/money"
+)
+
+func TestParseCSV(t *testing.T) {
+	t.Run("should parse a signed amount column", func(t *testing.T) {
+		t.Parallel()
+
+		file := "\ufeffPosted Date,Payee,Amount\n" +
+			"03/14/2022,  WHOLE FOODS   #123 ,\"-1,234.50\"\n" +
+			"03/15/2022,PAYROLL,2000\n" +
+			"\n" +
+			"not a date,COFFEE,-4.00\n"
+		rows, rowErrors, err := importer.ParseCSV(strings.NewReader(file), &importer.Mapping{
+			DateColumn:        "posted date",
+			DateFormat:        "MM/DD/YYYY",
+			DescriptionColumn: "Payee",
+			AmountColumn:      "Amount",
+		})
+		assert.Nil(t, err)
+		assert.Len(t, rows, 2)
+		assert.Equal(t, time.Date(2022, time.March, 14, 0, 0, 0, 0, time.UTC), rows[0].Date)
+		assert.Equal(t, "WHOLE FOODS #123", rows[0].Description)
+		assert.Equal(t, money.Amount(-123450), rows[0].Amount)
+		assert.Equal(t, money.Amount(200000), rows[1].Amount)
+		assert.Len(t, rowErrors, 1)
+		assert.Equal(t, 4, rowErrors[0].Line)
+	})
+
+	t.Run("should read debit and credit columns", func(t *testing.T) {
+		t.Parallel()
+
+		file := "Date;Description;Debit;Credit\n2022-01-02;Rent;1500.00;\n2022-01-03;Refund;;(25.00)\n"
+		rows, rowErrors, err := importer.ParseCSV(strings.NewReader(file), &importer.Mapping{
+			DateColumn:        "Date",
+			DateFormat:        "YYYY-MM-DD",
+			DescriptionColumn: "Description",
+			DebitColumn:       "Debit",
+			CreditColumn:      "Credit",
+			Delimiter:         ";",
+		})
+		assert.Nil(t, err)
+		assert.Len(t, rowErrors, 0)
+		assert.Equal(t, money.Amount(-150000), rows[0].Amount)
+		assert.Equal(t, money.Amount(2500), rows[1].Amount)
+	})
+
+	t.Run("should flip amounts when expenses are positive", func(t *testing.T) {
+		t.Parallel()
+
+		file := "Date,Description,Amount\n2 Jan 2022,Netflix,15.99\n2 Jan 2022,Payment,-100\n"
+		rows, _, err := importer.ParseCSV(strings.NewReader(file), &importer.Mapping{
+			DateColumn:        "Date",
+			DateFormat:        "D MMM YYYY",
+			DescriptionColumn: "Description",
+			AmountColumn:      "Amount",
+			SignConvention:    importer.ExpensesPositive,
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, money.Amount(-1599), rows[0].Amount)
+		assert.Equal(t, money.Amount(10000), rows[1].Amount)
+	})
+
+	t.Run("should reject mappings and files that don't fit", func(t *testing.T) {
+		t.Parallel()
+
+		mapping := &importer.Mapping{DateColumn: "Date", DateFormat: "YYYY-MM-DD", DescriptionColumn: "Description"}
+		assert.ErrorIs(t, mapping.Validate(), importer.ErrInvalidMapping)
+		mapping.AmountColumn, mapping.DebitColumn, mapping.CreditColumn = "Amount", "Debit", "Credit"
+		assert.ErrorIs(t, mapping.Validate(), importer.ErrInvalidMapping)
+
+		mapping.DebitColumn, mapping.CreditColumn = "", ""
+		_, _, err := importer.ParseCSV(strings.NewReader("Date,Memo,Amount\n"), mapping)
+		assert.ErrorIs(t, err, importer.ErrMissingColumn)
+	})
+}
+
+func TestParseAmount(t *testing.T) {
+	for input, expected := range map[string]money.Amount{
+		"$1,234.56": 123456,
+		"-12.00":    -1200,
+		"(12.00)":   -1200,
+		"12.00-":    -1200,
+		"+3":        300,
+	} {
+		amount, err := importer.ParseAmount(input)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, amount, input)
+	}
+	_, err := importer.ParseAmount("twelve")
+	assert.NotNil(t, err)
+}
+
+func TestImportTransactionID(t *testing.T) {
+	row := &importer.Row{Date: time.Date(2022, time.March, 14, 0, 0, 0, 0, time.UTC), Description: "Coffee", Amount: -400}
+	assert.Equal(t, finances.ImportTransactionID("checking", row, 0), finances.ImportTransactionID("checking", row, 0))
+	assert.NotEqual(t, finances.ImportTransactionID("checking", row, 0), finances.ImportTransactionID("checking", row, 1))
+	assert.NotEqual(t, finances.ImportTransactionID("checking", row, 0), finances.ImportTransactionID("savings", row, 0))
+}
@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/merchant"
+)
+
+func TestNormalizeMerchant(t *testing.T) {
+	t.Run("should strip processor prefixes, store numbers and locations", func(t *testing.T) {
+		t.Parallel()
+
+		for descriptor, expected := range map[string]string{
+			"SQ *BLUE BOTTLE 0423 OAKLAND CA":     "Blue Bottle",
+			"TST* SHAKE SHACK 123":                "Shake Shack",
+			"PAYPAL *SPOTIFY":                     "Spotify",
+			"AMAZON.COM*2K4R81":                   "Amazon.com",
+			"TRADER JOE'S #123":                   "Trader Joe's",
+			"POS PURCHASE 04/23 SAFEWAY 1234":     "Safeway",
+			"UBER   *TRIP":                        "Uber",
+			"7-ELEVEN 33912":                      "7-Eleven",
+			"BLUE BOTTLE OAKLAND CA":              "Blue Bottle",
+			"WHOLE FOODS MARKET SAN FRANCISCO CA": "Whole Foods Market",
+			"SHAKE SHACK SALT LAKE CITY UT":       "Shake Shack",
+			"STARBUCKS NEW YORK NY":               "Starbucks",
+			"Netflix":                             "Netflix",
+		} {
+			assert.Equal(t, expected, merchant.Normalize(descriptor), descriptor)
+		}
+	})
+
+	t.Run("should keep what is left of descriptors that are only a reference", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, "4411", merchant.Normalize("#4411"))
+		assert.Equal(t, "", merchant.Normalize("  "))
+	})
+
+	t.Run("should match merchants regardless of case and spacing", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, merchant.Key("Blue  Bottle"), merchant.Key("BLUE BOTTLE"))
+		assert.Equal(t, "Blue Bottle Coffee", merchant.Title("BLUE BOTTLE COFFEE"))
+	})
+}
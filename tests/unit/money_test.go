@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/money"
+)
+
+func TestParseMoney(t *testing.T) {
+	t.Run("should parse decimal strings into minor units exactly", func(t *testing.T) {
+		t.Parallel()
+
+		cases := map[string]money.Amount{
+			"12.34":         1234,
+			"-12.34":        -1234,
+			"0.1":           10,
+			".5":            50,
+			"100":           10000,
+			" 7.00 ":        700,
+			"+3.05":         305,
+			"1.500":         150,
+			"16777217.01":   1677721701,
+			"9999999999.99": 999999999999,
+		}
+		for input, expected := range cases {
+			amount, err := money.Parse(input)
+			assert.NoError(t, err, input)
+			assert.Equal(t, expected, amount, input)
+		}
+
+		for _, input := range []string{"", "-", "abc", "1.2.3", "1,000.00", "1e3", "1.005", "-1.004", "0.125"} {
+			_, err := money.Parse(input)
+			assert.Error(t, err, input)
+		}
+	})
+}
+
+func TestMoneyJSON(t *testing.T) {
+	t.Run("should marshal amounts as decimal numbers", func(t *testing.T) {
+		t.Parallel()
+
+		b, err := json.Marshal(map[string]money.Amount{"a": -1230, "b": 5})
+		assert.NoError(t, err)
+		assert.Equal(t, `{"a":-12.30,"b":0.05}`, string(b))
+
+		var decoded struct {
+			A money.Amount `json:"a"`
+			B money.Amount `json:"b"`
+			C money.Amount `json:"c"`
+		}
+		err = json.Unmarshal([]byte(`{"a":-12.3,"b":"0.05","c":1e2}`), &decoded)
+		assert.NoError(t, err)
+		assert.Equal(t, money.Amount(-1230), decoded.A)
+		assert.Equal(t, money.Amount(5), decoded.B)
+		assert.Equal(t, money.Amount(10000), decoded.C)
+
+		// three decimal currencies aren't rounded
+		err = json.Unmarshal([]byte(`{"a":1.234}`), &decoded)
+		assert.Error(t, err)
+	})
+}
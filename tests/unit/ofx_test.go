@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/importer"
+	"github.com/tony-tvu/goexpense/money"
+)
+
+const sgmlStatement = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0<SEVERITY>INFO</STATUS><DTSERVER>20220401120000</SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1>
+<STMTTRNRS>
+<TRNUID>1
+<STMTRS>
+<CURDEF>USD
+<BANKACCTFROM>
+<BANKID>121000248
+<ACCTID>000123456789
+<ACCTTYPE>CHECKING
+</BANKACCTFROM>
+<BANKTRANLIST>
+<DTSTART>20220301
+<DTEND>20220331
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20220314120000.000[-5:EST]
+<TRNAMT>-42.17
+<FITID>2022031401
+<NAME>TRADER JOE&amp;S #552
+<MEMO>POS PURCHASE
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20220315
+<TRNAMT>1500,00
+<FITID>2022031502
+<MEMO>DIRECT DEPOSIT ACME
+</STMTTRN>
+</BANKTRANLIST>
+<LEDGERBAL>
+<BALAMT>2310.55
+<DTASOF>20220331
+</LEDGERBAL>
+</STMTRS>
+</STMTTRNRS>
+</BANKMSGSRSV1>
+</OFX>
+`
+
+const xmlStatement = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+  <CREDITCARDMSGSRSV1>
+    <CCSTMTTRNRS>
+      <CCSTMTRS>
+        <CURDEF>usd</CURDEF>
+        <CCACCTFROM><ACCTID>4111111111111111</ACCTID></CCACCTFROM>
+        <BANKTRANLIST>
+          <STMTTRN>
+            <TRNTYPE>DEBIT</TRNTYPE>
+            <DTPOSTED>20220502</DTPOSTED>
+            <TRNAMT>-15.99</TRNAMT>
+            <FITID>abc-1</FITID>
+            <PAYEE><NAME>NETFLIX.COM</NAME></PAYEE>
+            <MEMO></MEMO>
+          </STMTTRN>
+        </BANKTRANLIST>
+        <LEDGERBAL><BALAMT>-215.40</BALAMT><DTASOF>20220531</DTASOF></LEDGERBAL>
+      </CCSTMTRS>
+    </CCSTMTTRNRS>
+  </CREDITCARDMSGSRSV1>
+</OFX>
+`
+
+func TestParseOFX(t *testing.T) {
+	t.Run("should parse sgml statements without closing tags", func(t *testing.T) {
+		t.Parallel()
+
+		statements, err := importer.ParseOFX(strings.NewReader(sgmlStatement))
+		assert.Nil(t, err)
+		assert.Len(t, statements, 1)
+		statement := statements[0]
+		assert.Equal(t, "000123456789", statement.AccountID)
+		assert.Equal(t, "USD", statement.Currency)
+		assert.Len(t, statement.Transactions, 2)
+
+		purchase := statement.Transactions[0]
+		assert.Equal(t, "2022031401", purchase.FITID)
+		assert.Equal(t, "TRADER JOE&S #552", purchase.Name)
+		assert.Equal(t, "POS PURCHASE", purchase.Memo)
+		assert.Equal(t, money.Amount(-4217), purchase.Amount)
+		assert.Equal(t, time.Date(2022, time.March, 14, 0, 0, 0, 0, time.UTC), purchase.Date)
+
+		deposit := statement.Transactions[1]
+		assert.Equal(t, "DIRECT DEPOSIT ACME", deposit.Name)
+		assert.Equal(t, money.Amount(150000), deposit.Amount)
+
+		assert.Equal(t, money.Amount(231055), *statement.Balance)
+		assert.Equal(t, time.Date(2022, time.March, 31, 0, 0, 0, 0, time.UTC), statement.BalanceDate)
+	})
+
+	t.Run("should parse xml credit card statements", func(t *testing.T) {
+		t.Parallel()
+
+		statements, err := importer.ParseOFX(strings.NewReader(xmlStatement))
+		assert.Nil(t, err)
+		assert.Len(t, statements, 1)
+		statement := statements[0]
+		assert.Equal(t, "4111111111111111", statement.AccountID)
+		assert.Equal(t, "USD", statement.Currency)
+		assert.Len(t, statement.Transactions, 1)
+		assert.Equal(t, "NETFLIX.COM", statement.Transactions[0].Name)
+		assert.Equal(t, "", statement.Transactions[0].Memo)
+		assert.Equal(t, money.Amount(-21540), *statement.Balance)
+	})
+
+	t.Run("should parse empty elements as values", func(t *testing.T) {
+		t.Parallel()
+
+		xml := strings.Replace(xmlStatement, "<MEMO></MEMO>", "<MEMO/>", 1)
+		xml = strings.Replace(xml, "<FITID>abc-1</FITID>", "<MEMO />\n<FITID>abc-1</FITID>", 1)
+		statements, err := importer.ParseOFX(strings.NewReader(xml))
+		assert.Nil(t, err)
+		assert.Len(t, statements, 1)
+		assert.Len(t, statements[0].Transactions, 1)
+		assert.Equal(t, "abc-1", statements[0].Transactions[0].FITID)
+		assert.Equal(t, money.Amount(-21540), *statements[0].Balance)
+
+		sgml := strings.Replace(sgmlStatement, "<NAME>TRADER JOE&amp;S #552", "<NAME>", 1)
+		sgml = strings.Replace(sgml, "<TRNAMT>-42.17\n<FITID>2022031401", "<TRNAMT>-42.17\n<CHECKNUM>\n<FITID>2022031401", 1)
+		statements, err = importer.ParseOFX(strings.NewReader(sgml))
+		assert.Nil(t, err)
+		assert.Len(t, statements, 1)
+		assert.Len(t, statements[0].Transactions, 2)
+		purchase := statements[0].Transactions[0]
+		assert.Equal(t, "2022031401", purchase.FITID)
+		assert.Equal(t, "POS PURCHASE", purchase.Name)
+		assert.Equal(t, "POS PURCHASE", purchase.Memo)
+		assert.Equal(t, money.Amount(231055), *statements[0].Balance)
+	})
+
+	t.Run("should reject files that aren't statements", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := importer.ParseOFX(strings.NewReader("Date,Description,Amount\n"))
+		assert.ErrorIs(t, err, importer.ErrInvalidOFX)
+		_, err = importer.ParseOFX(strings.NewReader("<OFX><STMTRS><BANKTRANLIST><STMTTRN><TRNAMT>1.00</STMTTRN></BANKTRANLIST></STMTRS></OFX>"))
+		assert.ErrorIs(t, err, importer.ErrInvalidOFX)
+	})
+}
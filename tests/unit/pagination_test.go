@@ -0,0 +1,34 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPageCursor(t *testing.T) {
+	t.Run("should encode and decode page cursors", func(t *testing.T) {
+		t.Parallel()
+
+		transaction := &finances.Transaction{
+			ID:     primitive.NewObjectID(),
+			Name:   "TRADER JOE'S #123",
+			Amount: -4567,
+			Date:   time.Date(2022, time.October, 2, 0, 0, 0, 0, time.UTC),
+		}
+		encoded := finances.NewPageCursor(transaction).Encode()
+
+		decoded, err := finances.DecodePageCursor(encoded)
+		assert.NoError(t, err)
+		assert.Equal(t, transaction.ID, decoded.ID)
+		assert.Equal(t, transaction.Name, decoded.Name)
+		assert.Equal(t, transaction.Amount, decoded.Amount)
+		assert.Equal(t, true, transaction.Date.Equal(decoded.Date))
+
+		_, err = finances.DecodePageCursor("not a cursor")
+		assert.Error(t, err)
+	})
+}
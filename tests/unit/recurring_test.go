@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/recurring"
+)
+
+func charges(start time.Time, years, months, days int, amounts ...money.Amount) []*recurring.Charge {
+	result := []*recurring.Charge{}
+	for i, amount := range amounts {
+		result = append(result, &recurring.Charge{
+			TransactionID: string(rune('a' + i)),
+			Date:          start.AddDate(years*i, months*i, days*i),
+			Amount:        amount,
+		})
+	}
+	return result
+}
+
+func TestDetectRecurring(t *testing.T) {
+	start := time.Date(2022, time.January, 15, 0, 0, 0, 0, time.UTC)
+
+	t.Run("should detect monthly charges and flag a price increase", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Date(2022, time.May, 20, 0, 0, 0, 0, time.UTC)
+		series := recurring.Detect(charges(start, 0, 1, 0, -1549, -1549, -1549, -1799), now)
+		assert.Len(t, series, 1)
+		assert.Equal(t, recurring.Monthly, series[0].Cadence)
+		assert.Equal(t, money.Amount(-1611), series[0].AverageAmount)
+		assert.Equal(t, time.Date(2022, time.May, 15, 0, 0, 0, 0, time.UTC), series[0].NextDate)
+		assert.True(t, series[0].PriceIncrease)
+		assert.Equal(t, 0, series[0].MissedCharges)
+		assert.True(t, series[0].Active)
+	})
+
+	t.Run("should detect weekly and annual charges", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Date(2022, time.February, 6, 0, 0, 0, 0, time.UTC)
+		series := recurring.Detect(charges(start, 0, 0, 7, -1000, -1000, -1000), now)
+		assert.Len(t, series, 1)
+		assert.Equal(t, recurring.Weekly, series[0].Cadence)
+
+		now = time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC)
+		series = recurring.Detect(charges(start.AddDate(-1, 0, 0), 1, 0, 0, -9900, -9900), now)
+		assert.Len(t, series, 1)
+		assert.Equal(t, recurring.Annual, series[0].Cadence)
+		assert.Equal(t, time.Date(2023, time.January, 15, 0, 0, 0, 0, time.UTC), series[0].NextDate)
+	})
+
+	t.Run("should count missed charges and end series", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Date(2022, time.May, 10, 0, 0, 0, 0, time.UTC)
+		series := recurring.Detect(charges(start, 0, 1, 0, -500, -500, -500), now)
+		assert.Len(t, series, 1)
+		assert.Equal(t, 1, series[0].MissedCharges)
+		assert.True(t, series[0].Active)
+
+		now = time.Date(2022, time.December, 1, 0, 0, 0, 0, time.UTC)
+		series = recurring.Detect(charges(start, 0, 1, 0, -500, -500, -500), now)
+		assert.False(t, series[0].Active)
+	})
+
+	t.Run("should separate series with different amounts", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Date(2022, time.April, 20, 0, 0, 0, 0, time.UTC)
+		mixed := append(charges(start, 0, 1, 0, -299, -299, -299), charges(start.AddDate(0, 0, 3), 0, 1, 0, -1099, -1099, -1099)...)
+		series := recurring.Detect(mixed, now)
+		assert.Len(t, series, 2)
+	})
+
+	t.Run("should ignore irregular charges", func(t *testing.T) {
+		t.Parallel()
+
+		irregular := []*recurring.Charge{
+			{Date: start, Amount: -4000},
+			{Date: start.AddDate(0, 0, 3), Amount: -4200},
+			{Date: start.AddDate(0, 0, 50), Amount: -3900},
+			{Date: start.AddDate(0, 0, 61), Amount: -4100},
+		}
+		assert.Len(t, recurring.Detect(irregular, start.AddDate(0, 3, 0)), 0)
+		assert.Len(t, recurring.Detect(irregular[:1], start), 0)
+	})
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/money"
+)
+
+func TestMatchRefund(t *testing.T) {
+	day := time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC)
+	transaction := func(id, merchant string, amount money.Amount, days int) *finances.Transaction {
+		return &finances.Transaction{TransactionID: id, Merchant: merchant, Amount: amount, Date: day.AddDate(0, 0, days)}
+	}
+
+	t.Run("should prefer an exact amount over a more recent purchase", func(t *testing.T) {
+		t.Parallel()
+
+		refund := transaction("refund", "Amazon", 2500, 10)
+		purchase := finances.MatchRefund(refund, []*finances.Transaction{
+			transaction("exact", "amazon", -2500, 0),
+			transaction("larger", "Amazon", -8000, 5),
+			transaction("other", "Target", -2500, 8),
+		}, nil)
+		assert.Equal(t, "exact", purchase.TransactionID)
+	})
+
+	t.Run("should skip purchases already refunded, too old or after the refund", func(t *testing.T) {
+		t.Parallel()
+
+		refund := transaction("refund", "Amazon", 2500, 100)
+		purchase := finances.MatchRefund(refund, []*finances.Transaction{
+			transaction("old", "Amazon", -2500, 0),
+			transaction("refunded", "Amazon", -3000, 50),
+			transaction("later", "Amazon", -2500, 101),
+		}, map[string]money.Amount{"refunded": 1000})
+		assert.Nil(t, purchase)
+	})
+}
+
+func TestMatchReimbursement(t *testing.T) {
+	day := time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC)
+	expense := func(id string, amount money.Amount, days int) *finances.Transaction {
+		return &finances.Transaction{
+			TransactionID: id,
+			Amount:        amount,
+			Date:          day.AddDate(0, 0, days),
+			Reimbursement: &finances.Reimbursement{Payer: "Acme", Status: finances.ReimbursementOwed},
+		}
+	}
+	owed := []*finances.Transaction{expense("hotel", -30000, 0), expense("flight", -45000, 1), expense("taxi", -4000, 20)}
+
+	t.Run("should settle every expense up to the payment when it covers their total", func(t *testing.T) {
+		t.Parallel()
+
+		payment := &finances.Transaction{Name: "ACME CORP EXPENSES", Amount: 75000, Date: day.AddDate(0, 0, 10)}
+		expenses := finances.MatchReimbursement(payment, owed)
+		assert.Len(t, expenses, 2)
+	})
+
+	t.Run("should settle a single expense of the same amount", func(t *testing.T) {
+		t.Parallel()
+
+		payment := &finances.Transaction{Name: "Acme payroll", Amount: 45000, Date: day.AddDate(0, 0, 10)}
+		expenses := finances.MatchReimbursement(payment, owed)
+		assert.Len(t, expenses, 1)
+		assert.Equal(t, "flight", expenses[0].TransactionID)
+	})
+
+	t.Run("should not settle with payments from someone else, in another currency or that don't add up", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Len(t, finances.MatchReimbursement(&finances.Transaction{Name: "Venmo", Amount: 45000, Date: day.AddDate(0, 0, 10)}, owed), 0)
+		assert.Len(t, finances.MatchReimbursement(&finances.Transaction{Name: "Acme", Amount: 1000, Date: day.AddDate(0, 0, 30)}, owed), 0)
+		assert.Len(t, finances.MatchReimbursement(&finances.Transaction{Name: "Acme", Amount: 45000, Currency: "EUR", Date: day.AddDate(0, 0, 10)}, owed), 0)
+	})
+}
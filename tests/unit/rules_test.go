@@ -0,0 +1,186 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/money"
+	"github.com/tony-tvu/goexpense/rules"
+)
+
+func TestRuleValidate(t *testing.T) {
+	t.Run("should reject malformed rules", func(t *testing.T) {
+		t.Parallel()
+
+		setCategory := []*rules.Action{{Type: rules.SetCategory, Value: "groceries"}}
+		invalid := []*rules.Rule{
+			{Conditions: []*rules.Condition{{Field: rules.FieldName, Op: rules.OpContains, Value: "x"}}},
+			{Actions: setCategory},
+			{Match: "some", Conditions: []*rules.Condition{{Field: rules.FieldName, Op: rules.OpContains, Value: "x"}}, Actions: setCategory},
+			{Conditions: []*rules.Condition{{Field: rules.FieldName, Op: rules.OpRegex, Value: "("}}, Actions: setCategory},
+			{Conditions: []*rules.Condition{{Field: rules.FieldAmount, Op: rules.OpContains, Value: "10"}}, Actions: setCategory},
+			{Conditions: []*rules.Condition{{Field: rules.FieldDay, Op: rules.OpEq, Value: "32"}}, Actions: setCategory},
+			{Conditions: []*rules.Condition{{Field: rules.FieldSign, Op: rules.OpIs, Value: "zero"}}, Actions: setCategory},
+			{Conditions: []*rules.Condition{{Field: "memo", Op: rules.OpContains, Value: "x"}}, Actions: setCategory},
+			{Conditions: []*rules.Condition{{Field: rules.FieldName, Op: rules.OpContains, Value: "x"}}, Actions: []*rules.Action{{Type: rules.Rename}}},
+		}
+		for _, rule := range invalid {
+			assert.Error(t, rule.Validate())
+		}
+
+		valid := &rules.Rule{
+			Conditions: []*rules.Condition{{Field: rules.FieldName, Op: rules.OpRegex, Value: "^uber"}},
+			Actions:    []*rules.Action{{Type: rules.MarkIgnore}},
+		}
+		assert.NoError(t, valid.Validate())
+		assert.Equal(t, rules.MatchAll, valid.Match)
+	})
+}
+
+func TestRuleMatches(t *testing.T) {
+	subject := &rules.Subject{
+		Name:         "UBER *TRIP 1234",
+		Amount:       money.Amount(-2350),
+		AccountID:    "acc_1",
+		EnrollmentID: "enr_1",
+		Institution:  "Chase",
+		Date:         time.Date(2022, time.June, 15, 0, 0, 0, 0, time.UTC),
+	}
+	condition := func(field, op, value string) *rules.Condition {
+		return &rules.Condition{Field: field, Op: op, Value: value}
+	}
+
+	t.Run("should match each condition type", func(t *testing.T) {
+		t.Parallel()
+
+		matching := []*rules.Condition{
+			condition(rules.FieldName, rules.OpContains, "uber *trip"),
+			condition(rules.FieldName, rules.OpRegex, `^uber \*trip \d+$`),
+			condition(rules.FieldName, rules.OpEquals, "uber *trip 1234"),
+			condition(rules.FieldAmount, rules.OpGt, "20"),
+			condition(rules.FieldAmount, rules.OpEq, "23.50"),
+			condition(rules.FieldAccountID, rules.OpEquals, "acc_1"),
+			condition(rules.FieldEnrollmentID, rules.OpEquals, "enr_1"),
+			condition(rules.FieldInstitution, rules.OpContains, "chase"),
+			condition(rules.FieldDay, rules.OpGte, "15"),
+			condition(rules.FieldSign, rules.OpIs, rules.Negative),
+		}
+		for _, c := range matching {
+			rule := &rules.Rule{Match: rules.MatchAll, Conditions: []*rules.Condition{c}}
+			assert.True(t, rule.Matches(subject), c.Field+" "+c.Op+" "+c.Value)
+		}
+
+		failing := []*rules.Condition{
+			condition(rules.FieldName, rules.OpEquals, "uber"),
+			condition(rules.FieldAmount, rules.OpLt, "20"),
+			condition(rules.FieldAccountID, rules.OpEquals, "acc_2"),
+			condition(rules.FieldDay, rules.OpLt, "15"),
+			condition(rules.FieldSign, rules.OpIs, rules.Positive),
+		}
+		for _, c := range failing {
+			rule := &rules.Rule{Match: rules.MatchAll, Conditions: []*rules.Condition{c}}
+			assert.False(t, rule.Matches(subject), c.Field+" "+c.Op+" "+c.Value)
+		}
+	})
+
+	t.Run("should combine conditions with and/or", func(t *testing.T) {
+		t.Parallel()
+
+		conditions := []*rules.Condition{
+			condition(rules.FieldName, rules.OpContains, "uber"),
+			condition(rules.FieldAmount, rules.OpGt, "100"),
+		}
+		assert.False(t, (&rules.Rule{Match: rules.MatchAll, Conditions: conditions}).Matches(subject))
+		assert.True(t, (&rules.Rule{Match: rules.MatchAny, Conditions: conditions}).Matches(subject))
+	})
+
+	t.Run("should apply actions of matching rules in priority order", func(t *testing.T) {
+		t.Parallel()
+
+		ruleset := []*rules.Rule{
+			{
+				Conditions: []*rules.Condition{condition(rules.FieldName, rules.OpContains, "uber")},
+				Actions: []*rules.Action{
+					{Type: rules.SetCategory, Value: "transportation"},
+					{Type: rules.AddTag, Value: "rides"},
+					{Type: rules.Rename, Value: "Uber"},
+				},
+			},
+			{
+				Conditions: []*rules.Condition{condition(rules.FieldName, rules.OpContains, "lyft")},
+				Actions:    []*rules.Action{{Type: rules.SetCategory, Value: "vacation"}},
+			},
+			{
+				Conditions: []*rules.Condition{condition(rules.FieldInstitution, rules.OpEquals, "chase")},
+				Actions:    []*rules.Action{{Type: rules.AddTag, Value: "chase"}},
+			},
+		}
+		outcome := rules.Apply(ruleset, subject)
+		assert.Equal(t, "transportation", outcome.Category)
+		assert.Equal(t, "Uber", outcome.Name)
+		assert.Equal(t, []string{"rides", "chase"}, outcome.Tags)
+		assert.Equal(t, []int{0, 2}, outcome.Matched)
+		assert.False(t, outcome.Ignore)
+
+		// the first rule to categorize wins
+		ignoreRule := &rules.Rule{
+			Conditions: []*rules.Condition{condition(rules.FieldSign, rules.OpIs, rules.Negative)},
+			Actions:    []*rules.Action{{Type: rules.MarkIgnore}, {Type: rules.Rename, Value: "Ride"}},
+		}
+		outcome = rules.Apply(append(ruleset, ignoreRule), subject)
+		assert.False(t, outcome.Ignore)
+		assert.Equal(t, "transportation", outcome.Category)
+		assert.Equal(t, "Uber", outcome.Name)
+
+		outcome = rules.Apply(append([]*rules.Rule{ignoreRule}, ruleset...), subject)
+		assert.True(t, outcome.Ignore)
+		assert.Equal(t, "", outcome.Category)
+		assert.Equal(t, "Ride", outcome.Name)
+	})
+
+	t.Run("should stop after a matching rule with stop processing", func(t *testing.T) {
+		t.Parallel()
+
+		ruleset := []*rules.Rule{
+			{
+				Conditions:     []*rules.Condition{condition(rules.FieldName, rules.OpContains, "lyft")},
+				Actions:        []*rules.Action{{Type: rules.AddTag, Value: "lyft"}},
+				StopProcessing: true,
+			},
+			{
+				Conditions:     []*rules.Condition{condition(rules.FieldName, rules.OpContains, "uber")},
+				Actions:        []*rules.Action{{Type: rules.AddTag, Value: "rides"}},
+				StopProcessing: true,
+			},
+			{
+				Conditions: []*rules.Condition{condition(rules.FieldName, rules.OpContains, "trip")},
+				Actions:    []*rules.Action{{Type: rules.SetCategory, Value: "vacation"}},
+			},
+		}
+		outcome := rules.Apply(ruleset, subject)
+		assert.Equal(t, []string{"rides"}, outcome.Tags)
+		assert.Equal(t, "", outcome.Category)
+		assert.Equal(t, []int{1}, outcome.Matched)
+	})
+}
+
+func TestSuggestionSubstring(t *testing.T) {
+	t.Run("should keep the words before store numbers", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, "TRADER JOE'S", finances.SuggestionSubstring("TRADER JOE'S #123"))
+		assert.Equal(t, "TRADER JOE'S", finances.SuggestionSubstring("TRADER JOE'S 0456 SEATTLE WA"))
+		assert.Equal(t, "SQ *BLUE BOTTLE", finances.SuggestionSubstring("SQ *BLUE BOTTLE 88 -"))
+		assert.Equal(t, "Netflix", finances.SuggestionSubstring("Netflix"))
+	})
+
+	t.Run("should return nothing for names without a stable prefix", func(t *testing.T) {
+		t.Parallel()
+
+		assert.Equal(t, "", finances.SuggestionSubstring("#4411 ATM"))
+		assert.Equal(t, "", finances.SuggestionSubstring("AB 123"))
+		assert.Equal(t, "", finances.SuggestionSubstring(""))
+	})
+}
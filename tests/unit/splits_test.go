@@ -0,0 +1,44 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/money"
+)
+
+func TestSplitsMatch(t *testing.T) {
+	categories := finances.CategorySet{
+		"groceries": {Name: "groceries", Kind: finances.Expense},
+		"bills":     {Name: "bills", Kind: finances.Expense},
+		"vacation":  {Name: "vacation", Kind: finances.Expense},
+		"income":    {Name: "income", Kind: finances.Income},
+	}
+
+	t.Run("should require splits to add up to the transaction amount", func(t *testing.T) {
+		t.Parallel()
+
+		splits := []*finances.Split{
+			{Category: "groceries", Amount: 6000},
+			{Category: "bills", Amount: -4000},
+		}
+		assert.True(t, finances.SplitsMatch(money.Amount(-10000), splits, categories))
+		assert.False(t, finances.SplitsMatch(money.Amount(10000), splits, categories))
+		assert.False(t, finances.SplitsMatch(money.Amount(-9999), splits, categories))
+
+		splits = append(splits, &finances.Split{Category: "vacation", Amount: 0})
+		assert.False(t, finances.SplitsMatch(money.Amount(-10000), splits, categories))
+	})
+
+	t.Run("should net income and expense splits", func(t *testing.T) {
+		t.Parallel()
+
+		splits := []*finances.Split{
+			{Category: "income", Amount: 3000},
+			{Category: "groceries", Amount: 7000},
+		}
+		assert.False(t, finances.SplitsMatch(money.Amount(-10000), splits, categories))
+		assert.True(t, finances.SplitsMatch(money.Amount(-4000), splits, categories))
+	})
+}
@@ -0,0 +1,19 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	t.Run("should lowercase, trim and dedupe tags", func(t *testing.T) {
+		t.Parallel()
+
+		tags := finances.NormalizeTags([]string{" Trip-Japan ", "tax  deductible", "", "trip-japan", strings.Repeat("x", 51)})
+		assert.Equal(t, []string{"trip-japan", "tax deductible"}, tags)
+		assert.Equal(t, []string{}, finances.NormalizeTags(nil))
+	})
+}
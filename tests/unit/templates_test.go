@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestSchedule(t *testing.T) {
+	t.Run("should fall on the day of the month, clamped to short months", func(t *testing.T) {
+		t.Parallel()
+
+		schedule := &finances.Schedule{Frequency: finances.FrequencyMonthly, DayOfMonth: 31, StartDate: date(2022, time.January, 10)}
+		assert.Nil(t, schedule.Validate())
+		assert.Equal(t, date(2022, time.January, 31), schedule.Occurrence(0))
+		assert.Equal(t, date(2022, time.February, 28), schedule.Occurrence(1))
+		assert.Equal(t, date(2022, time.March, 31), schedule.Occurrence(2))
+	})
+
+	t.Run("should start the month after when the day has passed", func(t *testing.T) {
+		t.Parallel()
+
+		schedule := &finances.Schedule{Frequency: finances.FrequencyMonthly, Interval: 2, DayOfMonth: 1, StartDate: date(2022, time.November, 15)}
+		assert.Nil(t, schedule.Validate())
+		assert.Equal(t, date(2022, time.December, 1), schedule.Occurrence(0))
+		assert.Equal(t, date(2023, time.February, 1), schedule.Occurrence(1))
+	})
+
+	t.Run("should repeat every n weeks", func(t *testing.T) {
+		t.Parallel()
+
+		schedule := &finances.Schedule{Frequency: finances.FrequencyWeekly, Interval: 2, StartDate: date(2022, time.June, 3)}
+		assert.Nil(t, schedule.Validate())
+		assert.Equal(t, date(2022, time.June, 3), schedule.Occurrence(0))
+		assert.Equal(t, date(2022, time.June, 17), schedule.Occurrence(1))
+		assert.Equal(t, date(2022, time.July, 1), schedule.Occurrence(2))
+	})
+
+	t.Run("should stop at the end date", func(t *testing.T) {
+		t.Parallel()
+
+		end := date(2022, time.March, 1)
+		schedule := &finances.Schedule{Frequency: finances.FrequencyMonthly, StartDate: date(2022, time.January, 1), EndDate: &end}
+		assert.Nil(t, schedule.Validate())
+		assert.Equal(t, 1, schedule.DayOfMonth)
+		assert.True(t, schedule.Includes(2))
+		assert.False(t, schedule.Includes(3))
+	})
+
+	t.Run("should reject invalid schedules", func(t *testing.T) {
+		t.Parallel()
+
+		end := date(2021, time.December, 1)
+		for _, schedule := range []*finances.Schedule{
+			{Frequency: "daily", StartDate: date(2022, time.January, 1)},
+			{Frequency: finances.FrequencyMonthly},
+			{Frequency: finances.FrequencyMonthly, DayOfMonth: 32, StartDate: date(2022, time.January, 1)},
+			{Frequency: finances.FrequencyWeekly, Interval: -1, StartDate: date(2022, time.January, 1)},
+			{Frequency: finances.FrequencyWeekly, StartDate: date(2022, time.January, 1), EndDate: &end},
+		} {
+			assert.Equal(t, finances.ErrInvalidSchedule, schedule.Validate())
+		}
+	})
+}
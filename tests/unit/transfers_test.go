@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tony-tvu/goexpense/finances"
+	"github.com/tony-tvu/goexpense/money"
+)
+
+func TestMatchTransfers(t *testing.T) {
+	day := time.Date(2022, time.March, 10, 0, 0, 0, 0, time.UTC)
+	transaction := func(id, account string, amount money.Amount, days int) *finances.Transaction {
+		return &finances.Transaction{TransactionID: id, AccountID: account, Amount: amount, Currency: "USD", Date: day.AddDate(0, 0, days)}
+	}
+
+	t.Run("should pair opposite amounts across accounts within the window", func(t *testing.T) {
+		t.Parallel()
+
+		pairs := finances.MatchTransfers([]*finances.Transaction{
+			transaction("payment", "checking", -50000, 0),
+			transaction("far", "credit", 50000, 3),
+			transaction("near", "credit", 50000, 1),
+			transaction("late", "savings", 50000, 5),
+		}, nil)
+		assert.Len(t, pairs, 1)
+		assert.Equal(t, "payment", pairs[0][0].TransactionID)
+		assert.Equal(t, "near", pairs[0][1].TransactionID)
+	})
+
+	t.Run("should not pair within one account, across currencies or different amounts", func(t *testing.T) {
+		t.Parallel()
+
+		euro := transaction("euro", "savings", 50000, 0)
+		euro.Currency = "EUR"
+		pairs := finances.MatchTransfers([]*finances.Transaction{
+			transaction("out", "checking", -50000, 0),
+			transaction("same", "checking", 50000, 0),
+			euro,
+			transaction("less", "credit", 49999, 0),
+		}, nil)
+		assert.Len(t, pairs, 0)
+	})
+
+	t.Run("should skip unlinked pairs and use each inflow once", func(t *testing.T) {
+		t.Parallel()
+
+		pairs := finances.MatchTransfers([]*finances.Transaction{
+			transaction("a", "checking", -1000, 0),
+			transaction("b", "checking", -1000, 1),
+			transaction("c", "credit", 1000, 1),
+		}, map[string]bool{finances.TransferKey("a", "c"): true})
+		assert.Len(t, pairs, 1)
+		assert.Equal(t, "b", pairs[0][0].TransactionID)
+	})
+
+	t.Run("should not pair refunds, reimbursements or transactions categorized by hand", func(t *testing.T) {
+		t.Parallel()
+
+		refund := transaction("refund", "credit", 1000, 0)
+		refund.RefundOf = "purchase"
+		payment := transaction("payment", "savings", 1000, 0)
+		payment.Reimburses = []string{"hotel"}
+		locked := transaction("locked", "brokerage", 1000, 0)
+		locked.Locked = []string{finances.LockCategory}
+		pairs := finances.MatchTransfers([]*finances.Transaction{
+			transaction("out", "checking", -1000, 0),
+			refund,
+			payment,
+			locked,
+		}, nil)
+		assert.Len(t, pairs, 0)
+	})
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/go-playground/validator"
 	"github.com/tony-tvu/goexpense/auth"
 	"github.com/tony-tvu/goexpense/db"
+	"github.com/tony-tvu/goexpense/money"
 	"github.com/tony-tvu/goexpense/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"golang.org/x/crypto/bcrypt"
@@ -176,12 +177,65 @@ func (h *Handler) GetUserInfo(c *gin.Context) {
 		return
 	}
 
+	baseCurrency := u.BaseCurrency
+	if baseCurrency == "" {
+		baseCurrency = money.DefaultCurrency
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"username": u.Username,
-		"email":    u.Email,
+		"username":      u.Username,
+		"email":         u.Email,
+		"base_currency": baseCurrency,
 	})
 }
 
+func (h *Handler) UpdateUserInfo(c *gin.Context) {
+	ctx := c.Request.Context()
+	defer c.Request.Body.Close()
+
+	userID, err := auth.AuthorizeUser(c, h.Db)
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	type Input struct {
+		BaseCurrency string `json:"base_currency" validate:"required,len=3,alpha"`
+	}
+
+	var input *Input
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	err = json.Unmarshal(bodyBytes, &input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	err = v.Struct(input)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	_, err = h.Db.Users.UpdateOne(
+		ctx,
+		bson.M{"_id": *userID},
+		bson.M{"$set": bson.M{
+			"base_currency": strings.ToUpper(input.BaseCurrency),
+			"updated_at":    time.Now(),
+		}},
+	)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+}
+
 func (h *Handler) RegisterUser(c *gin.Context) {
 	ctx := c.Request.Context()
 	defer c.Request.Body.Close()
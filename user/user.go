@@ -12,6 +12,9 @@ type User struct {
 	Email    string             `json:"email" bson:"email"`
 	Password string
 
+	// currency totals are converted into
+	BaseCurrency string `json:"base_currency" bson:"base_currency"`
+
 	CreatedAt time.Time `json:"created_at" bson:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
 }
@@ -32,3 +32,9 @@ func GetMonth(month int) time.Month {
 		return time.January
 	}
 }
+
+// Returns the first instant of the given month and the first instant of the following month
+func MonthRange(month, year int) (time.Time, time.Time) {
+	from := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	return from, from.AddDate(0, 1, 0)
+}
@@ -24,6 +24,8 @@ export default function CreateRuleBtn({ onSuccess, forceRefresh, icon }) {
   const [loading, setLoading] = useState(false)
   const [substring, setSubstring] = useState(null)
   const [category, setCategory] = useState('bills')
+  const [scope, setScope] = useState('all')
+  const [from, setFrom] = useState('')
   const { isOpen, onOpen, onClose } = useDisclosure()
   const cancelRef = React.useRef()
   const navigate = useNavigate()
@@ -41,6 +43,17 @@ export default function CreateRuleBtn({ onSuccess, forceRefresh, icon }) {
       })
       setLoading(false)
       return
+    } else if (scope === 'from_date' && !from) {
+      toast({
+        title: 'Error',
+        description: 'Pick the date to apply the rule from',
+        status: 'error',
+        position: 'top-right',
+        duration: 5000,
+        isClosable: true,
+      })
+      setLoading(false)
+      return
     } else {
       setLoading(true)
       await fetch(`${process.env.REACT_APP_API_URL}/rules`, {
@@ -50,8 +63,11 @@ export default function CreateRuleBtn({ onSuccess, forceRefresh, icon }) {
           'Content-Type': 'application/json',
         },
         body: JSON.stringify({
-          substring: substring,
-          category: category,
+          match: 'all',
+          conditions: [{ field: 'name', op: 'contains', value: substring }],
+          actions: [{ type: 'set_category', value: category }],
+          scope: scope,
+          from: scope === 'from_date' ? from : undefined,
         }),
       })
         .then((res) => {
@@ -103,7 +119,7 @@ export default function CreateRuleBtn({ onSuccess, forceRefresh, icon }) {
 
             <AlertDialogBody>
               <FormControl>
-                <FormLabel>Substring (case-sensitive)</FormLabel>
+                <FormLabel>Name contains</FormLabel>
                 <Input
                   onChange={(event) => setSubstring(event.target.value)}
                   mb={3}
@@ -125,6 +141,22 @@ export default function CreateRuleBtn({ onSuccess, forceRefresh, icon }) {
                   <option value={'vacation'}>Vacation</option>
                   <option value={'uncategorized'}>Uncategorized</option>
                 </Select>
+                <FormLabel mt={3}>Apply to</FormLabel>
+                <Select
+                  defaultValue={scope}
+                  onChange={(event) => setScope(event.target.value)}
+                >
+                  <option value={'all'}>All transactions</option>
+                  <option value={'from_date'}>Transactions from a date</option>
+                  <option value={'future'}>New transactions only</option>
+                </Select>
+                {scope === 'from_date' && (
+                  <Input
+                    type="date"
+                    onChange={(event) => setFrom(event.target.value)}
+                    mt={3}
+                  />
+                )}
               </FormControl>
             </AlertDialogBody>
             <AlertDialogFooter>
@@ -61,8 +61,7 @@ export default function DeleteRuleBtn({ rule, onSuccess }) {
             </AlertDialogHeader>
 
             <AlertDialogBody>
-              Are you sure you want to remove "{rule.substring}"={rule.category}
-              ?
+              Are you sure you want to remove this rule?
             </AlertDialogBody>
 
             <AlertDialogFooter>
@@ -15,6 +15,51 @@ import { IoIosWarning } from 'react-icons/io'
 import logger from '../logger'
 import DeleteRuleBtn from '../components/DeleteRuleBtn'
 
+const opLabels = {
+  contains: 'contains',
+  equals: 'is',
+  regex: 'matches',
+  eq: '=',
+  gt: '>',
+  gte: '>=',
+  lt: '<',
+  lte: '<=',
+  is: 'is',
+}
+
+function capitalize(text) {
+  if (!text) return ''
+  return text.charAt(0).toUpperCase() + text.slice(1)
+}
+
+function describeConditions(rule) {
+  const conditions = (rule.conditions || []).map(
+    (condition) =>
+      `${capitalize(condition.field.replace('_', ' '))} ${
+        opLabels[condition.op] || condition.op
+      } "${condition.value}"`
+  )
+  return conditions.join(rule.match === 'any' ? ' or ' : ' and ')
+}
+
+function describeActions(rule) {
+  const actions = (rule.actions || []).map((action) => {
+    switch (action.type) {
+      case 'set_category':
+        return `Category = ${capitalize(action.value)}`
+      case 'add_tag':
+        return `Tag #${action.value}`
+      case 'rename':
+        return `Rename to "${action.value}"`
+      case 'ignore':
+        return 'Ignore'
+      default:
+        return action.type
+    }
+  })
+  return actions.join(', ')
+}
+
 export default function Rules() {
   const [data, setData] = useState([])
   const [loading, setLoading] = useState(true)
@@ -68,10 +113,12 @@ export default function Rules() {
           p={3}
           mb={5}
         >
-          <Text fontSize="xl" as="b">
-            "{rule.substring}" ={' '}
-            {rule.category.charAt(0).toUpperCase() + rule.category.slice(1)}
-          </Text>
+          <VStack alignItems="start" spacing={1}>
+            <Text fontSize="xl" as="b">
+              {describeConditions(rule)}
+            </Text>
+            <Text>{describeActions(rule)}</Text>
+          </VStack>
           <Spacer />
 
           <DeleteRuleBtn rule={rule} onSuccess={onSuccess} />
@@ -93,12 +140,15 @@ export default function Rules() {
         <VStack alignItems="start" width="100%" mb={10}>
           <Text fontSize="md" pl={1} mb={5}>
             Rules provide a quick and easy way to categorize transactions. When
-            creating a rule, you can specify a substring and the category that
-            it belongs to. For instance, a substring of "Bananas Market" and
-            category of "groceries" will make all transactions with that
-            substring change to the "groceries" category. More specifically, a
-            transaction with the name "Super Fruits and Bananas Market" will be
-            categorized as "groceries".
+            creating a rule, you can specify a substring of the transaction
+            name, the category that it belongs to and which transactions it
+            applies to. For instance, a substring of "Bananas Market" and
+            category of "groceries" applied to all transactions will make all
+            transactions with that substring change to the "groceries"
+            category. More specifically, a transaction with the name "Super
+            Fruits and Bananas Market" will be categorized as "groceries".
+            Rules applied to new transactions only leave your existing
+            transactions as they are.
           </Text>
           <HStack>
             <IoIosWarning size={'60px'} color={'red'} />